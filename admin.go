@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+)
+
+// serveAdmin starts the admin HTTP server on addr. POST /shutdown cancels
+// the run the same way SIGTERM does: the loop stops before its next page and
+// the deferred cleanup runs. When token is set, requests must send it as
+// "Authorization: Bearer <token>".
+func serveAdmin(addr, token string, cancel context.CancelFunc, prog *progress) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/shutdown", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		log.Printf("Shutdown requested by %s\n", r.RemoteAddr)
+		cancel()
+		fmt.Fprintf(w, "%s; skipped %d; shutting down\n", prog.summary(), skippedRecords.Load())
+	})
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("admin server: %w", err)
+	}
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("Admin server stopped: %v\n", err)
+		}
+	}()
+	log.Printf("Admin server listening on %s\n", ln.Addr())
+	return srv, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// analyzeTables returns the tables -analyze processes: the ones the load
+// wrote to, and with -shards every shard, since ANALYZE of an inheritance
+// parent does not update its children's statistics. Partitions are covered
+// by ANALYZE of their parent.
+func analyzeTables() []string {
+	tables := loadTables()
+	for k := 0; k < cfg.Shards; k++ {
+		tables = append(tables, shardName(k))
+	}
+	return tables
+}
+
+// analyze refreshes the planner statistics of the loaded tables, one
+// statement per table, with VACUUM too when vacuum is set. VACUUM cannot
+// run inside a transaction, so each statement runs on its own.
+func analyze(ctx context.Context, db *sql.DB, vacuum bool) error {
+	command := "ANALYZE"
+	if vacuum {
+		command = "VACUUM (ANALYZE)"
+	}
+	for _, table := range analyzeTables() {
+		start := time.Now()
+		if _, err := db.ExecContext(ctx, command+" "+pq.QuoteIdentifier(table)); err != nil {
+			return fmt.Errorf("%s %s: %w", command, table, err)
+		}
+		log.Printf("%s %s took %s\n", command, table, time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
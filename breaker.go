@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// circuit states, also sent as the circuit_state StatsD gauge.
+const (
+	circuitClosed   = 0
+	circuitOpen     = 1
+	circuitHalfOpen = 2
+)
+
+var circuitNames = map[int]string{circuitClosed: "closed", circuitOpen: "open", circuitHalfOpen: "half-open"}
+
+// breaker stops fetching for a cooldown once the API has failed threshold
+// times within window, instead of retrying into an outage. After the
+// cooldown one trial fetch is let through: success closes the circuit and
+// failure opens it again. A nil breaker always allows fetching.
+type breaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	now       func() time.Time // for tests
+
+	state    int
+	failures []time.Time // within window, oldest first
+	openedAt time.Time
+}
+
+func newBreaker(threshold int, window, cooldown time.Duration) *breaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &breaker{threshold: threshold, window: window, cooldown: cooldown, now: time.Now}
+}
+
+// wait returns how long the caller must hold off before fetching. Once the
+// cooldown has passed the circuit is half-open and wait returns 0.
+func (b *breaker) wait() time.Duration {
+	if b == nil || b.state != circuitOpen {
+		return 0
+	}
+	if left := b.cooldown - b.now().Sub(b.openedAt); left > 0 {
+		return left
+	}
+	b.setState(circuitHalfOpen)
+	return 0
+}
+
+func (b *breaker) success() {
+	if b == nil {
+		return
+	}
+	if b.state == circuitHalfOpen {
+		b.setState(circuitClosed)
+	}
+}
+
+func (b *breaker) failure() {
+	if b == nil {
+		return
+	}
+	now := b.now()
+	if b.state == circuitHalfOpen {
+		b.open(now)
+		return
+	}
+	b.failures = append(b.failures, now)
+	for len(b.failures) > 0 && now.Sub(b.failures[0]) > b.window {
+		b.failures = b.failures[1:]
+	}
+	if len(b.failures) >= b.threshold {
+		b.open(now)
+	}
+}
+
+func (b *breaker) open(now time.Time) {
+	b.openedAt = now
+	b.failures = b.failures[:0]
+	b.setState(circuitOpen)
+	stats.count("circuit_opened", 1)
+}
+
+func (b *breaker) setState(state int) {
+	log.Printf("Circuit breaker %s\n", circuitNames[state])
+	b.state = state
+	stats.gauge("circuit_state", state)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock is a breaker clock moved on by hand.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestBreaker(threshold int, window, cooldown time.Duration) (*breaker, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)}
+	b := newBreaker(threshold, window, cooldown)
+	b.now = clock.now
+	return b, clock
+}
+
+func TestBreakerOpensAtThreshold(t *testing.T) {
+	b, clock := newTestBreaker(3, time.Minute, 30*time.Second)
+	b.failure()
+	clock.advance(10 * time.Second)
+	b.failure()
+	if b.state != circuitClosed || b.wait() != 0 {
+		t.Fatalf("circuit %s after 2 of 3 failures, want closed", circuitNames[b.state])
+	}
+	clock.advance(10 * time.Second)
+	b.failure()
+	if b.state != circuitOpen {
+		t.Fatalf("circuit %s after 3 failures, want open", circuitNames[b.state])
+	}
+	if got := b.wait(); got != 30*time.Second {
+		t.Errorf("wait() = %s just after opening, want the cooldown 30s", got)
+	}
+	clock.advance(20 * time.Second)
+	if got := b.wait(); got != 10*time.Second {
+		t.Errorf("wait() = %s 20s into the cooldown, want 10s", got)
+	}
+}
+
+func TestBreakerForgetsFailuresOutsideWindow(t *testing.T) {
+	b, clock := newTestBreaker(3, time.Minute, 30*time.Second)
+	b.failure()
+	b.failure()
+	clock.advance(2 * time.Minute)
+	b.failure()
+	if b.state != circuitClosed {
+		t.Errorf("circuit %s with the first failures out of the window, want closed", circuitNames[b.state])
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	tests := []struct {
+		name  string
+		trial func(b *breaker)
+		want  int
+	}{
+		{"trial succeeds", (*breaker).success, circuitClosed},
+		{"trial fails", (*breaker).failure, circuitOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, clock := newTestBreaker(2, time.Minute, 30*time.Second)
+			b.failure()
+			b.failure()
+			clock.advance(30 * time.Second)
+			if got := b.wait(); got != 0 || b.state != circuitHalfOpen {
+				t.Fatalf("after the cooldown wait() = %s, circuit %s; want 0, half-open", got, circuitNames[b.state])
+			}
+			tt.trial(b)
+			if b.state != tt.want {
+				t.Fatalf("circuit %s after the trial, want %s", circuitNames[b.state], circuitNames[tt.want])
+			}
+			if tt.want == circuitOpen {
+				// Reopened with a fresh cooldown from the failed trial.
+				if got := b.wait(); got != 30*time.Second {
+					t.Errorf("wait() = %s after reopening, want 30s", got)
+				}
+				return
+			}
+			// Closed again, it takes the full threshold to reopen.
+			b.failure()
+			if b.state != circuitClosed {
+				t.Errorf("circuit %s after one failure, want closed", circuitNames[b.state])
+			}
+		})
+	}
+}
+
+func TestNilBreaker(t *testing.T) {
+	b := newBreaker(0, time.Minute, time.Minute)
+	b.failure()
+	b.success()
+	if got := b.wait(); got != 0 {
+		t.Errorf("disabled breaker wait() = %s, want 0", got)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxRedirects is how many redirects an API request follows, as many as
+// http.DefaultClient does.
+const maxRedirects = 10
+
+// apiClient sends every request to the Socrata API. parseFlags gives it the
+// transport from -max-idle-conns-per-host, -idle-conn-timeout and -http2.
+var apiClient = &http.Client{CheckRedirect: checkRedirect}
+
+// newAPITransport tunes a copy of http.DefaultTransport for the API.
+//
+// Pages are fetched one at a time, and -count-only, -distinct, -preflight
+// and the sample count query run before the fetch loop, so a run has at
+// most one API request in flight; there is no -concurrency flag. A single
+// idle connection is therefore enough to reuse the TLS session from page to
+// page, as long as -idle-conn-timeout outlasts the gap between pages,
+// including retry backoff and time spent waiting on a full -queue-size. The
+// higher per-host default leaves room for overlapping requests, such as a
+// page retried while a timed-out one is still being torn down.
+//
+// With -http2 (the default) requests to an HTTPS endpoint that offers HTTP/2
+// share one multiplexed connection and -max-idle-conns-per-host has little
+// effect. -http2=false forces HTTP/1.1, for proxies that mishandle HTTP/2.
+func newAPITransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	t.IdleConnTimeout = cfg.IdleConnTimeout
+	if !cfg.HTTP2 {
+		t.ForceAttemptHTTP2 = false
+		// A non-nil, empty map turns off the transport's HTTP/2 support.
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t
+}
+
+// tokenParam is the query parameter -token-as-param sends the app token in.
+const tokenParam = "$$app_token"
+
+// apiGet requests u from the API with the -app-token. Errors quoting the URL
+// have the token masked, as they end up in the log.
+func apiGet(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	setAPIHeaders(req)
+	resp, err := apiClient.Do(req)
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		ue.URL = maskToken(ue.URL)
+	}
+	return resp, err
+}
+
+// setAPIHeaders adds the app token to req, in the X-App-Token header or,
+// with -token-as-param, for proxies that strip the header, in the query.
+func setAPIHeaders(req *http.Request) {
+	switch {
+	case cfg.AppToken == "":
+	case cfg.TokenAsParam:
+		req.URL.RawQuery = withTokenParam(req.URL.RawQuery)
+	default:
+		req.Header.Set("X-App-Token", cfg.AppToken)
+	}
+}
+
+// withTokenParam appends the app token to query, unless it is there
+// already, as after a redirect that kept the query string.
+func withTokenParam(query string) string {
+	if q, err := url.ParseQuery(query); err == nil && q.Has(tokenParam) {
+		return query
+	}
+	if query != "" {
+		query += "&"
+	}
+	return query + url.QueryEscape(tokenParam) + "=" + url.QueryEscape(cfg.AppToken)
+}
+
+// maskToken hides a -token-as-param app token in s, a URL or a message
+// quoting one.
+func maskToken(s string) string {
+	if !cfg.TokenAsParam || cfg.AppToken == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, url.QueryEscape(cfg.AppToken), "xxxxx")
+}
+
+// printURL prints the URL of the first page as it is requested, with the
+// app token masked.
+func printURL(w io.Writer) error {
+	req, err := http.NewRequest(http.MethodGet, pageURL(0, cursor{}), nil)
+	if err != nil {
+		return err
+	}
+	setAPIHeaders(req)
+	_, err = fmt.Fprintln(w, maskToken(req.URL.String()))
+	return err
+}
+
+// checkRedirect follows a moved endpoint and sends the app token again, but
+// only to the host it was meant for and never over plain HTTP after HTTPS.
+// A redirect elsewhere fails the request instead of going on without the
+// token and failing later with 401 or throttled anonymous access.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	first := via[0]
+	logger(req.Context()).Warn("API request redirected", "status", req.Response.StatusCode, "to", maskToken(req.URL.Redacted()))
+	if cfg.AppToken == "" {
+		return nil
+	}
+	if req.URL.Host != first.URL.Host {
+		return fmt.Errorf("redirected from %s to %s, which is not sent the app token; set -dataset-url to the new location", first.URL.Host, req.URL.Host)
+	}
+	if first.URL.Scheme == "https" && req.URL.Scheme != "https" {
+		return errors.New("redirected from HTTPS to HTTP, which would send the app token in the clear")
+	}
+	setAPIHeaders(req)
+	return nil
+}
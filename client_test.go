@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestRedirects requests moved endpoints with an app token: a redirect on
+// the same host is followed with the token sent again, while one to another
+// host, and a chain longer than maxRedirects, fail the request.
+func TestRedirects(t *testing.T) {
+	var elsewhereHits atomic.Int32
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		elsewhereHits.Add(1)
+	}))
+	t.Cleanup(elsewhere.Close)
+
+	var loops atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new?"+r.URL.RawQuery, http.StatusMovedPermanently)
+		case "/new":
+			if r.Header.Get("X-App-Token") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`[]`))
+		case "/away":
+			http.Redirect(w, r, elsewhere.URL+"/resource.json", http.StatusFound)
+		case "/loop":
+			loops.Add(1)
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	withConfig(t, func(c *config) {
+		c.AppToken = "secret"
+		c.TokenAsParam = false
+	})
+
+	resp, err := apiGet(context.Background(), srv.URL+"/old?$limit=100")
+	if err != nil {
+		t.Fatalf("same-host redirect: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/new" {
+		t.Errorf("same-host redirect ended at %s with %s, want /new with 200 OK", resp.Request.URL.Path, resp.Status)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/away", "which is not sent the app token"},
+		{"/loop", "stopped after 10 redirects"},
+	}
+	for _, tt := range tests {
+		resp, err := apiGet(context.Background(), srv.URL+tt.path)
+		if err == nil {
+			resp.Body.Close()
+			t.Errorf("%s: request succeeded, want %q", tt.path, tt.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.path, err, tt.want)
+		}
+	}
+	if n := elsewhereHits.Load(); n != 0 {
+		t.Errorf("the other host was requested %d times", n)
+	}
+	if n := loops.Load(); n != maxRedirects {
+		t.Errorf("the redirect loop was requested %d times, want %d", n, maxRedirects)
+	}
+}
+
+// TestTokenMasking sends the app token as a query parameter to a server
+// that redirects and then keeps failing: neither the -print-url output, the
+// log nor the errors of the count query and the page fetch show the token.
+func TestTokenMasking(t *testing.T) {
+	const token = "s3cr/et+token"
+	var tokens atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get(tokenParam) == token {
+			tokens.Add(1)
+		}
+		if r.URL.Path == "/resource.json" {
+			http.Redirect(w, r, "/moved.json?"+r.URL.RawQuery, http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(srv.Close)
+	withConfig(t, func(c *config) {
+		c.DatasetURL = srv.URL + "/resource.json"
+		c.DB = false
+		c.SummaryInterval = 0
+		c.AppToken = token
+		c.TokenAsParam = true
+		c.MaxConsecutiveErrors = 1
+		c.CountAttempts = 1
+		c.RetryBase, c.RetryMax = 0, 0
+	})
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	var printed bytes.Buffer
+	if err := printURL(&printed); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(printed.String(), url.QueryEscape(tokenParam)+"=xxxxx") {
+		t.Errorf("-print-url printed %q, want the token parameter masked", printed.String())
+	}
+
+	var errs []string
+	if _, err := fetchTotalCount(context.Background()); err == nil {
+		t.Error("count query succeeded against a failing server")
+	} else {
+		errs = append(errs, err.Error())
+	}
+	if _, err := runFetch(t); err == nil {
+		t.Error("fetch succeeded against a failing server")
+	} else {
+		errs = append(errs, err.Error())
+	}
+	// A request to a closed server fails with a *url.Error quoting the URL.
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	if _, err := apiGet(context.Background(), closed.URL+"/resource.json?"+withTokenParam("")); err == nil {
+		t.Error("request to a closed server succeeded")
+	} else {
+		errs = append(errs, err.Error())
+	}
+
+	if tokens.Load() == 0 {
+		t.Fatal("the server was never sent the token")
+	}
+	for name, s := range map[string]string{"-print-url": printed.String(), "log": logged.String(), "errors": strings.Join(errs, "\n")} {
+		if strings.Contains(s, token) || strings.Contains(s, url.QueryEscape(token)) {
+			t.Errorf("%s shows the app token:\n%s", name, s)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// ANSI escapes for -color.
+const (
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiReset  = "\x1b[0m"
+)
+
+// colorEnabled reports whether output to f is colored. With -color auto
+// that is when f is a terminal, NO_COLOR is unset and TERM is not dumb, so
+// piped and redirected output stays plain.
+func colorEnabled(f *os.File) bool {
+	switch cfg.Color {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// useColor reports whether output to w is colored. Only files can be
+// terminals.
+func useColor(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && colorEnabled(f)
+}
+
+// newTable returns a table writing to w with header, in bold when w is
+// colored.
+func newTable(w io.Writer, header []string) *tablewriter.Table {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader(header)
+	if useColor(w) {
+		colors := make([]tablewriter.Colors, len(header))
+		for i := range colors {
+			colors[i] = tablewriter.Colors{tablewriter.Bold}
+		}
+		table.SetHeaderColor(colors...)
+	}
+	return table
+}
+
+// appendResult adds a row to a table of checks, red when the check failed
+// and the table is colored.
+func appendResult(table *tablewriter.Table, w io.Writer, row []string, failed bool) {
+	if !failed || !useColor(w) {
+		table.Append(row)
+		return
+	}
+	colors := make([]tablewriter.Colors, len(row))
+	for i := range colors {
+		colors[i] = tablewriter.Colors{tablewriter.FgRedColor}
+	}
+	table.Rich(row, colors)
+}
+
+// colorLogWriter colors log lines by level: errors red, and warnings,
+// including log lines starting "WARNING:", yellow. slog writes each record
+// with a single Write, so every call is one whole line.
+type colorLogWriter struct {
+	w io.Writer
+}
+
+func (c colorLogWriter) Write(p []byte) (int, error) {
+	color := ""
+	switch {
+	case bytes.Contains(p, []byte("level=ERROR")):
+		color = ansiRed
+	case bytes.Contains(p, []byte("level=WARN")), bytes.Contains(p, []byte(`msg="WARNING:`)):
+		color = ansiYellow
+	}
+	if color == "" {
+		return c.w.Write(p)
+	}
+	line := bytes.TrimSuffix(p, []byte("\n"))
+	if _, err := io.WriteString(c.w, color+string(line)+ansiReset+"\n"); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import "fmt"
+
+// ColumnSpec is one taxi_trips column written by the insert: its name and
+// how its value is taken from a trip. Every column, copied or derived, is
+// one entry of tripColumnSpecs, and the insert's column list and values are
+// both built from it, so they cannot get out of step.
+type ColumnSpec struct {
+	name  string
+	kind  columnKind
+	value func(r columnRow) any
+}
+
+// columnKind groups the columns by where their value comes from, which also
+// decides whether a run writes them.
+type columnKind int
+
+const (
+	columnSource     columnKind = iota // copied from the API record
+	columnDerived                      // computed at insert time from the source columns
+	columnProvenance                   // where and when the trip was fetched, with -with-provenance
+	columnRaw                          // the source record, with -store-raw
+)
+
+func (k columnKind) enabled() bool {
+	switch k {
+	case columnProvenance:
+		return cfg.WithProvenance
+	case columnRaw:
+		return cfg.StoreRaw
+	}
+	return true
+}
+
+// columnRow is what the values of one row are taken from. source holds the
+// values of the source columns, for the derived ones.
+type columnRow struct {
+	trip   data_fetched
+	batch  batch
+	source []any
+}
+
+// tripColumnSpecs are the taxi_trips columns in insert order. The source
+// columns come first, as columnValues relies on.
+var tripColumnSpecs = []ColumnSpec{
+	{"trip_id", columnSource, func(r columnRow) any { return r.trip.TripID }},
+	{"taxi_id", columnSource, func(r columnRow) any { return r.trip.TaxiID }},
+	{"trip_start_timestamp", columnSource, func(r columnRow) any { return nullTime(r.trip.TripStartTimestamp.Time) }},
+	{"trip_end_timestamp", columnSource, func(r columnRow) any { return nullTime(r.trip.TripEndTimestamp.Time) }},
+	{"trip_seconds", columnSource, func(r columnRow) any { return r.trip.TripSeconds.Int }},
+	{"trip_miles", columnSource, func(r columnRow) any { return r.trip.TripMiles.Float64 }},
+	{"pickup_census_tract", columnSource, func(r columnRow) any { return r.trip.PickupCensusTract }},
+	{"dropoff_census_tract", columnSource, func(r columnRow) any { return r.trip.DropoffCensusTract }},
+	{"pickup_community_area", columnSource, func(r columnRow) any { return r.trip.PickupCommunityArea.Int }},
+	{"dropoff_community_area", columnSource, func(r columnRow) any { return r.trip.DropoffCommunityArea.Int }},
+	{"fare", columnSource, func(r columnRow) any { return r.trip.Fare.Float64 }},
+	{"tips", columnSource, func(r columnRow) any { return r.trip.Tips.Float64 }},
+	{"tolls", columnSource, func(r columnRow) any { return r.trip.Tolls.Float64 }},
+	{"extras", columnSource, func(r columnRow) any { return r.trip.Extras.Float64 }},
+	{"trip_total", columnSource, func(r columnRow) any { return r.trip.TripTotal.Float64 }},
+	{"payment_type", columnSource, func(r columnRow) any { return r.trip.PaymentType }},
+	{"company", columnSource, func(r columnRow) any { return r.trip.Company }},
+	{"pickup_centroid_latitude", columnSource, func(r columnRow) any { return r.trip.PickupCentroidLatitude.Float64 }},
+	{"pickup_centroid_longitude", columnSource, func(r columnRow) any { return r.trip.PickupCentroidLongitude.Float64 }},
+	{"pickup_centroid_location", columnSource, func(r columnRow) any { return r.trip.PickupCentroidLocation.wkt() }},
+	{"dropoff_centroid_latitude", columnSource, func(r columnRow) any { return r.trip.DropoffCentroidLatitude.Float64 }},
+	{"dropoff_centroid_longitude", columnSource, func(r columnRow) any { return r.trip.DropoffCentroidLongitude.Float64 }},
+	{"dropoff_centroid_location", columnSource, func(r columnRow) any { return r.trip.DropoffCentroidLocation.wkt() }},
+
+	{"pickup_geohash", columnDerived, pickupGeohash},
+	{"row_hash", columnDerived, func(r columnRow) any { return rowHash(r.source) }},
+
+	// fetched_at is stored in UTC.
+	{"fetched_at", columnProvenance, func(r columnRow) any { return nullTime(r.batch.fetchedAt.UTC()) }},
+	{"source_url", columnProvenance, func(r columnRow) any { return r.batch.source }},
+
+	{rawColumn, columnRaw, func(r columnRow) any { return rawValue(r.trip) }},
+}
+
+// Parts of the registry, for the queries and file formats built on them.
+var (
+	sourceSpecs = columnSpecs(columnSource)
+	tripSpecs   = columnSpecs(columnSource, columnDerived)
+
+	// sourceColumns are the columns copied from the API record.
+	sourceColumns = columnNames(sourceSpecs)
+	// tripColumns adds the columns derived at insert time to sourceColumns.
+	tripColumns = columnNames(tripSpecs)
+	// provenanceColumns record where and when each row was fetched. They
+	// are only written with -with-provenance.
+	provenanceColumns = columnNames(columnSpecs(columnProvenance))
+)
+
+// columnValues relies on the source columns coming first.
+func init() {
+	for i, c := range tripColumnSpecs {
+		if c.kind == columnSource && i > 0 && tripColumnSpecs[i-1].kind != columnSource {
+			panic(fmt.Sprintf("source column %s follows other columns in tripColumnSpecs", c.name))
+		}
+	}
+}
+
+// columnSpecs returns the columns of the given kinds, in insert order.
+func columnSpecs(kinds ...columnKind) []ColumnSpec {
+	var specs []ColumnSpec
+	for _, c := range tripColumnSpecs {
+		for _, k := range kinds {
+			if c.kind == k {
+				specs = append(specs, c)
+			}
+		}
+	}
+	return specs
+}
+
+// insertSpecs returns the columns written by this run: tripColumns, then
+// the provenance and raw columns when enabled.
+func insertSpecs() []ColumnSpec {
+	var specs []ColumnSpec
+	for _, c := range tripColumnSpecs {
+		if c.kind.enabled() {
+			specs = append(specs, c)
+		}
+	}
+	return specs
+}
+
+func columnNames(specs []ColumnSpec) []string {
+	names := make([]string, len(specs))
+	for i, c := range specs {
+		names[i] = c.name
+	}
+	return names
+}
+
+// columnValues returns the values of specs for trip t of batch b, in
+// order. specs must start with all the source columns.
+func columnValues(specs []ColumnSpec, t data_fetched, b batch) []any {
+	values := make([]any, len(specs))
+	r := columnRow{trip: t, batch: b}
+	for i, c := range specs {
+		if c.kind != columnSource && r.source == nil {
+			r.source = values[:i]
+		}
+		values[i] = c.value(r)
+	}
+	return values
+}
+
+func sourceValues(t data_fetched) []any {
+	return columnValues(sourceSpecs, t, batch{})
+}
+
+func tripValues(t data_fetched) []any {
+	return columnValues(tripSpecs, t, batch{})
+}
+
+// tripValuesByName returns the values of tripSpecs for t by column name.
+func tripValuesByName(t data_fetched) map[string]any {
+	byName := make(map[string]any, len(tripSpecs))
+	for i, v := range tripValues(t) {
+		byName[tripSpecs[i].name] = v
+	}
+	return byName
+}
+
+func pickupGeohash(r columnRow) any {
+	lat, lon := r.trip.PickupCentroidLatitude.Float64, r.trip.PickupCentroidLongitude.Float64
+	if lat == 0 || lon == 0 || cfg.GeohashPrecision <= 0 {
+		return nil
+	}
+	return geohashEncode(lat, lon, cfg.GeohashPrecision)
+}
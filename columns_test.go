@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// insertColumnList returns the quoted column list of an INSERT statement
+// and its number of placeholders.
+func insertColumnList(t *testing.T, query string) ([]string, int) {
+	t.Helper()
+	_, rest, ok := strings.Cut(query, " (")
+	columns, rest, ok2 := strings.Cut(rest, ") VALUES (")
+	placeholders, _, ok3 := strings.Cut(rest, ")")
+	if !ok || !ok2 || !ok3 {
+		t.Fatalf("not an INSERT with a column list: %s", query)
+	}
+	return strings.Split(columns, ", "), len(strings.Split(placeholders, ", "))
+}
+
+func quotedNames(specs []ColumnSpec) []string {
+	return quoteIdentifiers(columnNames(specs))
+}
+
+// The INSERT of every feature combination writes exactly the registry's
+// columns of the enabled kinds, in registry order, with one value each.
+func TestInsertColumnsMatchRegistry(t *testing.T) {
+	var trip data_fetched
+	if err := json.Unmarshal([]byte(`{"trip_id":"t1","pickup_centroid_latitude":"41.88","pickup_centroid_longitude":"-87.63"}`), &trip); err != nil {
+		t.Fatal(err)
+	}
+	trip.raw = json.RawMessage(`{"trip_id":"t1"}`)
+	b := batch{fetchedAt: time.Now(), source: "https://example.com/x.json"}
+
+	for _, provenance := range []bool{false, true} {
+		for _, raw := range []bool{false, true} {
+			withConfig(t, func(c *config) { c.WithProvenance, c.StoreRaw = provenance, raw })
+			kinds := []columnKind{columnSource, columnDerived}
+			if provenance {
+				kinds = append(kinds, columnProvenance)
+			}
+			if raw {
+				kinds = append(kinds, columnRaw)
+			}
+			var want []ColumnSpec
+			for _, c := range tripColumnSpecs {
+				for _, k := range kinds {
+					if c.kind == k {
+						want = append(want, c)
+					}
+				}
+			}
+
+			specs := insertSpecs()
+			columns, placeholders := insertColumnList(t, upsertKeySQL("taxi_trips", columnNames(specs), tripKey()))
+			if !reflect.DeepEqual(columns, quotedNames(want)) {
+				t.Errorf("provenance %v, raw %v: INSERT columns\n%q\nwant\n%q", provenance, raw, columns, quotedNames(want))
+			}
+			if n := len(columnValues(specs, trip, b)); n != len(columns) || placeholders != len(columns) {
+				t.Errorf("provenance %v, raw %v: %d columns, %d placeholders and %d values", provenance, raw, len(columns), placeholders, n)
+			}
+		}
+	}
+
+	columns, _ := insertColumnList(t, insertSQL)
+	if want := quotedNames(columnSpecs(columnSource, columnDerived)); !reflect.DeepEqual(columns, want) {
+		t.Errorf("insertSQL columns\n%q\nwant\n%q", columns, want)
+	}
+}
+
+func TestReparseUpdateMatchesRegistry(t *testing.T) {
+	var sets []string
+	for i, c := range columnSpecs(columnDerived) {
+		sets = append(sets, quotedNames([]ColumnSpec{c})[0]+" = $"+strconv.Itoa(i+2))
+	}
+	want := `UPDATE "taxi_trips" SET ` + strings.Join(sets, ", ") + ` WHERE "trip_id" = $1`
+	if reparseUpdateSQL != want {
+		t.Errorf("reparseUpdateSQL = %s, want %s", reparseUpdateSQL, want)
+	}
+}
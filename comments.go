@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// columnComments describes each taxi_trips column for COMMENT ON COLUMN.
+var columnComments = map[string]string{
+	"trip_id":                    "Unique identifier for the trip (source: Chicago Data Portal)",
+	"taxi_id":                    "Unique identifier for the taxi",
+	"trip_start_timestamp":       "When the trip started, rounded to the nearest 15 minutes (local time)",
+	"trip_end_timestamp":         "When the trip ended, rounded to the nearest 15 minutes (local time)",
+	"trip_seconds":               "Duration of the trip in seconds",
+	"trip_miles":                 "Distance of the trip in miles",
+	"pickup_census_tract":        "Census tract where the trip began; blank for privacy in some cases",
+	"dropoff_census_tract":       "Census tract where the trip ended; blank for privacy in some cases",
+	"pickup_community_area":      "Community area where the trip began",
+	"dropoff_community_area":     "Community area where the trip ended",
+	"fare":                       "Fare for the trip in dollars",
+	"tips":                       "Tip for the trip in dollars; cash tips generally are not recorded",
+	"tolls":                      "Tolls for the trip in dollars",
+	"extras":                     "Extra charges for the trip in dollars",
+	"trip_total":                 "Total cost of the trip in dollars",
+	"payment_type":               "Type of payment for the trip",
+	"company":                    "Taxi company",
+	"pickup_centroid_latitude":   "Latitude of the center of the pickup census tract or community area (degrees)",
+	"pickup_centroid_longitude":  "Longitude of the center of the pickup census tract or community area (degrees)",
+	"pickup_centroid_location":   "Pickup centroid as WKT POINT(longitude latitude)",
+	"dropoff_centroid_latitude":  "Latitude of the center of the dropoff census tract or community area (degrees)",
+	"dropoff_centroid_longitude": "Longitude of the center of the dropoff census tract or community area (degrees)",
+	"dropoff_centroid_location":  "Dropoff centroid as WKT POINT(longitude latitude)",
+	"pickup_geohash":             "Geohash of the pickup centroid (derived at insert time)",
+	"row_hash":                   "SHA-256 of the source columns, used to detect changed rows (derived at insert time)",
+	"fetched_at":                 "When the row was fetched, in UTC (with -with-provenance)",
+	"source_url":                 "API page URL or file the row was loaded from (with -with-provenance)",
+}
+
+// commentColumns sets the description of every taxi_trips column that has
+// one in columnComments.
+func commentColumns(ctx context.Context, db *sql.DB) error {
+	for _, col := range columnNames(insertSpecs()) {
+		comment, ok := columnComments[col]
+		if !ok {
+			continue
+		}
+		stmt := fmt.Sprintf("COMMENT ON COLUMN taxi_trips.%s IS %s", pq.QuoteIdentifier(col), pq.QuoteLiteral(comment))
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("comment on %s: %w", col, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestCommentColumns runs -with-comments against sqlmock: one COMMENT ON
+// COLUMN per commented column, in column order, provenance columns
+// included.
+func TestCommentColumns(t *testing.T) {
+	withConfig(t, func(c *config) {
+		c.WithProvenance = true
+		c.StoreRaw = false
+		c.Reparse = false
+	})
+	var got []string
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherFunc(func(_, stmt string) error {
+		got = append(got, stmt)
+		return nil
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	columns := append(append([]string{}, tripColumns...), provenanceColumns...)
+	for range columns {
+		mock.ExpectExec("COMMENT ON COLUMN").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	if err := commentColumns(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if len(got) != len(columns) {
+		t.Fatalf("%d comments for %d columns:\n%s", len(got), len(columns), strings.Join(got, "\n"))
+	}
+	for i, col := range columns {
+		if prefix := `COMMENT ON COLUMN taxi_trips."` + col + `" IS '`; !strings.HasPrefix(got[i], prefix) {
+			t.Errorf("comment %d = %s, want it on %s", i, got[i], col)
+		}
+	}
+	want := map[int]string{
+		0:                `COMMENT ON COLUMN taxi_trips."trip_id" IS 'Unique identifier for the trip (source: Chicago Data Portal)'`,
+		len(columns) - 2: `COMMENT ON COLUMN taxi_trips."fetched_at" IS 'When the row was fetched, in UTC (with -with-provenance)'`,
+		len(columns) - 1: `COMMENT ON COLUMN taxi_trips."source_url" IS 'API page URL or file the row was loaded from (with -with-provenance)'`,
+	}
+	for i, stmt := range want {
+		if got[i] != stmt {
+			t.Errorf("comment %d =\n%s\nwant\n%s", i, got[i], stmt)
+		}
+	}
+}
+
+func TestCommentColumnsError(t *testing.T) {
+	withConfig(t, func(c *config) { c.WithProvenance = false })
+	db, mock := newMock(t)
+	mock.ExpectExec(`COMMENT ON COLUMN taxi_trips."trip_id" IS 'Unique identifier for the trip (source: Chicago Data Portal)'`).
+		WillReturnError(errors.New("must be owner of table taxi_trips"))
+	err := commentColumns(context.Background(), db)
+	if err == nil || err.Error() != "comment on trip_id: must be owner of table taxi_trips" {
+		t.Errorf("commentColumns error = %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// metadataCacheTTL is how long a cached views API response is reused.
+const metadataCacheTTL = 24 * time.Hour
+
+// apiColumn is a column as described by the Socrata views API.
+type apiColumn struct {
+	FieldName    string `json:"fieldName"`
+	DataTypeName string `json:"dataTypeName"`
+}
+
+// socrataTypes maps the Go field types of the record structs to the views
+// API dataTypeName values they decode. Timestamps may be either kind.
+var socrataTypes = map[reflect.Type][]string{
+	reflect.TypeOf(""):              {"text"},
+	reflect.TypeOf(false):           {"checkbox"},
+	reflect.TypeOf(CustomInt{}):     {"number"},
+	reflect.TypeOf(CustomFloat64{}): {"number"},
+	reflect.TypeOf(CustomTime{}):    {"floating_timestamp", "calendar_date"},
+	reflect.TypeOf(Location{}):      {"point"},
+}
+
+// fieldSocrataTypes does the same for -fields-file types. JSON fields match
+// any column type.
+var fieldSocrataTypes = map[string][]string{
+	"text":      {"text"},
+	"integer":   {"number"},
+	"float":     {"number"},
+	"boolean":   {"checkbox"},
+	"timestamp": {"floating_timestamp", "calendar_date"},
+}
+
+// metadataURL returns the views API URL describing the -dataset-url
+// resource, e.g. /resource/wrvz-psew.json becomes /api/views/wrvz-psew.json.
+func metadataURL() (string, error) {
+	base, id, ok := strings.Cut(cfg.DatasetURL, "/resource/")
+	if !ok || id == "" {
+		return "", fmt.Errorf("cannot derive the views API URL from -dataset-url %s", cfg.DatasetURL)
+	}
+	return base + "/api/views/" + id, nil
+}
+
+// fetchMetadata returns the dataset's columns, reusing a response cached in
+// the user cache directory for metadataCacheTTL.
+func fetchMetadata() ([]apiColumn, error) {
+	u, err := metadataURL()
+	if err != nil {
+		return nil, err
+	}
+
+	var cache string
+	if dir, err := os.UserCacheDir(); err == nil {
+		cache = filepath.Join(dir, "taxi-trips", strings.ReplaceAll(strings.TrimPrefix(u, "https://"), "/", "_"))
+	}
+	var body []byte
+	if info, err := os.Stat(cache); cache != "" && err == nil && time.Since(info.ModTime()) < metadataCacheTTL {
+		if body, err = os.ReadFile(cache); err != nil {
+			return nil, err
+		}
+		log.Printf("Using cached metadata %s\n", cache)
+	} else {
+		if body, err = fetchMetadataBody(u); err != nil {
+			return nil, err
+		}
+		if cache != "" {
+			if err := os.MkdirAll(filepath.Dir(cache), 0o755); err == nil {
+				if err := writeFileAtomic(cache, body); err != nil {
+					log.Printf("Could not cache metadata: %v\n", err)
+				}
+			}
+		}
+	}
+
+	var view struct {
+		Columns []apiColumn `json:"columns"`
+	}
+	if err := json.Unmarshal(body, &view); err != nil {
+		return nil, fmt.Errorf("metadata: %w", err)
+	}
+	return view.Columns, nil
+}
+
+func fetchMetadataBody(u string) ([]byte, error) {
+	log.Printf("Fetching metadata from: %s\n", u)
+	resp, err := apiGet(context.Background(), u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata: unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, cfg.MaxResponseBytes))
+}
+
+// modelTypes returns the Socrata types each modelled field accepts, for the
+// active schema, keyed by the dataset column the field is read from under
+// -field-map. A nil slice accepts any type.
+func modelTypes() map[string][]string {
+	types := make(map[string][]string)
+	if spec, ok := cfg.Loader.(*fieldsSpec); ok {
+		for _, f := range spec.Fields {
+			types[f.Name] = fieldSocrataTypes[f.Type]
+		}
+		return types
+	}
+
+	t := reflect.TypeOf(data_fetched{})
+	if _, ok := cfg.Loader.(tnpSchema); ok {
+		t = reflect.TypeOf(tnpTrip{})
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if name, _, _ := strings.Cut(f.Tag.Get("json"), ","); name != "" && name != "-" {
+			types[sourceField(name)] = socrataTypes[f.Type]
+		}
+	}
+	return types
+}
+
+// compareSchema prints how the API's columns differ from the modelled ones
+// and returns the number of differences. System columns (":id" and the
+// like) are ignored.
+func compareSchema(w io.Writer, columns []apiColumn) int {
+	model := modelTypes()
+	names := make(map[string]bool, len(model))
+	for name := range model {
+		names[name] = true
+	}
+	apiTypes := make(map[string]string, len(columns))
+	for _, c := range columns {
+		if strings.HasPrefix(c.FieldName, ":") {
+			continue
+		}
+		apiTypes[c.FieldName] = c.DataTypeName
+		names[c.FieldName] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	table := newTable(w, []string{"Column", "API Type", "Model Type", "Status"})
+	differences := 0
+	for _, name := range sorted {
+		apiType, inAPI := apiTypes[name]
+		want, inModel := model[name]
+		status := "ok"
+		switch {
+		case !inModel:
+			status = "not modelled"
+		case !inAPI:
+			status = "missing from API"
+		case want != nil && !slices.Contains(want, apiType):
+			status = "type mismatch"
+		}
+		if status != "ok" {
+			differences++
+		}
+		table.Append([]string{name, apiType, strings.Join(want, " or "), status})
+	}
+	table.Render()
+	fmt.Fprintf(w, "%d differences\n", differences)
+	return differences
+}
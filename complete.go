@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// requiredFields maps the names accepted by -required to a check that the
+// field was present in the source record.
+var requiredFields = map[string]func(t data_fetched) bool{
+	"taxi_id":          func(t data_fetched) bool { return t.TaxiID != "" },
+	"start_time":       func(t data_fetched) bool { return t.TripStartTimestamp.Valid },
+	"end_time":         func(t data_fetched) bool { return t.TripEndTimestamp.Valid },
+	"seconds":          func(t data_fetched) bool { return t.TripSeconds.Valid },
+	"miles":            func(t data_fetched) bool { return t.TripMiles.Valid },
+	"pickup_area":      func(t data_fetched) bool { return t.PickupCommunityArea.Valid },
+	"dropoff_area":     func(t data_fetched) bool { return t.DropoffCommunityArea.Valid },
+	"fare":             func(t data_fetched) bool { return t.Fare.Valid },
+	"tips":             func(t data_fetched) bool { return t.Tips.Valid },
+	"total":            func(t data_fetched) bool { return t.TripTotal.Valid },
+	"payment_type":     func(t data_fetched) bool { return t.PaymentType != "" },
+	"company":          func(t data_fetched) bool { return t.Company != "" },
+	"pickup_location":  func(t data_fetched) bool { return t.PickupCentroidLocation.Type != "" },
+	"dropoff_location": func(t data_fetched) bool { return t.DropoffCentroidLocation.Type != "" },
+}
+
+// completeFilter drops trips missing any of the -required fields and counts
+// the drops by missing field.
+type completeFilter struct {
+	fields  []string
+	dropped int
+	missing map[string]int
+}
+
+func newCompleteFilter(list string) (*completeFilter, error) {
+	f := &completeFilter{missing: make(map[string]int)}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := requiredFields[name]; !ok {
+			names := make([]string, 0, len(requiredFields))
+			for n := range requiredFields {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown field %q: want one of %s", name, strings.Join(names, ", "))
+		}
+		f.fields = append(f.fields, name)
+	}
+	if len(f.fields) == 0 {
+		return nil, fmt.Errorf("no fields given")
+	}
+	return f, nil
+}
+
+// filter returns the trips that have every required field, reusing the
+// backing array of trips.
+func (f *completeFilter) filter(trips []data_fetched) []data_fetched {
+	kept := trips[:0]
+	for _, t := range trips {
+		if f.keep(t) {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// keep reports whether t has every required field. A dropped trip counts
+// once towards each field it is missing.
+func (f *completeFilter) keep(t data_fetched) bool {
+	ok := true
+	for _, name := range f.fields {
+		if !requiredFields[name](t) {
+			f.missing[name]++
+			ok = false
+		}
+	}
+	if !ok {
+		f.dropped++
+	}
+	return ok
+}
+
+func (f *completeFilter) String() string {
+	reasons := make([]string, len(f.fields))
+	for i, name := range f.fields {
+		reasons[i] = fmt.Sprintf("%s missing: %d", name, f.missing[name])
+	}
+	return fmt.Sprintf("Dropped %d incomplete trips (%s)", f.dropped, strings.Join(reasons, ", "))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// baseConfigFile holds settings shared by every profile; -profile NAME
+// layers config.NAME.yaml over it. Both are looked up in the working
+// directory. Settings are applied with this precedence, highest first:
+//
+//	command-line flags
+//	environment variables (see envFlags)
+//	config.NAME.yaml
+//	config.yaml
+//	flag defaults
+const baseConfigFile = "config.yaml"
+
+// loadConfigFiles applies config.yaml, and with a profile config.NAME.yaml
+// over it, to the flags that were not set on the command line or by the
+// environment. config.yaml is optional; a named profile's file is not.
+func loadConfigFiles(profile string) error {
+	settings, err := readConfigFile(baseConfigFile)
+	if errors.Is(err, os.ErrNotExist) {
+		settings, err = map[string]string{}, nil
+	}
+	if err != nil {
+		return err
+	}
+	if profile != "" {
+		overrides, err := readConfigFile("config." + profile + ".yaml")
+		if err != nil {
+			return err
+		}
+		for name, value := range overrides {
+			settings[name] = value
+		}
+	}
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for name, value := range settings {
+		if set[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func readConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	settings, err := parseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return settings, nil
+}
+
+// parseConfig reads a flat YAML mapping of flag names to values, such as
+//
+//	db-host: db.internal
+//	summary-interval: 1m
+//	where: "fare > 0" # quoted values may contain '#'
+//
+// Nested mappings and lists are not supported.
+func parseConfig(r io.Reader) (map[string]string, error) {
+	settings := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		text := scanner.Text()
+		line := strings.TrimSpace(text)
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		if text[0] == ' ' || text[0] == '\t' || strings.HasPrefix(line, "- ") {
+			return nil, fmt.Errorf("line %d: nested values are not supported", lineNo)
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("line %d: expected name: value", lineNo)
+		}
+		if flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("line %d: unknown flag %q", lineNo, name)
+		}
+		value = strings.TrimSpace(value)
+
+		switch {
+		case strings.HasPrefix(value, `"`):
+			end := strings.LastIndex(value, `"`)
+			unquoted, err := strconv.Unquote(value[:end+1])
+			if end == 0 || err != nil {
+				return nil, fmt.Errorf("line %d: bad quoted value", lineNo)
+			}
+			value = unquoted
+		case strings.HasPrefix(value, "'"):
+			end := strings.LastIndex(value, "'")
+			if end == 0 {
+				return nil, fmt.Errorf("line %d: unterminated single quote", lineNo)
+			}
+			value = strings.ReplaceAll(value[1:end], "''", "'")
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		settings[name] = value
+	}
+	return settings, scanner.Err()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestConfigPrecedence layers config.yaml, a profile, the environment and
+// the command line, each setting one more flag than the next: every flag
+// ends up with the value of the highest layer that sets it.
+func TestConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.yaml":      "db-host: base\ndb-user: base\ndb-name: base\ndb-port: base\n",
+		"config.prod.yaml": "db-user: prod\ndb-name: prod\ndb-port: prod\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	// A command line of its own, with a string flag for every variable
+	// applyEnv may read.
+	saved := flag.CommandLine
+	t.Cleanup(func() { flag.CommandLine = saved })
+	flag.CommandLine = flag.NewFlagSet("taxi", flag.ContinueOnError)
+	values := make(map[string]*string)
+	for name := range envFlags {
+		values[name] = flag.String(name, "default", "")
+	}
+	for _, env := range envFlags {
+		t.Setenv(env, "")
+		os.Unsetenv(env)
+	}
+	t.Setenv("PGDATABASE", "env")
+	t.Setenv("PGPORT", "env")
+	if err := flag.CommandLine.Parse([]string{"-db-port", "flag"}); err != nil {
+		t.Fatal(err)
+	}
+
+	applyEnv()
+	if err := loadConfigFiles("prod"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"db-host":     "base",
+		"db-user":     "prod",
+		"db-name":     "env",
+		"db-port":     "flag",
+		"db-password": "default",
+	}
+	for name, v := range want {
+		if got := *values[name]; got != v {
+			t.Errorf("-%s = %q, want the %s value", name, got, v)
+		}
+	}
+}
+
+func TestLoadConfigFilesMissingProfile(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	if err := loadConfigFiles(""); err != nil {
+		t.Errorf("without config.yaml: %v", err)
+	}
+	if err := loadConfigFiles("staging"); err == nil || !strings.Contains(err.Error(), "config.staging.yaml") {
+		t.Errorf("missing profile file: error = %v", err)
+	}
+}
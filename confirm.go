@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// confirmLoad asks for a typed "yes" before fetching more than
+// -confirm-threshold rows, unless -yes was given. It is off unless
+// -confirm-threshold is set, so a run makes no count query for it by
+// default. When stdin is not a terminal there is nobody to ask, so the run
+// is refused instead.
+func confirmLoad() error {
+	if cfg.ConfirmThreshold <= 0 || cfg.Yes || cfg.Reparse || cfg.ReportSource == "db" || cfg.ValidateOnly || cfg.DropTable {
+		return nil
+	}
+	total, err := fetchTotalCount(context.Background())
+	if err != nil {
+		log.Printf("Could not fetch total count, skipping confirmation: %v\n", err)
+		return nil
+	}
+	if total <= cfg.ConfirmThreshold {
+		return nil
+	}
+
+	return confirmYes(fmt.Sprintf("%d rows match, more than -confirm-threshold %d. Type \"yes\" to continue: ", total, cfg.ConfirmThreshold),
+		fmt.Sprintf("%d rows match, more than -confirm-threshold %d; rerun with -yes to proceed", total, cfg.ConfirmThreshold))
+}
+
+// confirmInput is where confirmYes reads the answer, and stdinIsTerminal
+// whether anyone is there to type it. Tests replace both.
+var (
+	confirmInput    io.Reader = os.Stdin
+	stdinIsTerminal           = func() bool { return isTerminal(os.Stdin) }
+)
+
+// confirmYes shows prompt and returns an error unless "yes" is typed. When
+// stdin is not a terminal it returns refusal instead.
+func confirmYes(prompt, refusal string) error {
+	if !stdinIsTerminal() {
+		return errors.New(refusal)
+	}
+	fmt.Fprint(textOut, prompt)
+	answer, _ := bufio.NewReader(confirmInput).ReadString('\n')
+	if strings.TrimSpace(answer) != "yes" {
+		return errors.New("aborted: the answer was not \"yes\"")
+	}
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// withConfirm makes stdin a terminal or not for the rest of the test, with
+// input typed at the prompt.
+func withConfirm(t *testing.T, terminal bool, input string) {
+	t.Helper()
+	savedInput, savedTerminal := confirmInput, stdinIsTerminal
+	t.Cleanup(func() { confirmInput, stdinIsTerminal = savedInput, savedTerminal })
+	confirmInput = strings.NewReader(input)
+	stdinIsTerminal = func() bool { return terminal }
+}
+
+func TestConfirmYes(t *testing.T) {
+	tests := []struct {
+		name     string
+		terminal bool
+		input    string
+		wantErr  string
+	}{
+		{"yes", true, "yes\n", ""},
+		{"yes without newline", true, " yes ", ""},
+		{"no", true, "no\n", "aborted"},
+		{"nothing typed", true, "", "aborted"},
+		{"not a terminal", false, "yes\n", "rerun with -yes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfirm(t, tt.terminal, tt.input)
+			err := confirmYes("Continue? ", "rerun with -yes")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("confirmYes = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("confirmYes = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestConfirmLoad serves a count of 5000 rows and checks which runs are
+// refused, and that no count is asked for unless -confirm-threshold is set.
+func TestConfirmLoad(t *testing.T) {
+	if def := flag.Lookup("confirm-threshold").DefValue; def != "0" {
+		t.Errorf("-confirm-threshold defaults to %s, want it off", def)
+	}
+	tests := []struct {
+		name       string
+		threshold  int
+		yes        bool
+		terminal   bool
+		input      string
+		wantCounts int32
+		wantErr    bool
+	}{
+		{"off by default", 0, false, false, "", 0, false},
+		{"under the threshold", 10000, false, false, "", 1, false},
+		{"refused without a terminal", 1000, false, false, "", 1, true},
+		{"-yes without a terminal", 1000, true, false, "", 0, false},
+		{"answered yes", 1000, false, true, "yes\n", 1, false},
+		{"answered no", 1000, false, true, "no\n", 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var counts atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				counts.Add(1)
+				fmt.Fprint(w, `[{"count":"5000"}]`)
+			}))
+			defer srv.Close()
+			withConfig(t, func(c *config) {
+				c.DatasetURL = srv.URL + "/resource.json"
+				c.ConfirmThreshold, c.Yes = tt.threshold, tt.yes
+			})
+			withConfirm(t, tt.terminal, tt.input)
+
+			err := confirmLoad()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("confirmLoad = %v, want error %v", err, tt.wantErr)
+			}
+			if got := counts.Load(); got != tt.wantCounts {
+				t.Errorf("made %d count queries, want %d", got, tt.wantCounts)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// csvImporter reads a CSV in the -csv export format and turns each row back
+// into a record in the API's JSON format, so -import-csv shares the decode
+// and load path with the API and -replay.
+type csvImporter struct {
+	path   string
+	f      *os.File
+	r      *csv.Reader
+	header []string
+}
+
+// locationColumns maps each WKT point column to the latitude and longitude
+// columns it is rebuilt from when the point itself is missing.
+var locationColumns = map[string][2]string{
+	"pickup_centroid_location":  {"pickup_centroid_latitude", "pickup_centroid_longitude"},
+	"dropoff_centroid_location": {"dropoff_centroid_latitude", "dropoff_centroid_longitude"},
+}
+
+// openCSVImport opens path and validates its header. Columns may be left
+// out, but every column must be one of sourceColumns or provenanceColumns,
+// and trip_id is required. Provenance columns are read but not stored.
+func openCSVImport(path string) (*csvImporter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: reading header: %w", path, err)
+	}
+
+	known := make(map[string]bool, len(sourceColumns)+len(provenanceColumns))
+	for _, c := range append(append([]string{}, sourceColumns...), provenanceColumns...) {
+		known[c] = true
+	}
+	seen := make(map[string]bool, len(header))
+	for _, c := range header {
+		switch {
+		case !known[c]:
+			f.Close()
+			return nil, fmt.Errorf("%s: unexpected column %q", path, c)
+		case seen[c]:
+			f.Close()
+			return nil, fmt.Errorf("%s: duplicate column %q", path, c)
+		}
+		seen[c] = true
+	}
+	if !seen["trip_id"] {
+		f.Close()
+		return nil, fmt.Errorf("%s: missing trip_id column", path)
+	}
+	return &csvImporter{path: path, f: f, r: r, header: header}, nil
+}
+
+func (c *csvImporter) page(n int) ([]json.RawMessage, error) {
+	var records []json.RawMessage
+	for len(records) < n {
+		row, err := c.r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.path, err)
+		}
+		line, _ := c.r.FieldPos(0)
+		record, err := c.record(row)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", c.path, line, err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// record converts one row to JSON. Values equal to -csv-null are absent,
+// and point columns are parsed from WKT or, failing that, rebuilt from the
+// latitude and longitude columns.
+func (c *csvImporter) record(row []string) (json.RawMessage, error) {
+	fields := make(map[string]string, len(row))
+	for i, v := range row {
+		if v != cfg.CSVNull {
+			fields[c.header[i]] = v
+		}
+	}
+
+	out := make(map[string]any, len(fields))
+	for name, v := range fields {
+		if _, ok := locationColumns[name]; !ok {
+			out[name] = v
+		}
+	}
+	for name, latlon := range locationColumns {
+		var loc *Location
+		var err error
+		if wkt, ok := fields[name]; ok {
+			loc, err = parsePoint(wkt)
+		} else if lat, ok := fields[latlon[0]]; ok {
+			if lon, ok := fields[latlon[1]]; ok {
+				loc, err = pointFromLatLon(lat, lon)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if loc != nil {
+			out[name] = loc
+		}
+	}
+	return json.Marshal(out)
+}
+
+// parsePoint parses the "POINT(lon lat)" text written by Location.wkt.
+func parsePoint(s string) (*Location, error) {
+	inner, hasPrefix := strings.CutPrefix(strings.TrimSpace(s), "POINT(")
+	inner, hasSuffix := strings.CutSuffix(inner, ")")
+	coords := strings.Fields(inner)
+	if !hasPrefix || !hasSuffix || len(coords) != 2 {
+		return nil, fmt.Errorf("invalid point %q", s)
+	}
+	return pointFromLatLon(coords[1], coords[0])
+}
+
+func pointFromLatLon(lat, lon string) (*Location, error) {
+	y, err := strconv.ParseFloat(lat, 64)
+	if err != nil {
+		return nil, err
+	}
+	x, err := strconv.ParseFloat(lon, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &Location{Type: "Point", Coordinates: [2]float64{x, y}}, nil
+}
+
+func (c *csvImporter) name() string { return c.path }
+
+func (c *csvImporter) Close() error {
+	return c.f.Close()
+}
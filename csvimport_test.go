@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCSVRoundTrip exports trips with the CSV sink and imports the file
+// back: every trip must come back with the same values, absent fields
+// included.
+func TestCSVRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trips.csv")
+	withConfig(t, func(c *config) { c.WithProvenance = false })
+	trips := []data_fetched{sampleTrip(t, "a"), {TripID: "b"}}
+
+	sink, err := newCSVSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(context.Background(), batch{trips: trips}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	imp, err := openCSVImport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer imp.Close()
+	records, err := imp.page(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(trips) {
+		t.Fatalf("imported %d records, want %d", len(records), len(trips))
+	}
+	for i, record := range records {
+		var got data_fetched
+		if err := json.Unmarshal(record, &got); err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(csvRecord(got), csvRecord(trips[i])) {
+			t.Errorf("trip %s came back as\n%v\nwant\n%v", trips[i].TripID, csvRecord(got), csvRecord(trips[i]))
+		}
+		if !reflect.DeepEqual(tripValues(got), tripValues(trips[i])) {
+			t.Errorf("trip %s came back with values\n%v\nwant\n%v", trips[i].TripID, tripValues(got), tripValues(trips[i]))
+		}
+	}
+}
+
+// TestCSVImportLatLon rebuilds a missing point from its latitude and
+// longitude columns.
+func TestCSVImportLatLon(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trips.csv")
+	csv := "trip_id,pickup_centroid_latitude,pickup_centroid_longitude\na,41.88,-87.63\n"
+	if err := os.WriteFile(path, []byte(csv), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	imp, err := openCSVImport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer imp.Close()
+	records, err := imp.page(10)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("imported %d records: %v", len(records), err)
+	}
+	var got data_fetched
+	if err := json.Unmarshal(records[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	want := Location{Type: "Point", Coordinates: [2]float64{-87.63, 41.88}}
+	if got.PickupCentroidLocation != want {
+		t.Errorf("pickup location = %+v, want %+v", got.PickupCentroidLocation, want)
+	}
+}
+
+func TestCSVImportHeader(t *testing.T) {
+	tests := []struct {
+		header  string
+		wantErr string
+	}{
+		{"trip_id,fare", ""},
+		{"trip_id,fare,surge", `unexpected column "surge"`},
+		{"trip_id,fare,fare", `duplicate column "fare"`},
+		{"taxi_id,fare", "missing trip_id column"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "trips.csv")
+		if err := os.WriteFile(path, []byte(tt.header+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		imp, err := openCSVImport(path)
+		if err == nil {
+			imp.Close()
+		}
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("header %q: %v", tt.header, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("header %q: error %v, want %q", tt.header, err, tt.wantErr)
+		}
+	}
+}
+
+// TestTZOutputRoundTrip exports a trip with -tz-output and reads it back
+// with -replay and -import-csv: the timestamps are written with their
+// offset and come back with the wall clock of the trip as fetched.
+func TestTZOutputRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		sink func(path string) (Sink, error)
+		read func(path string) (recordSource, error)
+	}{
+		{
+			"jsonl",
+			func(path string) (Sink, error) { return newJSONLSink(path) },
+			func(path string) (recordSource, error) { return openReplay(path) },
+		},
+		{
+			"csv",
+			func(path string) (Sink, error) { return newCSVSink(path) },
+			func(path string) (recordSource, error) { return openCSVImport(path) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "trips")
+			withConfig(t, func(c *config) {
+				c.WithProvenance = false
+				c.OutputLocation = time.FixedZone("UTC-5", -5*60*60)
+			})
+			trip := sampleTrip(t, "a")
+
+			sink, err := tt.sink(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Write(context.Background(), batch{trips: []data_fetched{trip}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), "-05:00") {
+				t.Errorf("export has no -tz-output offset:\n%s", data)
+			}
+
+			src, err := tt.read(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer src.Close()
+			records, err := src.page(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 1 {
+				t.Fatalf("read back %d records, want 1", len(records))
+			}
+			var got data_fetched
+			if err := json.Unmarshal(records[0], &got); err != nil {
+				t.Fatal(err)
+			}
+			got.Normalize(cfg.Normalize)
+			undoOutputZone(&got)
+			for _, ts := range []struct {
+				name      string
+				got, want CustomTime
+			}{
+				{"trip_start_timestamp", got.TripStartTimestamp, trip.TripStartTimestamp},
+				{"trip_end_timestamp", got.TripEndTimestamp, trip.TripEndTimestamp},
+			} {
+				if ts.got.Time.Format(ctLayout) != ts.want.Time.Format(ctLayout) || !ts.got.Time.Equal(ts.want.Time) {
+					t.Errorf("%s came back as %v, want %v", ts.name, ts.got.Time, ts.want.Time)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"sort"
+	"strings"
+)
+
+// Decoder splits a response body into records. Records are passed on as
+// JSON objects keyed by field name, so a decoder for another format
+// converts each row to that shape and the rest of the pipeline is shared.
+type Decoder interface {
+	Decode(body []byte) ([]json.RawMessage, error)
+}
+
+// decoders maps -format-in names to their decoders.
+var decoders = map[string]Decoder{
+	"json": jsonDecoder{},
+}
+
+// mediaTypes maps response Content-Types to -format-in names for
+// -format-in auto.
+var mediaTypes = map[string]string{
+	"application/json": "json",
+}
+
+// jsonDecoder reads the API's JSON array of objects. A lone object is
+// occasionally sent in place of the array; it is taken as a one-record page,
+// unless it is a Socrata error body.
+type jsonDecoder struct{}
+
+func (jsonDecoder) Decode(body []byte) ([]json.RawMessage, error) {
+	var records []json.RawMessage
+	err := json.Unmarshal(body, &records)
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) || typeErr.Value != "object" {
+		return records, err
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(body, &object); err != nil {
+		return nil, err
+	}
+	if _, ok := object["error"]; ok {
+		return nil, fmt.Errorf("API error: %s", object["message"])
+	}
+	log.Println("Response was a single object instead of an array; treating it as one record")
+	return []json.RawMessage{body}, nil
+}
+
+// formatNames lists the accepted -format-in values.
+func formatNames() string {
+	names := []string{"auto"}
+	for name := range decoders {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return strings.Join(names, ", ")
+}
+
+// selectDecoder returns the decoder for -format-in, or with auto the one
+// registered for contentType. Unknown or missing types fall back to JSON,
+// which is what the API serves.
+func selectDecoder(contentType string) (Decoder, error) {
+	if cfg.FormatIn != "auto" {
+		d, ok := decoders[cfg.FormatIn]
+		if !ok {
+			return nil, fmt.Errorf("unknown -format-in %q: want %s", cfg.FormatIn, formatNames())
+		}
+		return d, nil
+	}
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+		if name, ok := mediaTypes[mt]; ok {
+			return decoders[name], nil
+		}
+	}
+	return decoders["json"], nil
+}
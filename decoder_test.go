@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJSONDecoder(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []string
+		wantErr string
+	}{
+		{"array", `[{"trip_id":"a"},{"trip_id":"b"}]`, []string{`{"trip_id":"a"}`, `{"trip_id":"b"}`}, ""},
+		{"empty array", `[]`, []string{}, ""},
+		{"lone object", `{"trip_id":"a"}`, []string{`{"trip_id":"a"}`}, ""},
+		{"error body", `{"error":true,"message":"bad query"}`, nil, `API error: "bad query"`},
+		{"truncated", `[{"trip_id":"a"`, nil, "unexpected end"},
+		{"not records", `"a"`, nil, "cannot unmarshal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := jsonDecoder{}.Decode([]byte(tt.body))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Decode error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(tt.want) {
+				t.Fatalf("Decode returned %d records, want %d", len(records), len(tt.want))
+			}
+			for i, r := range records {
+				if string(r) != tt.want[i] {
+					t.Errorf("record %d = %s, want %s", i, r, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSelectDecoder(t *testing.T) {
+	tests := []struct {
+		formatIn    string
+		contentType string
+		wantErr     bool
+	}{
+		{"auto", "application/json; charset=utf-8", false},
+		{"auto", "text/csv", false}, // unknown types are read as JSON
+		{"auto", "", false},
+		{"json", "text/csv", false},
+		{"xml", "application/json", true},
+	}
+	for _, tt := range tests {
+		withConfig(t, func(c *config) { c.FormatIn = tt.formatIn })
+		d, err := selectDecoder(tt.contentType)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("-format-in %s: got a decoder, want an error", tt.formatIn)
+			}
+			continue
+		}
+		if _, ok := d.(jsonDecoder); err != nil || !ok {
+			t.Errorf("-format-in %s with Content-Type %q = %T, %v, want the JSON decoder", tt.formatIn, tt.contentType, d, err)
+		}
+	}
+}
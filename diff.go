@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// diffSummary counts how fetched trips compare with the stored rows.
+type diffSummary struct {
+	New, Changed, Unchanged int
+}
+
+func (d diffSummary) String() string {
+	return fmt.Sprintf("Diff: %d new, %d changed, %d unchanged", d.New, d.Changed, d.Unchanged)
+}
+
+// diffTrips compares a page of trips with the rows stored under the same
+// trip_ids and adds the outcome to sum. Rows are matched by row_hash; rows
+// stored before row_hash existed are hashed from their columns instead.
+// With -verbose every changed column is logged. Nothing is written.
+func diffTrips(ctx context.Context, db *sql.DB, trips []data_fetched, sum *diffSummary) error {
+	ids := make([]string, len(trips))
+	for i, trip := range trips {
+		ids[i] = trip.TripID
+	}
+
+	query := fmt.Sprintf("SELECT row_hash, %s FROM taxi_trips WHERE trip_id = ANY($1)", strings.Join(quoteIdentifiers(sourceColumns), ", "))
+	rows, err := db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	stored := make(map[string][]any, len(trips))
+	hashes := make(map[string]string, len(trips))
+	for rows.Next() {
+		var hash sql.NullString
+		values := make([]any, len(sourceColumns))
+		dest := []any{&hash}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		id := formatValue(values[0])
+		stored[id] = values
+		if hash.Valid {
+			hashes[id] = hash.String
+		} else {
+			hashes[id] = rowHash(values)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var page diffSummary
+	for _, trip := range trips {
+		old, ok := stored[trip.TripID]
+		if !ok {
+			page.New++
+			continue
+		}
+		values := sourceValues(trip)
+		if rowHash(values) == hashes[trip.TripID] {
+			page.Unchanged++
+			continue
+		}
+		page.Changed++
+		if cfg.Verbose {
+			for i, col := range sourceColumns {
+				if before, after := formatValue(old[i]), formatValue(values[i]); before != after {
+					log.Printf("  %s %s: %q -> %q\n", trip.TripID, col, before, after)
+				}
+			}
+		}
+	}
+	log.Println(page)
+
+	sum.New += page.New
+	sum.Changed += page.Changed
+	sum.Unchanged += page.Unchanged
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// distinctLimit caps the number of values -distinct asks for. Socrata
+// returns only 1000 rows unless told otherwise.
+const distinctLimit = 50000
+
+type distinctValue struct {
+	Value string
+	Null  bool
+	Count int
+}
+
+// fetchDistinct asks the API for the distinct values of field and how many
+// rows have each, sorted by value. The configured filters apply, and
+// -field-map names the column the field is read from.
+func fetchDistinct(field string) ([]distinctValue, error) {
+	column := sourceField(field)
+	resp, err := apiGet(context.Background(), queryURL(url.Values{
+		"$select": {column + ", count(*) AS count"},
+		"$group":  {column},
+		"$order":  {column},
+		"$limit":  {strconv.Itoa(distinctLimit)},
+	}))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("distinct query: unexpected status %s", resp.Status)
+	}
+
+	var result []map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	values := make([]distinctValue, len(result))
+	for i, row := range result {
+		count, err := strconv.Atoi(fmt.Sprint(row["count"]))
+		if err != nil {
+			return nil, fmt.Errorf("distinct query: invalid count %v", row["count"])
+		}
+		v, ok := row[column]
+		values[i] = distinctValue{Value: fmt.Sprint(v), Null: !ok || v == nil, Count: count}
+	}
+	if len(values) == distinctLimit {
+		return values, fmt.Errorf("distinct query: stopped at %d values", distinctLimit)
+	}
+	return values, nil
+}
+
+// printDistinct lists the values quoted, so stray whitespace and casing
+// differences are visible.
+func printDistinct(w io.Writer, column string, values []distinctValue) {
+	total := 0
+	for _, v := range values {
+		if v.Null {
+			fmt.Fprintf(w, "%8d  (null)\n", v.Count)
+		} else {
+			fmt.Fprintf(w, "%8d  %q\n", v.Count, v.Value)
+		}
+		total += v.Count
+	}
+	fmt.Fprintf(w, "%d distinct values of %s over %d rows\n", len(values), column, total)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// childTablesSQL lists the tables inheriting from a table: its -shards, or
+// the partitions of a -partition-range table.
+const childTablesSQL = `SELECT c.relname FROM pg_inherits i
+        JOIN pg_class c ON c.oid = i.inhrelid
+        WHERE i.inhparent = to_regclass($1) ORDER BY c.relname`
+
+// dropTables drops the tables a load writes to, for -drop-table, after a
+// typed confirmation unless -yes was given. Each table goes with its shards
+// and partitions, found in the catalog rather than from -shards and
+// -partition-range, so a reset works whatever flags created them. Nothing
+// is dropped with CASCADE; a view on a table makes the drop fail instead.
+func dropTables(ctx context.Context, db *sql.DB) error {
+	tables := loadTables()
+	if !cfg.Yes {
+		if err := confirmYes(fmt.Sprintf("Drop %s, with their shards and partitions? Type \"yes\" to continue: ", strings.Join(tables, ", ")),
+			"rerun with -yes to drop the tables"); err != nil {
+			return err
+		}
+	}
+	for _, table := range tables {
+		var found sql.NullString
+		if err := db.QueryRowContext(ctx, "SELECT to_regclass($1)::text", pq.QuoteIdentifier(table)).Scan(&found); err != nil {
+			return err
+		}
+		if !found.Valid {
+			log.Printf("%s does not exist\n", table)
+			continue
+		}
+		children, err := childTables(ctx, db, table)
+		if err != nil {
+			return fmt.Errorf("listing the children of %s: %w", table, err)
+		}
+		// Listed together, the children need no CASCADE.
+		names := append(children, table)
+		if _, err := db.ExecContext(ctx, "DROP TABLE IF EXISTS "+strings.Join(quoteIdentifiers(names), ", ")); err != nil {
+			return fmt.Errorf("drop %s: %w", table, err)
+		}
+		if len(children) > 0 {
+			log.Printf("Dropped %s and its %d child tables: %s\n", table, len(children), strings.Join(children, ", "))
+		} else {
+			log.Printf("Dropped %s\n", table)
+		}
+	}
+	return nil
+}
+
+func childTables(ctx context.Context, db *sql.DB, table string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, childTablesSQL, pq.QuoteIdentifier(table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var children []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		children = append(children, name)
+	}
+	return children, rows.Err()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// A refused -drop-table returns an error, through run()'s deferred calls,
+// without touching the database.
+func TestDropTablesRefused(t *testing.T) {
+	tests := []struct {
+		name     string
+		terminal bool
+		input    string
+	}{
+		{"not a terminal", false, ""},
+		{"answered no", true, "no\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *config) {
+				c.Yes = false
+				c.Loader = nil
+			})
+			withConfirm(t, tt.terminal, tt.input)
+			db, mock := newMock(t)
+			if err := dropTables(context.Background(), db); err == nil {
+				t.Error("dropTables succeeded without confirmation")
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestDropTablesConfirmed(t *testing.T) {
+	withConfig(t, func(c *config) {
+		c.Yes = false
+		c.Loader = nil
+	})
+	withConfirm(t, true, "yes\n")
+	db, mock := newMock(t)
+	mock.ExpectQuery("SELECT to_regclass($1)::text").WithArgs(`"taxi_trips"`).
+		WillReturnRows(sqlmock.NewRows([]string{"to_regclass"}).AddRow("taxi_trips"))
+	mock.ExpectQuery(childTablesSQL).WithArgs(`"taxi_trips"`).
+		WillReturnRows(sqlmock.NewRows([]string{"relname"}).AddRow("taxi_trips_0").AddRow("taxi_trips_1"))
+	mock.ExpectExec(`DROP TABLE IF EXISTS "taxi_trips_0", "taxi_trips_1", "taxi_trips"`).WillReturnResult(driver.ResultNoRows)
+	mock.ExpectQuery("SELECT to_regclass($1)::text").WithArgs(`"taxi_trips_anomalies"`).
+		WillReturnRows(sqlmock.NewRows([]string{"to_regclass"}).AddRow(nil))
+	if err := dropTables(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// connString returns the connection string to hand to sql.Open. A -dsn (or
+// DATABASE_URL) takes precedence over the individual -db-* settings and is
+// validated before use. The -ssl* settings always apply to the -db-*
+// settings, and to a -dsn only when given explicitly, so a DSN's own
+// sslmode is otherwise kept.
+func connString() (string, error) {
+	return dsnConnString(cfg.DSN)
+}
+
+// dsnConnString is connString for the given -dsn, or for the -db-* settings
+// when dsn is empty.
+func dsnConnString(dsn string) (string, error) {
+	ssl, err := sslParams()
+	if err != nil {
+		return "", err
+	}
+
+	if dsn == "" {
+		dsn = fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s",
+			quoteParam(cfg.DBHost), cfg.DBPort, quoteParam(cfg.DBUser), quoteParam(cfg.DBPassword), quoteParam(cfg.DBName))
+		for _, p := range ssl {
+			dsn += " " + p[0] + "=" + quoteParam(p[1])
+		}
+		return dsn, nil
+	}
+
+	for _, p := range ssl {
+		if !isFlagSet(p[0]) {
+			continue
+		}
+		if isURL(dsn) {
+			u, err := url.Parse(dsn)
+			if err != nil {
+				break // reported below
+			}
+			q := u.Query()
+			q.Set(p[0], p[1])
+			u.RawQuery = q.Encode()
+			dsn = u.String()
+		} else {
+			// Later keys override earlier ones.
+			dsn += " " + p[0] + "=" + quoteParam(p[1])
+		}
+	}
+
+	if isURL(dsn) {
+		// url.Parse errors quote the whole input, password included, so
+		// only the underlying reason is reported.
+		if _, err := url.Parse(dsn); err != nil {
+			var uerr *url.Error
+			if errors.As(err, &uerr) {
+				err = uerr.Err
+			}
+			return "", fmt.Errorf("invalid -dsn: %v", err)
+		}
+	}
+	if _, err := pq.NewConnector(dsn); err != nil {
+		return "", fmt.Errorf("invalid -dsn: %v", err)
+	}
+	return dsn, nil
+}
+
+// sslModes are the sslmode values lib/pq supports.
+var sslModes = map[string]bool{"disable": true, "require": true, "verify-ca": true, "verify-full": true}
+
+// sslParams validates the -ssl* settings and returns them as connection
+// parameters. Certificate files are checked up front so a missing one fails
+// with its flag name rather than as a TLS error; their contents are never
+// read here.
+func sslParams() ([][2]string, error) {
+	if !sslModes[cfg.SSLMode] {
+		return nil, fmt.Errorf("invalid -sslmode %q: want disable, require, verify-ca or verify-full", cfg.SSLMode)
+	}
+	params := [][2]string{{"sslmode", cfg.SSLMode}}
+	for _, f := range []struct{ name, path string }{
+		{"sslrootcert", cfg.SSLRootCert},
+		{"sslcert", cfg.SSLCert},
+		{"sslkey", cfg.SSLKey},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			return nil, fmt.Errorf("invalid -%s: %v", f.name, err)
+		}
+		params = append(params, [2]string{f.name, f.path})
+	}
+	if (cfg.SSLCert == "") != (cfg.SSLKey == "") {
+		return nil, errors.New("-sslcert and -sslkey must be given together")
+	}
+	return params, nil
+}
+
+// quoteParam quotes a key=value connection parameter so values with spaces
+// or quotes, such as passwords and paths, survive.
+func quoteParam(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+func isURL(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+var passwordParam = regexp.MustCompile(`password=('(\\.|[^'\\])*'|\S*)`)
+
+// redactDSN hides the password in a URL or key=value connection string so
+// it can be logged.
+func redactDSN(dsn string) string {
+	if isURL(dsn) {
+		if u, err := url.Parse(dsn); err == nil {
+			if q := u.Query(); q.Has("password") {
+				q.Set("password", "xxxxx")
+				u.RawQuery = q.Encode()
+			}
+			return u.Redacted()
+		}
+		return "<unparseable connection URL>"
+	}
+	return passwordParam.ReplaceAllString(dsn, "password=xxxxx")
+}
+
+// describeConnError explains a failed connection. Authentication failures
+// (SQLSTATE class 28) from lib/pq only name the user, so they get a hint at
+// the settings to check; the password itself is never printed.
+func describeConnError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code.Class() != "28" {
+		return fmt.Errorf("connecting to Postgres: %w", err)
+	}
+	source := "-db-user/-db-password (env PGUSER/PGPASSWORD)"
+	if cfg.DSN != "" {
+		source = "the credentials in -dsn (env DATABASE_URL)"
+	}
+	return fmt.Errorf("postgres rejected the login (%s): check %s, and that pg_hba.conf allows this host and -sslmode %s",
+		pqErr.Message, source, cfg.SSLMode)
+}
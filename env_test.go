@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	input := `# Chicago taxi loader
+PGHOST=db.internal
+
+export PGUSER=loader
+PGPASSWORD="p@ss # not a comment"
+APP_TOKEN='abc"def'
+SOCRATA_WHERE="company = \"Flash Cab\"\ttab"
+PGDATABASE=chicago # trailing comment
+EMPTY=
+  SPACED  =  value with spaces  
+HASH=a#b
+`
+	want := map[string]string{
+		"PGHOST":        "db.internal",
+		"PGUSER":        "loader",
+		"PGPASSWORD":    "p@ss # not a comment",
+		"APP_TOKEN":     `abc"def`,
+		"SOCRATA_WHERE": "company = \"Flash Cab\"\ttab",
+		"PGDATABASE":    "chicago",
+		"EMPTY":         "",
+		"SPACED":        "value with spaces",
+		"HASH":          "a#b",
+	}
+	got, err := parseEnvFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseEnvFile =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestParseEnvFileErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"no equals", "PGHOST\n", "line 1: expected KEY=VALUE"},
+		{"no key", "# ok\n=value\n", "line 2: expected KEY=VALUE"},
+		{"bad double quote", `A="unterminated` + "\n", "line 1: bad quoted value"},
+		{"bad single quote", "A='unterminated\n", "line 1: unterminated single quote"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseEnvFile(strings.NewReader(tt.input))
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("parseEnvFile error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// Variables already in the environment win over the file.
+func TestLoadEnvFileKeepsEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("TAXI_TEST_SET=file\nTAXI_TEST_UNSET=file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TAXI_TEST_SET", "environment")
+	t.Setenv("TAXI_TEST_UNSET", "")
+	os.Unsetenv("TAXI_TEST_UNSET")
+
+	if err := loadEnvFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := os.Getenv("TAXI_TEST_SET"); got != "environment" {
+		t.Errorf("TAXI_TEST_SET = %q, want the environment's value", got)
+	}
+	if got := os.Getenv("TAXI_TEST_UNSET"); got != "file" {
+		t.Errorf("TAXI_TEST_UNSET = %q, want the file's value", got)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+)
+
+// errorDump is the -error-dump file of records that failed to decode. It is
+// nil when the flag is unset, and its methods do nothing on nil.
+var errorDump *dumpFile
+
+// dumpFile writes each bad record to a JSON Lines file with the error and
+// where it was found, up to a cap. Every entry is written straight to the
+// file, so the samples survive a run that ends fatally.
+type dumpFile struct {
+	f       *os.File
+	enc     *json.Encoder
+	max     int
+	written int
+	dropped int
+}
+
+// dumpEntry is one line of the -error-dump file.
+type dumpEntry struct {
+	Offset int             `json:"offset"`
+	Index  int             `json:"index"` // position of the record in its page
+	Error  string          `json:"error"`
+	Record json.RawMessage `json:"record"`
+}
+
+func openDumpFile(path string, max int) (*dumpFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &dumpFile{f: f, enc: json.NewEncoder(f), max: max}, nil
+}
+
+// add records a record that failed to decode with err.
+func (d *dumpFile) add(offset, index int, record json.RawMessage, err error) {
+	if d == nil {
+		return
+	}
+	if d.max > 0 && d.written >= d.max {
+		d.dropped++
+		return
+	}
+	if !json.Valid(record) {
+		record, _ = json.Marshal(string(record))
+	}
+	if werr := d.enc.Encode(dumpEntry{Offset: offset, Index: index, Error: err.Error(), Record: record}); werr != nil {
+		log.Printf("-error-dump: %v\n", werr)
+		return
+	}
+	d.written++
+}
+
+func (d *dumpFile) close() {
+	if d == nil {
+		return
+	}
+	if err := d.f.Close(); err != nil {
+		log.Printf("-error-dump: %v\n", err)
+		return
+	}
+	if d.dropped > 0 {
+		log.Printf("Wrote %d undecodable records to %s; %d more over -error-dump-max were not written\n", d.written, d.f.Name(), d.dropped)
+	} else if d.written > 0 {
+		log.Printf("Wrote %d undecodable records to %s\n", d.written, d.f.Name())
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestErrorDump fetches a page with two records that fail to decode and an
+// -error-dump-max of 1: the first is dumped with its position and error,
+// the second only counted.
+func TestErrorDump(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors.jsonl")
+	bad := `{"trip_id":"b","trip_start_timestamp":"yesterday"}`
+	fetchTestServer(t, `[{"trip_id":"a"},`+bad+`,{"trip_id":"c","trip_seconds":"many"}]`)
+	dump, err := openDumpFile(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errorDump = dump
+	t.Cleanup(func() { errorDump = nil })
+
+	rows, _ := runFetch(t)
+	dump.close()
+	if rows != 1 {
+		t.Errorf("fetched %d rows, want the 1 that decoded", rows)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []dumpEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e dumpEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("dump line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("dumped %d records, want 1 under -error-dump-max", len(entries))
+	}
+	e := entries[0]
+	if e.Offset != 0 || e.Index != 1 || e.Error == "" || string(e.Record) != bad {
+		t.Errorf("dumped %+v, want record %s at offset 0, index 1, with its error", e, bad)
+	}
+	if dump.dropped != 1 {
+		t.Errorf("dropped %d records over the cap, want 1", dump.dropped)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// FetchError is a failed API request, whether the request itself, the
+// status or the body failed. Its message is the cause's, which names the
+// URL where it matters.
+type FetchError struct {
+	URL string // with the app token masked
+	Err error
+}
+
+func (e *FetchError) Error() string { return e.Err.Error() }
+func (e *FetchError) Unwrap() error { return e.Err }
+
+// ParseError is a record that could not be decoded into a trip.
+type ParseError struct {
+	Offset int    // of the page
+	Index  int    // of the record within the page
+	TripID string // "" when the record has none that can be read
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	trip := ""
+	if e.TripID != "" {
+		trip = " (trip " + e.TripID + ")"
+	}
+	return fmt.Sprintf("record %d at offset %d%s: %v", e.Index, e.Offset, trip, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// DBError is a write the database rejected during the run, such as a batch
+// that could not be inserted. Failing to connect or to create the tables is
+// not one: that ends the run before it starts, with exitFatal like any other
+// setup error.
+type DBError struct {
+	Op  string // what was being done, or "" when the cause says it
+	Err error
+}
+
+func (e *DBError) Error() string {
+	if e.Op == "" {
+		return e.Err.Error()
+	}
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *DBError) Unwrap() error { return e.Err }
+
+// exitCode returns the exit code of a run ended by err, by the category of
+// error it wraps. Bad configuration and anything uncategorized is
+// exitFatal.
+func exitCode(err error) int {
+	var (
+		fe *FetchError
+		pe *ParseError
+		de *DBError
+	)
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &pe):
+		return exitParse
+	case errors.As(err, &de):
+		return exitDB
+	case errors.As(err, &fe):
+		return exitFetch
+	}
+	return exitFatal
+}
+
+// runFailure holds the error that ends a run from outside the fetch loop,
+// such as a batch the database workers could not insert.
+var runFailure failure
+
+// failure keeps the first error reported to it and cancels the run, so the
+// fetch loop stops and run() exits with the error's exitCode after its
+// deferred calls, the run history among them.
+type failure struct {
+	mu     sync.Mutex
+	err    error
+	cancel context.CancelFunc // of the run, nil until run() sets it
+}
+
+// set records err unless an earlier error was, in which case err is only
+// logged.
+func (f *failure) set(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		log.Print(err)
+		return
+	}
+	f.err = err
+	if f.cancel != nil {
+		f.cancel()
+	}
+}
+
+func (f *failure) get() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	fetchErr := &FetchError{URL: "https://example.com/x.json", Err: &statusError{StatusCode: 503, Status: "503 Service Unavailable"}}
+	parseErr := &ParseError{Offset: 100, Index: 3, TripID: "abc", Err: errors.New("invalid character")}
+	dbErr := &DBError{Op: "inserting the page at offset 100", Err: errors.New("connection reset")}
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, exitOK},
+		{"plain", errors.New("invalid cursor"), exitFatal},
+		{"fetch", fetchErr, exitFetch},
+		{"wrapped fetch", fmt.Errorf("giving up after 10 consecutive fetch errors: %w", fetchErr), exitFetch},
+		{"parse", parseErr, exitParse},
+		{"wrapped parse", fmt.Errorf("strict: %w", parseErr), exitParse},
+		{"db", dbErr, exitDB},
+		{"wrapped db", fmt.Errorf("postgres: %w", dbErr), exitDB},
+		// Not reaching the database or creating its tables is not a
+		// DBError, so it is fatal like any other setup error.
+		{"connect", describeConnError(errors.New("dial tcp 127.0.0.1:5432: connect: connection refused")), exitFatal},
+		{"creating tables", fmt.Errorf("creating tables: %w", errors.New("permission denied for schema public")), exitFatal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailureKeepsFirstErrorAndCancels(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := failure{cancel: cancel}
+	if f.get() != nil {
+		t.Fatal("new failure has an error")
+	}
+
+	first := &DBError{Op: "inserting the page at offset 0", Err: errors.New("connection reset")}
+	f.set(first)
+	f.set(errors.New("later"))
+	if got := f.get(); got != first {
+		t.Errorf("get() = %v, want the first error %v", got, first)
+	}
+	if ctx.Err() == nil {
+		t.Error("set did not cancel the run")
+	}
+}
+
+// The exit codes are a contract with the scripts that run loads: 0 success,
+// 1 a fatal error, 2 some records skipped, 3 canceled.
+func TestOutcomeCode(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		canceled    bool
+		rows        int
+		skipped     int64
+		failOnEmpty bool
+		want        int
+	}{
+		{"success", nil, false, 300, 0, false, exitOK},
+		{"empty without -fail-on-empty", nil, false, 0, 0, false, exitOK},
+		{"fatal", errors.New("invalid cursor in resume.txt"), false, 100, 0, false, exitFatal},
+		{"skipped", nil, false, 300, 2, false, exitSkipped},
+		{"canceled", nil, true, 100, 0, false, exitCanceled},
+		// Canceling to stop a failed run does not hide the failure.
+		{"canceled by a failure", errors.New("postgres: connection reset"), true, 100, 0, false, exitFatal},
+		{"canceled with skipped records", nil, true, 100, 2, false, exitCanceled},
+		{"empty", nil, false, 0, 0, true, exitEmpty},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *config) { c.FailOnEmpty = tt.failOnEmpty })
+			if got := outcomeCode(tt.err, tt.canceled, tt.rows, tt.skipped); got != tt.want {
+				t.Errorf("outcomeCode = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
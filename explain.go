@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// activeFilter renders the SoQL query parameters every page request
+// carries, such as $where, or "" when there are none.
+func activeFilter() string {
+	q := strings.TrimPrefix(queryURL(url.Values{}), cfg.DatasetURL+"?")
+	if unescaped, err := url.QueryUnescape(q); err == nil {
+		q = unescaped
+	}
+	return q
+}
+
+// explain prints what a run with the resolved settings (flags, environment
+// and -env-file) would do, for -explain. It reads nothing from the network
+// or the database.
+func explain(w io.Writer) {
+	line := func(label, format string, args ...any) {
+		fmt.Fprintf(w, "%-10s %s\n", label+":", fmt.Sprintf(format, args...))
+	}
+
+	switch {
+	case cfg.Preflight:
+		line("Mode", "check the database, API, credentials and output paths")
+		return
+	case cfg.PrintSchema:
+		line("Mode", "print the DDL that creates the taxi_trips tables")
+		return
+	case cfg.PrintURL:
+		line("Mode", "print the URL of the first page request")
+		return
+	case cfg.SchemaOut != "":
+		line("Mode", "write the trip JSON Schema to %s", cfg.SchemaOut)
+		return
+	case cfg.CompareSchema:
+		line("Mode", "compare the dataset's columns with the model")
+	case cfg.Distinct != "":
+		line("Mode", "print the distinct values of %s", cfg.Distinct)
+	case cfg.CountOnly:
+		line("Mode", "print the number of matching rows")
+	case cfg.DropTable:
+		line("Mode", "drop %s with their shards and partitions", strings.Join(loadTables(), ", "))
+		return
+	case cfg.ValidateOnly:
+		line("Mode", "check the rows in taxi_trips for invalid data")
+		return
+	case cfg.ReportSource == "db":
+		line("Mode", "print the %s report from the rows in taxi_trips", cfg.Report)
+		return
+	case cfg.Reparse:
+		line("Mode", "recompute derived columns from raw_json in taxi_trips, %d rows per transaction", reparseBatch)
+	default:
+		line("Mode", "fetch and load")
+	}
+
+	switch {
+	case cfg.ReplayPath != "":
+		line("Source", "replay %s", cfg.ReplayPath)
+	case cfg.ImportCSV != "":
+		line("Source", "CSV import %s", cfg.ImportCSV)
+	case cfg.Reparse:
+		line("Source", "raw_json in Postgres")
+	default:
+		line("Source", "%s", cfg.DatasetURL)
+		if q := activeFilter(); q != "" {
+			line("Filter", "%s", q)
+		}
+		if cfg.SinceLastRun {
+			line("Since", "trips starting at or after the latest one loaded by the last successful run in extraction_runs")
+		}
+		switch {
+		case cfg.AppToken == "":
+		case cfg.TokenAsParam:
+			line("Auth", "app token in the %s query parameter", tokenParam)
+		default:
+			line("Auth", "app token in the X-App-Token header")
+		}
+	}
+	if len(cfg.FieldMap) > 0 {
+		var mapped []string
+		for field, source := range cfg.FieldMap {
+			mapped = append(mapped, field+" from "+source)
+		}
+		sort.Strings(mapped)
+		line("Fields", "%s", strings.Join(mapped, ", "))
+	}
+	if cfg.Reparse || cfg.SchemaOut != "" || cfg.CompareSchema || cfg.Distinct != "" || cfg.CountOnly {
+		return
+	}
+
+	var filters []string
+	if len(cfg.Companies) > 0 && fromFile() {
+		filters = append(filters, "companies "+strings.Join(cfg.Companies, ", "))
+	}
+	if cfg.Complete != nil {
+		filters = append(filters, "only trips with "+strings.Join(cfg.Complete.fields, ", "))
+	}
+	if cfg.MaxPerTaxi > 0 {
+		filters = append(filters, fmt.Sprintf("at most %d trips per taxi", cfg.MaxPerTaxi))
+	}
+	if cfg.ServerSample > 0 {
+		filters = append(filters, fmt.Sprintf("a sample of about %d rows", cfg.ServerSample))
+	}
+	if len(filters) > 0 {
+		line("Keep", "%s", strings.Join(filters, "; "))
+	}
+
+	switch {
+	case cfg.IDChunks != nil:
+		line("Paging", "%d trip_ids from -ids-file in %d $where trip_id in (...) queries", idCount(), len(cfg.IDChunks))
+	case fromFile():
+		line("Paging", "records in file order, 100 per batch")
+	case cfg.Keyset && !cfg.StartFromDate.IsZero():
+		line("Paging", "keyset by trip_start_timestamp, trip_id from %s, 100 rows per page", cfg.StartFromDate.Format(ctLayout))
+	case cfg.Keyset:
+		line("Paging", "keyset by trip_id, 100 rows per page")
+	default:
+		line("Paging", "$offset, 100 rows per page")
+	}
+	if cfg.ResumeFile != "" {
+		line("Resume", "cursor saved in %s", cfg.ResumeFile)
+	}
+	if cfg.MaxOffset > 0 {
+		line("Stop", "after offset %d", cfg.MaxOffset)
+	}
+	if cfg.MaxIdleTime > 0 {
+		line("Stop", "after %s without new rows", cfg.MaxIdleTime)
+	}
+	if cfg.MaxMemory > 0 {
+		line("Memory", "reading paused while the heap is over %d MiB and inserts are queued, checked every %s", cfg.MaxMemory>>20, cfg.MemoryInterval)
+	}
+	if !fromFile() {
+		jitter := ""
+		if cfg.RetryJitter {
+			jitter = ", jittered"
+		}
+		line("Retry", "%d failed fetches in a row, %d count query attempts; waiting %s doubling to %s%s",
+			cfg.MaxConsecutiveErrors, cfg.CountAttempts, cfg.RetryBase, cfg.RetryMax, jitter)
+	}
+
+	var sinks []string
+	if cfg.DB && !cfg.Diff {
+		target := "postgres"
+		if conn, err := connString(); err != nil {
+			target += " (" + err.Error() + ")"
+		} else {
+			target += " " + redactDSN(conn)
+		}
+		sinks = append(sinks, target)
+		for _, dsn := range cfg.ReplicaDSNs {
+			sinks = append(sinks, fmt.Sprintf("replica %s (errors %s)", redactDSN(dsn), cfg.ReplicaErrors))
+		}
+	}
+	if cfg.CSVPath != "" {
+		sinks = append(sinks, "CSV "+cfg.CSVPath)
+	}
+	if cfg.JSONLPath != "" {
+		sinks = append(sinks, "JSONL "+cfg.JSONLPath)
+	}
+	if cfg.StdoutNDJSON {
+		sinks = append(sinks, "JSON Lines on stdout")
+	}
+	if cfg.GeoJSONPath != "" {
+		sinks = append(sinks, "GeoJSON "+cfg.GeoJSONPath)
+	}
+	if len(cfg.KafkaBrokers) > 0 {
+		sinks = append(sinks, fmt.Sprintf("Kafka topic %s on %s (errors %s)", cfg.KafkaTopic, strings.Join(cfg.KafkaBrokers, ", "), cfg.KafkaErrors))
+	}
+	if cfg.Report != "" {
+		sinks = append(sinks, cfg.Report+" report")
+	}
+	if cfg.Diff {
+		sinks = append(sinks, "diff against postgres")
+	}
+	if len(sinks) == 0 {
+		sinks = append(sinks, "none")
+	}
+	line("Output", "%s", strings.Join(sinks, "; "))
+
+	if cfg.DB && !cfg.Diff {
+		table, columns := "taxi_trips and taxi_trips_anomalies", []string{}
+		switch l := cfg.Loader.(type) {
+		case *fieldsSpec:
+			table = l.Table
+		case tnpSchema:
+			table = "tnp_trips"
+		}
+		if cfg.WithProvenance {
+			columns = append(columns, provenanceColumns...)
+		}
+		if cfg.StoreRaw {
+			columns = append(columns, rawColumn)
+		}
+		if cfg.Shards > 0 {
+			table = fmt.Sprintf("%s, with trips spread over taxi_trips_0 to taxi_trips_%d by trip_id hash", table, cfg.Shards-1)
+		}
+		if r := cfg.PartitionRange; r != nil {
+			table = fmt.Sprintf("%s, with taxi_trips partitioned by month of trip_start_timestamp (%d partitions for %s and a default one)", table, r.months(), r)
+		}
+		line("Table", "%s, created if missing and never truncated", table)
+		if len(columns) > 0 {
+			line("Columns", "adds %s if missing", strings.Join(columns, ", "))
+		}
+		line("Retry", "up to %d inserts of a batch on lost connections and deadlocks", cfg.DBAttempts)
+		if cfg.DBLoadThreshold > 0 {
+			line("Throttle", "inserts slowed while more than %d sessions are active, checked every %s", cfg.DBLoadThreshold, cfg.DBLoadInterval)
+		}
+		if cfg.Analyze {
+			command := "ANALYZE"
+			if cfg.Vacuum {
+				command = "VACUUM (ANALYZE)"
+			}
+			line("After", "%s %s", command, strings.Join(analyzeTables(), ", "))
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+)
+
+// partialFile is an export written to path+".partial" and renamed to path
+// only when it is committed without error and the run did not fail, so a
+// failed run never leaves a truncated file that looks complete. If the
+// process is killed the .partial file stays behind; the next run overwrites
+// it.
+type partialFile struct {
+	path    string
+	f       *os.File
+	failed  bool
+	aborted error // the run's error, once it has failed
+}
+
+func createPartial(path string) (*partialFile, error) {
+	f, err := os.Create(path + ".partial")
+	if err != nil {
+		return nil, err
+	}
+	return &partialFile{path: path, f: f}, nil
+}
+
+func (p *partialFile) Write(b []byte) (int, error) {
+	n, err := p.f.Write(b)
+	if err != nil {
+		p.failed = true
+	}
+	return n, err
+}
+
+// abort records that the run failed with err, so commit does not move the
+// file into place.
+func (p *partialFile) abort(err error) {
+	p.aborted = err
+}
+
+// commit closes the file and moves it into place, unless err is set, a
+// write failed or the run was aborted, in which case it is removed or, with
+// -keep-partial, left for inspection.
+func (p *partialFile) commit(err error) error {
+	if cerr := p.f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !p.failed && p.aborted == nil {
+		return os.Rename(p.f.Name(), p.path)
+	}
+	if cfg.KeepPartial {
+		log.Printf("Kept partial export %s\n", p.f.Name())
+	} else {
+		os.Remove(p.f.Name())
+	}
+	if err == nil && !p.failed {
+		// The run reports its own error.
+		log.Printf("%s not written: the run failed\n", p.path)
+		return nil
+	}
+	if err == nil {
+		err = fmt.Errorf("%s not written: an earlier write failed", p.path)
+	}
+	return err
+}
+
+// CSVSink writes trips to a CSV file with a header of sourceColumns, followed
+// by provenanceColumns with -with-provenance.
+type CSVSink struct {
+	path string
+	f    *partialFile
+	w    *csv.Writer
+}
+
+func newCSVSink(path string) (*CSVSink, error) {
+	f, err := createPartial(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &CSVSink{path: path, f: f, w: csv.NewWriter(f)}
+	header := sourceColumns
+	if cfg.WithProvenance {
+		header = append(append([]string{}, sourceColumns...), provenanceColumns...)
+	}
+	if err := s.w.Write(header); err != nil {
+		f.commit(err)
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *CSVSink) Name() string { return s.path }
+
+func (s *CSVSink) Write(ctx context.Context, b batch) error {
+	for _, trip := range b.trips {
+		record := csvRecord(trip)
+		if cfg.WithProvenance {
+			record = append(record, provenanceText(b)...)
+		}
+		if err := s.w.Write(record); err != nil {
+			s.f.failed = true
+			return err
+		}
+	}
+	// Flush per batch so the .partial file grows as the run goes and at
+	// most one batch is buffered.
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		s.f.failed = true
+		return err
+	}
+	return nil
+}
+
+func (s *CSVSink) abort(err error) { s.f.abort(err) }
+
+func (s *CSVSink) Close() error {
+	s.w.Flush()
+	return s.f.commit(s.w.Error())
+}
+
+// JSONLSink writes one trip per line in the API's JSON format, so the file
+// decodes back into data_fetched.
+type JSONLSink struct {
+	path string
+	f    *partialFile
+	w    *bufio.Writer
+	enc  *json.Encoder
+}
+
+func newJSONLSink(path string) (*JSONLSink, error) {
+	f, err := createPartial(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	return &JSONLSink{path: path, f: f, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+func (s *JSONLSink) Name() string { return s.path }
+
+func (s *JSONLSink) Write(ctx context.Context, b batch) error {
+	for _, trip := range b.trips {
+		if err := s.enc.Encode(exportTrip(trip, b)); err != nil {
+			s.f.failed = true
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *JSONLSink) Flush() error {
+	if err := s.w.Flush(); err != nil {
+		s.f.failed = true
+		return err
+	}
+	return nil
+}
+
+func (s *JSONLSink) abort(err error) { s.f.abort(err) }
+
+func (s *JSONLSink) Close() error {
+	return s.f.commit(s.w.Flush())
+}
+
+// NDJSONSink streams trips to a writer, stdout for -stdout-ndjson, one JSON
+// object per line in the -jsonl format. Each batch is flushed as soon as it
+// is written, so a reading process sees trips as they are fetched.
+type NDJSONSink struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func newNDJSONSink(w io.Writer) *NDJSONSink {
+	bw := bufio.NewWriter(w)
+	return &NDJSONSink{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (s *NDJSONSink) Name() string { return "stdout" }
+
+func (s *NDJSONSink) Write(ctx context.Context, b batch) error {
+	for _, trip := range b.trips {
+		if err := s.enc.Encode(exportTrip(trip, b)); err != nil {
+			return err
+		}
+	}
+	return s.w.Flush()
+}
+
+func (s *NDJSONSink) Close() error {
+	return s.w.Flush()
+}
+
+// exportTrip returns the value a trip of b is encoded as in JSON exports:
+// the trip with its timestamps in -tz-output, and its provenance with
+// -with-provenance.
+func exportTrip(trip data_fetched, b batch) any {
+	trip.TripStartTimestamp = exportTime(trip.TripStartTimestamp)
+	trip.TripEndTimestamp = exportTime(trip.TripEndTimestamp)
+	if cfg.WithProvenance {
+		return withProvenance(trip, b)
+	}
+	return trip
+}
+
+// csvRecord renders a trip in sourceColumns order, with floats at full
+// precision unless -float-precision sets their decimals. Fields that were
+// absent from the source JSON are written as -csv-null, so a missing fare is
+// not confused with a zero one. Text fields are treated as absent when empty,
+// since the API omits null text rather than sending "".
+func csvRecord(t data_fetched) []string {
+	str := func(s string) string {
+		if s == "" {
+			return cfg.CSVNull
+		}
+		return s
+	}
+	ts := func(ct CustomTime) string {
+		if !ct.Valid {
+			return cfg.CSVNull
+		}
+		return exportTime(ct).format()
+	}
+	num := func(ci CustomInt) string {
+		if !ci.Valid {
+			return cfg.CSVNull
+		}
+		return strconv.Itoa(ci.Int)
+	}
+	float := func(column string, cf CustomFloat64) string {
+		if !cf.Valid {
+			return cfg.CSVNull
+		}
+		return formatFloat(column, cf.Float64, -1)
+	}
+	point := func(l Location) string {
+		if l.Type == "" {
+			return cfg.CSVNull
+		}
+		return fmt.Sprint(l.wkt())
+	}
+
+	return []string{
+		str(t.TripID), str(t.TaxiID), ts(t.TripStartTimestamp), ts(t.TripEndTimestamp),
+		num(t.TripSeconds), float("trip_miles", t.TripMiles), str(t.PickupCensusTract), str(t.DropoffCensusTract),
+		num(t.PickupCommunityArea), num(t.DropoffCommunityArea),
+		float("fare", t.Fare), float("tips", t.Tips), float("tolls", t.Tolls), float("extras", t.Extras),
+		float("trip_total", t.TripTotal), str(t.PaymentType), str(t.Company),
+		float("pickup_centroid_latitude", t.PickupCentroidLatitude), float("pickup_centroid_longitude", t.PickupCentroidLongitude),
+		point(t.PickupCentroidLocation),
+		float("dropoff_centroid_latitude", t.DropoffCentroidLatitude), float("dropoff_centroid_longitude", t.DropoffCentroidLongitude),
+		point(t.DropoffCentroidLocation),
+	}
+}
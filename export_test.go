@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestCSVSinkFlushesPerBatch checks that each batch reaches the .partial
+// file as soon as it is written, and that Close moves the file into place.
+func TestCSVSinkFlushesPerBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trips.csv")
+	withConfig(t, func(c *config) { c.WithProvenance = false })
+	sink, err := newCSVSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var size int64
+	for i, id := range []string{"a", "b", "c"} {
+		if err := sink.Write(context.Background(), batch{trips: []data_fetched{sampleTrip(t, id)}}); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path + ".partial")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() <= size {
+			t.Errorf("after batch %d the file is %d bytes, was %d", i+1, info.Size(), size)
+		}
+		size = info.Size()
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("%s exists before Close (stat: %v)", path, err)
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".partial"); !os.IsNotExist(err) {
+		t.Errorf(".partial file left after Close (stat: %v)", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) != size {
+		t.Errorf("closed file is %d bytes, want the %d flushed", len(data), size)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 4 {
+		t.Errorf("file has %d lines, want a header and 3 trips", lines)
+	}
+}
+
+// TestExportsKeptOnlyOnSuccess runs the fetch loop with file exports: a run
+// that ends cleanly moves them into place, and one that gives up after a
+// fetch error mid-run leaves neither the export nor its .partial file.
+func TestExportsKeptOnlyOnSuccess(t *testing.T) {
+	tests := []struct {
+		name    string
+		fail    bool
+		wantErr bool
+	}{
+		{"clean end", false, false},
+		{"fetch error mid-run", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch n := requests.Add(1); {
+				case n == 1:
+					fmt.Fprint(w, `[{"trip_id":"a"},{"trip_id":"b"}]`)
+				case tt.fail:
+					http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				default:
+					fmt.Fprint(w, `[]`)
+				}
+			}))
+			defer srv.Close()
+			dir := t.TempDir()
+			paths := []string{filepath.Join(dir, "trips.csv"), filepath.Join(dir, "trips.jsonl"), filepath.Join(dir, "trips.geojson")}
+			withConfig(t, func(c *config) {
+				c.DatasetURL = srv.URL + "/resource.json"
+				c.DB = false
+				c.SummaryInterval = 0
+				c.MaxConsecutiveErrors = 1
+				c.KeepPartial = false
+				c.CSVPath, c.JSONLPath, c.GeoJSONPath = paths[0], paths[1], paths[2]
+			})
+
+			_, err := runFetch(t)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("run error = %v, want error %v", err, tt.wantErr)
+			}
+			for _, path := range paths {
+				_, statErr := os.Stat(path)
+				if exists := statErr == nil; exists == tt.fail {
+					t.Errorf("%s exists: %v, want %v", filepath.Base(path), exists, !tt.fail)
+				}
+				if _, err := os.Stat(path + ".partial"); !os.IsNotExist(err) {
+					t.Errorf("%s.partial left behind (stat: %v)", filepath.Base(path), err)
+				}
+			}
+		})
+	}
+}
+
+// TestCSVSinkWriteFailure fails the file under the CSV sink mid-run: the
+// sink reports the error and no export is left behind.
+func TestCSVSinkWriteFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trips.csv")
+	withConfig(t, func(c *config) {
+		c.WithProvenance = false
+		c.KeepPartial = false
+	})
+	sink, err := newCSVSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(context.Background(), batch{trips: []data_fetched{sampleTrip(t, "a")}}); err != nil {
+		t.Fatal(err)
+	}
+	sink.f.f.Close() // the disk goes away
+	if err := sink.Write(context.Background(), batch{trips: []data_fetched{sampleTrip(t, "b")}}); err == nil {
+		t.Error("Write to a closed file succeeded")
+	}
+	if err := sink.Close(); err == nil {
+		t.Error("Close after a failed write succeeded")
+	}
+	for _, p := range []string{path, path + ".partial"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s left behind (stat: %v)", filepath.Base(p), err)
+		}
+	}
+}
+
+// TestAbortedExportKept leaves the .partial file of a failed run with
+// -keep-partial.
+func TestAbortedExportKept(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trips.jsonl")
+	withConfig(t, func(c *config) { c.KeepPartial = true })
+	sink, err := newJSONLSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(context.Background(), batch{trips: []data_fetched{sampleTrip(t, "a")}}); err != nil {
+		t.Fatal(err)
+	}
+	closeSinks([]Sink{sink}, errors.New("giving up"))
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("export of a failed run moved into place (stat: %v)", err)
+	}
+	if data, err := os.ReadFile(path + ".partial"); err != nil || len(data) == 0 {
+		t.Errorf("partial export = %d bytes, %v, want the trip written", len(data), err)
+	}
+}
+
+// TestStdoutNDJSON fetches two pages with -stdout-ndjson and reads back
+// stdout: one JSON object per trip, in fetch order, and nothing else.
+func TestStdoutNDJSON(t *testing.T) {
+	fetchTestServer(t, `[{"trip_id":"a"},{"trip_id":"b"}]`, `[{"trip_id":"c"}]`)
+	withConfig(t, func(c *config) {
+		c.StdoutNDJSON = true
+		c.WithProvenance = false
+	})
+	out, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	stdout := os.Stdout
+	os.Stdout = out
+	_, err = runFetch(t)
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	var ids []string
+	for _, line := range lines {
+		var trip struct {
+			TripID string `json:"trip_id"`
+		}
+		if err := json.Unmarshal([]byte(line), &trip); err != nil {
+			t.Fatalf("stdout line %q is not a trip: %v", line, err)
+		}
+		ids = append(ids, trip.TripID)
+	}
+	if got := strings.Join(ids, ","); got != "a,b,c" {
+		t.Errorf("stdout has trips %s, want a,b,c:\n%s", got, data)
+	}
+}
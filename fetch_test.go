@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fetchTestServer serves the dataset from pages, one per request in order,
+// and an empty page once they run out.
+func fetchTestServer(t *testing.T, pages ...string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		if n > len(pages) {
+			fmt.Fprint(w, `[]`)
+			return
+		}
+		fmt.Fprint(w, pages[n-1])
+	}))
+	t.Cleanup(srv.Close)
+	withConfig(t, func(c *config) {
+		c.DatasetURL = srv.URL + "/resource.json"
+		c.DB = false
+		c.SummaryInterval = 0 // no count query
+	})
+	return srv, &requests
+}
+
+// runFetch runs the fetch loop, failing the test if it does not finish.
+func runFetch(t *testing.T) (int, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	type result struct {
+		rows int
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		rows, err := fetchAndPrinttaxitrips(ctx, ctx, nil, nil, &progress{start: time.Now()})
+		done <- result{rows, err}
+	}()
+	select {
+	case r := <-done:
+		return r.rows, r.err
+	case <-time.After(5 * time.Second):
+		t.Fatal("fetchAndPrinttaxitrips did not return")
+		return 0, nil
+	}
+}
+
+func TestFetchStopsOnEmptyPage(t *testing.T) {
+	tests := []struct {
+		name         string
+		pages        []string
+		wantRows     int
+		wantRequests int32
+	}{
+		{"empty dataset", nil, 0, 1},
+		{"after two pages", []string{`[{"trip_id":"a"},{"trip_id":"b"}]`, `[{"trip_id":"c"}]`}, 3, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, requests := fetchTestServer(t, tt.pages...)
+			rows, err := runFetch(t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rows != tt.wantRows || requests.Load() != tt.wantRequests {
+				t.Errorf("fetched %d rows in %d requests, want %d rows in %d", rows, requests.Load(), tt.wantRows, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestFetchIdleExit(t *testing.T) {
+	fetchTestServer(t, `[{"trip_id":"a"}]`)
+	withConfig(t, func(c *config) { c.MaxIdleTime = time.Nanosecond })
+	rows, err := runFetch(t)
+	if code := outcomeCode(err, false, rows, 0); rows != 1 || code != exitOK {
+		t.Errorf("idle exit fetched %d rows with exit code %d (%v), want 1 row and code 0", rows, code, err)
+	}
+}
+
+func TestFetchPageMaxResponseBytes(t *testing.T) {
+	page := `[{"trip_id":"a"}]`
+	tests := []struct {
+		name    string
+		body    string
+		limit   int64
+		wantErr bool
+	}{
+		{"exactly at the limit", page, int64(len(page)), false},
+		{"one byte over", page, int64(len(page)) - 1, true},
+		{"oversized", "[" + strings.Repeat(`{"trip_id":"a"},`, 1<<16) + `{"trip_id":"a"}]`, 1 << 10, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tt.body)
+			}))
+			defer srv.Close()
+			withConfig(t, func(c *config) { c.MaxResponseBytes = tt.limit })
+
+			records, _, err := fetchPage(context.Background(), srv.URL)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "-max-response-bytes") {
+					t.Errorf("fetchPage returned %d records and error %v, want the -max-response-bytes error", len(records), err)
+				}
+				return
+			}
+			if err != nil || len(records) != 1 {
+				t.Errorf("fetchPage returned %d records and error %v, want 1 record", len(records), err)
+			}
+		})
+	}
+}
+
+// TestStartFromDatePages checks the queries of the first two pages with
+// -start-from-date: both keep the lower bound, and the second pages on from
+// the last trip of the first by timestamp, then trip_id among trips that
+// started at the same time.
+func TestStartFromDatePages(t *testing.T) {
+	queries := make(chan url.Values, 2)
+	pages := []string{`[{"trip_id":"a","trip_start_timestamp":"2023-01-01T00:15:00.000"},` +
+		`{"trip_id":"b","trip_start_timestamp":"2023-01-01T00:15:00.000"}]`}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case queries <- r.URL.Query():
+		default:
+		}
+		if len(pages) == 0 {
+			fmt.Fprint(w, `[]`)
+			return
+		}
+		fmt.Fprint(w, pages[0])
+		pages = pages[1:]
+	}))
+	t.Cleanup(srv.Close)
+	withConfig(t, func(c *config) {
+		c.DatasetURL = srv.URL + "/resource.json"
+		c.DB = false
+		c.SummaryInterval = 0
+		c.Keyset = true
+		c.StartFromDate = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	})
+	if _, err := runFetch(t); err != nil {
+		t.Fatal(err)
+	}
+
+	const bound = "trip_start_timestamp >= '2023-01-01T00:00:00.000'"
+	want := []string{
+		bound,
+		"(" + bound + ") AND (trip_start_timestamp > '2023-01-01T00:15:00.000' OR " +
+			"(trip_start_timestamp = '2023-01-01T00:15:00.000' AND trip_id > 'b'))",
+	}
+	for i, w := range want {
+		q := <-queries
+		if got := q.Get("$where"); got != w {
+			t.Errorf("page %d $where = %q, want %q", i+1, got, w)
+		}
+		if got := q.Get("$order"); got != "trip_start_timestamp, trip_id" {
+			t.Errorf("page %d $order = %q, want by timestamp, then trip_id", i+1, got)
+		}
+		if q.Has("$offset") {
+			t.Errorf("page %d has an $offset", i+1)
+		}
+	}
+}
+
+// TestCountTimeoutFallback makes the count query outlast -count-timeout:
+// the run still fetches, without a total to report progress against.
+func TestCountTimeoutFallback(t *testing.T) {
+	var counts, pages atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Query().Get("$select"), "count(*)") {
+			counts.Add(1)
+			<-r.Context().Done()
+			return
+		}
+		if pages.Add(1) > 1 {
+			fmt.Fprint(w, `[]`)
+			return
+		}
+		fmt.Fprint(w, `[{"trip_id":"a"}]`)
+	}))
+	t.Cleanup(srv.Close)
+	withConfig(t, func(c *config) {
+		c.DatasetURL = srv.URL + "/resource.json"
+		c.DB = false
+		c.SummaryInterval = time.Hour
+		c.CountTimeout = 50 * time.Millisecond
+	})
+
+	_, err := fetchTotalCount(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "no answer within -count-timeout 50ms") {
+		t.Errorf("fetchTotalCount error = %v, want the -count-timeout", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	prog := &progress{start: time.Now()}
+	rows, err := fetchAndPrinttaxitrips(ctx, ctx, nil, nil, prog)
+	if err != nil {
+		t.Fatalf("run failed on a slow count query: %v", err)
+	}
+	if rows != 1 {
+		t.Errorf("fetched %d rows, want 1", rows)
+	}
+	if counts.Load() < 2 {
+		t.Errorf("count query asked %d times, want at least once per call", counts.Load())
+	}
+	if total := prog.total; total != 0 {
+		t.Errorf("progress total = %d after the count timed out, want unknown", total)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// parseFieldMap parses -field-map, a comma-separated list of field=source
+// pairs naming the dataset column that holds each trip field, e.g.
+// fare=total_fare. Fields are the JSON names of data_fetched.
+func parseFieldMap(s string) (map[string]string, error) {
+	fields := make(map[string]string)
+	sources := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		field, source, ok := strings.Cut(item, "=")
+		field, source = strings.TrimSpace(field), strings.TrimSpace(source)
+		if !ok {
+			return nil, fmt.Errorf("%q is not field=source", item)
+		}
+		if !knownFields[field] {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		if !identifier.MatchString(source) {
+			return nil, fmt.Errorf("%s: invalid source name %q", field, source)
+		}
+		if _, ok := fields[field]; ok {
+			return nil, fmt.Errorf("%s is mapped twice", field)
+		}
+		if other, ok := sources[source]; ok {
+			return nil, fmt.Errorf("%s is mapped to both %s and %s", source, other, field)
+		}
+		fields[field], sources[source] = source, field
+	}
+	return fields, nil
+}
+
+// sourceField returns the dataset column holding field, for the SoQL the
+// tool builds itself. -where is sent as written.
+func sourceField(field string) string {
+	if source, ok := cfg.FieldMap[field]; ok {
+		return source
+	}
+	return field
+}
+
+// remapRecord renames the mapped source columns of a record to their field
+// names, so it decodes into data_fetched. A record without the source
+// column is left as it is and the field stays absent. The record is rebuilt
+// from its original columns, so mappings that swap two columns read each
+// value before either is replaced.
+func remapRecord(record json.RawMessage, fieldMap map[string]string) (json.RawMessage, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(record, &raw); err != nil {
+		return nil, err
+	}
+	sources := make(map[string]bool, len(fieldMap))
+	for _, source := range fieldMap {
+		sources[source] = true
+	}
+	remapped := make(map[string]json.RawMessage, len(raw))
+	for name, v := range raw {
+		if !sources[name] {
+			remapped[name] = v
+		}
+	}
+	changed := false
+	for field, source := range fieldMap {
+		if v, ok := raw[source]; ok {
+			remapped[field] = v
+			changed = true
+		}
+	}
+	if !changed {
+		return record, nil
+	}
+	return json.Marshal(remapped)
+}
+
+// remapRecords applies remapRecord to a page in place. A record that is not
+// a JSON object is left for the decode step to report.
+func remapRecords(records []json.RawMessage) {
+	for i, record := range records {
+		if remapped, err := remapRecord(record, cfg.FieldMap); err == nil {
+			records[i] = remapped
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseFieldMap(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"fare=total_fare, tips=tip_amount", map[string]string{"fare": "total_fare", "tips": "tip_amount"}, false},
+		{"fare=tips,tips=fare", map[string]string{"fare": "tips", "tips": "fare"}, false},
+		{"fare", nil, true},
+		{"surge=surge_fee", nil, true},
+		{"fare=total fare", nil, true},
+		{"fare=a,fare=b", nil, true},
+		{"fare=a,tips=a", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseFieldMap(tt.in)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("parseFieldMap(%q) = %v, %v, want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRemapRecord(t *testing.T) {
+	tests := []struct {
+		name     string
+		fieldMap map[string]string
+		record   string
+		want     map[string]string
+	}{
+		{"two fields", map[string]string{"fare": "total_fare", "tips": "tip_amount"},
+			`{"trip_id":"a","total_fare":"12.5","tip_amount":"2"}`,
+			map[string]string{"trip_id": `"a"`, "fare": `"12.5"`, "tips": `"2"`}},
+		{"swap", map[string]string{"fare": "tips", "tips": "fare"},
+			`{"trip_id":"a","fare":"12.5","tips":"2"}`,
+			map[string]string{"trip_id": `"a"`, "fare": `"2"`, "tips": `"12.5"`}},
+		{"source absent", map[string]string{"fare": "total_fare"},
+			`{"trip_id":"a","fare":"1"}`,
+			map[string]string{"trip_id": `"a"`, "fare": `"1"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Map iteration order varies, so a result that depends on it
+			// shows up over a few runs.
+			for i := 0; i < 20; i++ {
+				remapped, err := remapRecord(json.RawMessage(tt.record), tt.fieldMap)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got map[string]json.RawMessage
+				if err := json.Unmarshal(remapped, &got); err != nil {
+					t.Fatal(err)
+				}
+				gotText := make(map[string]string, len(got))
+				for k, v := range got {
+					gotText[k] = string(v)
+				}
+				if !reflect.DeepEqual(gotText, tt.want) {
+					t.Fatalf("remapRecord = %v, want %v", gotText, tt.want)
+				}
+			}
+		})
+	}
+
+	if _, err := remapRecord(json.RawMessage(`[1]`), map[string]string{"fare": "total_fare"}); err == nil {
+		t.Error("remapRecord accepted a record that is not an object")
+	}
+}
+
+// The remapped record decodes into the trip fields.
+func TestRemapRecordsDecode(t *testing.T) {
+	withConfig(t, func(c *config) { c.FieldMap = map[string]string{"fare": "total_fare", "company": "operator"} })
+	records := []json.RawMessage{[]byte(`{"trip_id":"a","total_fare":"12.5","operator":"Flash Cab"}`)}
+	remapRecords(records)
+	var trip data_fetched
+	if err := json.Unmarshal(records[0], &trip); err != nil {
+		t.Fatal(err)
+	}
+	if trip.Fare.Float64 != 12.5 || trip.Company != "Flash Cab" {
+		t.Errorf("decoded fare %v and company %q, want 12.5 and Flash Cab", trip.Fare.Float64, trip.Company)
+	}
+}
+
+func TestFieldMapDistinctAndCompare(t *testing.T) {
+	var selects []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		selects = append(selects, r.URL.Query().Get("$select"))
+		fmt.Fprint(w, `[{"operator":"Flash Cab","count":"3"}]`)
+	}))
+	defer srv.Close()
+	withConfig(t, func(c *config) {
+		c.DatasetURL = srv.URL + "/resource.json"
+		c.FieldMap = map[string]string{"company": "operator"}
+		c.Loader = nil
+	})
+
+	values, err := fetchDistinct("company")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "operator, count(*) AS count"; len(selects) != 1 || selects[0] != want {
+		t.Errorf("$select = %q, want %q", selects, want)
+	}
+	if len(values) != 1 || values[0].Value != "Flash Cab" || values[0].Count != 3 {
+		t.Errorf("distinct values = %+v, want Flash Cab 3 times", values)
+	}
+
+	model := modelTypes()
+	if _, ok := model["operator"]; !ok {
+		t.Error("compare does not expect the company field in the operator column")
+	}
+	if _, ok := model["company"]; ok {
+		t.Error("compare expects a company column despite -field-map")
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// fieldsSpec describes a dataset that is loaded without the typed
+// data_fetched path. It is read from the -fields-file, for example:
+//
+//	{
+//	  "table": "building_permits",
+//	  "key": "id",
+//	  "fields": [
+//	    {"name": "id", "type": "text"},
+//	    {"name": "issue_date", "type": "timestamp"},
+//	    {"name": "total_fee", "type": "float"}
+//	  ]
+//	}
+type fieldsSpec struct {
+	Table  string      `json:"table"`
+	Key    string      `json:"key"`
+	Fields []fieldSpec `json:"fields"`
+}
+
+type fieldSpec struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// fieldTypes maps the types accepted in a fields file to their column types.
+var fieldTypes = map[string]string{
+	"text":      "TEXT",
+	"integer":   "BIGINT",
+	"float":     "FLOAT",
+	"boolean":   "BOOLEAN",
+	"timestamp": "TIMESTAMP",
+	"json":      "JSONB",
+}
+
+// identifier limits table and field names to ones that are also valid JSON
+// keys without escaping. They are quoted in SQL, so reserved words and
+// mixed case are fine.
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func loadFieldsFile(path string) (*fieldsSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec fieldsSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if !identifier.MatchString(spec.Table) {
+		return nil, fmt.Errorf("%s: invalid table name %q", path, spec.Table)
+	}
+	if len(spec.Fields) == 0 {
+		return nil, fmt.Errorf("%s: no fields", path)
+	}
+	hasKey := false
+	for _, f := range spec.Fields {
+		if !identifier.MatchString(f.Name) {
+			return nil, fmt.Errorf("%s: invalid field name %q", path, f.Name)
+		}
+		if _, ok := fieldTypes[f.Type]; !ok {
+			return nil, fmt.Errorf("%s: field %s: unknown type %q", path, f.Name, f.Type)
+		}
+		hasKey = hasKey || f.Name == spec.Key
+	}
+	if spec.Key != "" && !hasKey {
+		return nil, fmt.Errorf("%s: key %q is not a field", path, spec.Key)
+	}
+	return &spec, nil
+}
+
+// names returns the set of JSON keys the spec models, for checkSchemaDrift.
+func (s *fieldsSpec) names() map[string]bool {
+	names := make(map[string]bool, len(s.Fields))
+	for _, f := range s.Fields {
+		names[f.Name] = true
+	}
+	return names
+}
+
+func (s *fieldsSpec) createTable(ctx context.Context, db *sql.DB) error {
+	cols := make([]string, len(s.Fields))
+	for i, f := range s.Fields {
+		cols[i] = pq.QuoteIdentifier(f.Name) + " " + fieldTypes[f.Type]
+		if f.Name == s.Key {
+			cols[i] += " PRIMARY KEY"
+		}
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", pq.QuoteIdentifier(s.Table), strings.Join(cols, ", ")))
+	return err
+}
+
+func (s *fieldsSpec) insertSQL() string {
+	cols := make([]string, len(s.Fields))
+	for i, f := range s.Fields {
+		cols[i] = f.Name
+	}
+	return upsertSQL(s.Table, cols, s.Key)
+}
+
+// decode coerces a raw record into column values ordered like s.Fields.
+// Fields absent from the record become NULL.
+func (s *fieldsSpec) decode(record json.RawMessage) ([]any, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(record, &raw); err != nil {
+		return nil, err
+	}
+	values := make([]any, len(s.Fields))
+	for i, f := range s.Fields {
+		b, ok := raw[f.Name]
+		if !ok || string(b) == "null" {
+			continue
+		}
+		v, err := coerceField(f.Type, b)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", f.Name, err)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// coerceField converts a JSON value to the Go value stored for typ. Socrata
+// sends most scalars as strings, so both strings and bare values are
+// accepted.
+func coerceField(typ string, b json.RawMessage) (any, error) {
+	if typ == "json" {
+		return string(b), nil
+	}
+
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	str, isString := v.(string)
+	if !isString {
+		str = string(b)
+	}
+
+	switch typ {
+	case "text":
+		return str, nil
+	case "integer":
+		i, err := strconv.ParseInt(str, 10, 64)
+		if err != nil && !cfg.StrictTypes {
+			// Whole numbers sent as floats, like CustomInt.
+			if f, ferr := strconv.ParseFloat(str, 64); ferr == nil && f >= math.MinInt64 && f < math.MaxInt64 {
+				return int64(f), nil
+			}
+		}
+		return i, err
+	case "float":
+		return strconv.ParseFloat(str, 64)
+	case "boolean":
+		return strconv.ParseBool(str)
+	case "timestamp":
+		if t, err := time.Parse(ctLayout, str); err == nil {
+			return t, nil
+		}
+		return time.Parse(time.RFC3339Nano, str)
+	}
+	return nil, fmt.Errorf("unknown type %q", typ)
+}
+
+// insertRecords decodes a page of raw records against the spec and upserts
+// them in a single transaction.
+func (s *fieldsSpec) insertRecords(ctx context.Context, db *sql.DB, records []json.RawMessage) error {
+	rows := make([][]any, len(records))
+	for i, record := range records {
+		values, err := s.decode(record)
+		if err != nil {
+			return fmt.Errorf("record %d: %w", i, err)
+		}
+		rows[i] = values
+	}
+	return insertRows(ctx, db, s.insertSQL(), rows)
+}
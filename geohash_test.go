@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeohashEncode(t *testing.T) {
+	tests := []struct {
+		lat, lon  float64
+		precision int
+		want      string
+	}{
+		// Reference values from the geohash description.
+		{42.6, -5.6, 5, "ezs42"},
+		{57.64911, 10.40744, 11, "u4pruydqqvj"},
+		// Chicago and Sydney.
+		{41.8781, -87.6298, 7, "dp3wjzt"},
+		{-33.8688, 151.2093, 8, "r3gx2f77"},
+		// The corners and the origin.
+		{0, 0, 5, "s0000"},
+		{-90, -180, 5, "00000"},
+		{90, 180, 5, "zzzzz"},
+		{41.8781, -87.6298, 0, ""},
+	}
+	for _, tt := range tests {
+		if got := geohashEncode(tt.lat, tt.lon, tt.precision); got != tt.want {
+			t.Errorf("geohashEncode(%g, %g, %d) = %q, want %q", tt.lat, tt.lon, tt.precision, got, tt.want)
+		}
+	}
+}
+
+// A shorter geohash is a prefix of a longer one for the same point.
+func TestGeohashPrefix(t *testing.T) {
+	full := geohashEncode(41.892508, -87.626215, 12)
+	for p := 1; p < 12; p++ {
+		if got := geohashEncode(41.892508, -87.626215, p); !strings.HasPrefix(full, got) || len(got) != p {
+			t.Errorf("precision %d: %q is not the %d-character prefix of %q", p, got, p, full)
+		}
+	}
+}
+
+func TestPickupGeohash(t *testing.T) {
+	trip := func(lat, lon float64) columnRow {
+		var r columnRow
+		r.trip.PickupCentroidLatitude.Float64, r.trip.PickupCentroidLongitude.Float64 = lat, lon
+		return r
+	}
+	tests := []struct {
+		name      string
+		row       columnRow
+		precision int
+		want      any
+	}{
+		{"located", trip(41.8781, -87.6298), 7, "dp3wjzt"},
+		{"precision", trip(41.8781, -87.6298), 4, "dp3w"},
+		{"no location", trip(0, 0), 7, nil},
+		{"disabled", trip(41.8781, -87.6298), 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *config) { c.GeohashPrecision = tt.precision })
+			if got := pickupGeohash(tt.row); got != tt.want {
+				t.Errorf("pickupGeohash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
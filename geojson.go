@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+)
+
+// GeoJSONSink writes trips as a GeoJSON FeatureCollection of pickup points.
+// Features are streamed as batches arrive and the collection is closed by
+// Close. Trips without pickup coordinates are skipped and counted.
+type GeoJSONSink struct {
+	path    string
+	f       *partialFile
+	w       *bufio.Writer
+	enc     *json.Encoder
+	written int
+	missing int
+}
+
+// tripFeature is one trip as a GeoJSON Point feature.
+type tripFeature struct {
+	Type       string       `json:"type"`
+	Geometry   pointGeom    `json:"geometry"`
+	Properties featureProps `json:"properties"`
+}
+
+type pointGeom struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // longitude, latitude
+}
+
+type featureProps struct {
+	TripID    string     `json:"trip_id"`
+	Fare      *float64   `json:"fare"`
+	Company   string     `json:"company,omitempty"`
+	StartTime CustomTime `json:"trip_start_timestamp"`
+	EndTime   CustomTime `json:"trip_end_timestamp"`
+}
+
+func newGeoJSONSink(path string) (*GeoJSONSink, error) {
+	f, err := createPartial(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	if _, err := w.WriteString(`{"type":"FeatureCollection","features":[` + "\n"); err != nil {
+		f.commit(err)
+		return nil, err
+	}
+	return &GeoJSONSink{path: path, f: f, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+func (s *GeoJSONSink) Name() string { return s.path }
+
+func (s *GeoJSONSink) Write(ctx context.Context, b batch) error {
+	for _, trip := range b.trips {
+		lon, lat, ok := pickupPoint(trip)
+		if !ok {
+			s.missing++
+			continue
+		}
+		feature := tripFeature{
+			Type:     "Feature",
+			Geometry: pointGeom{Type: "Point", Coordinates: [2]float64{lon, lat}},
+			Properties: featureProps{
+				TripID:    trip.TripID,
+				Company:   trip.Company,
+				StartTime: exportTime(trip.TripStartTimestamp),
+				EndTime:   exportTime(trip.TripEndTimestamp),
+			},
+		}
+		if trip.Fare.Valid {
+			feature.Properties.Fare = &trip.Fare.Float64
+		}
+		if s.written > 0 {
+			if _, err := s.w.WriteString(","); err != nil {
+				s.f.failed = true
+				return err
+			}
+		}
+		if err := s.enc.Encode(feature); err != nil {
+			s.f.failed = true
+			return err
+		}
+		s.written++
+	}
+	return nil
+}
+
+// pickupPoint returns the pickup centroid, from the latitude and longitude
+// fields or failing that the location point.
+func pickupPoint(t data_fetched) (lon, lat float64, ok bool) {
+	if t.PickupCentroidLatitude.Valid && t.PickupCentroidLongitude.Valid {
+		return t.PickupCentroidLongitude.Float64, t.PickupCentroidLatitude.Float64, true
+	}
+	if t.PickupCentroidLocation.Type == "Point" {
+		return t.PickupCentroidLocation.Coordinates[0], t.PickupCentroidLocation.Coordinates[1], true
+	}
+	return 0, 0, false
+}
+
+func (s *GeoJSONSink) Flush() error {
+	if err := s.w.Flush(); err != nil {
+		s.f.failed = true
+		return err
+	}
+	return nil
+}
+
+func (s *GeoJSONSink) abort(err error) { s.f.abort(err) }
+
+func (s *GeoJSONSink) Close() error {
+	if s.missing > 0 {
+		log.Printf("%s: skipped %d trips without pickup coordinates\n", s.path, s.missing)
+	}
+	_, err := s.w.WriteString("]}\n")
+	if ferr := s.w.Flush(); err == nil {
+		err = ferr
+	}
+	return s.f.commit(err)
+}
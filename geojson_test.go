@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// geoJSONCollection is the shape GeoJSONSink writes, decoded back.
+type geoJSONCollection struct {
+	Type     string `json:"type"`
+	Features []struct {
+		Type     string `json:"type"`
+		Geometry struct {
+			Type        string    `json:"type"`
+			Coordinates []float64 `json:"coordinates"`
+		} `json:"geometry"`
+		Properties map[string]any `json:"properties"`
+	} `json:"features"`
+}
+
+// writeGeoJSON writes batches with a GeoJSONSink and decodes the file.
+func writeGeoJSON(t *testing.T, batches ...[]data_fetched) geoJSONCollection {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "trips.geojson")
+	sink, err := newGeoJSONSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, trips := range batches {
+		if err := sink.Write(context.Background(), batch{trips: trips}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fc geoJSONCollection
+	if err := json.Unmarshal(data, &fc); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, data)
+	}
+	return fc
+}
+
+// TestGeoJSONSinkStructure writes two batches and checks the result is one
+// FeatureCollection of Point features at the pickup, longitude first, with
+// the trip's properties.
+func TestGeoJSONSinkStructure(t *testing.T) {
+	fc := writeGeoJSON(t, []data_fetched{sampleTrip(t, "a")}, []data_fetched{sampleTrip(t, "b")})
+	if fc.Type != "FeatureCollection" {
+		t.Errorf("type = %q, want FeatureCollection", fc.Type)
+	}
+	if len(fc.Features) != 2 {
+		t.Fatalf("%d features, want 2", len(fc.Features))
+	}
+	for i, f := range fc.Features {
+		if f.Type != "Feature" || f.Geometry.Type != "Point" {
+			t.Errorf("feature %d is a %s with a %s geometry, want a Feature with a Point", i, f.Type, f.Geometry.Type)
+		}
+		if c := f.Geometry.Coordinates; len(c) != 2 || c[0] != -87.626215 || c[1] != 41.892508 {
+			t.Errorf("feature %d coordinates = %v, want the pickup as [lon, lat]", i, c)
+		}
+		want := map[string]any{
+			"trip_id":              string(rune('a' + i)),
+			"fare":                 12.25,
+			"company":              "Flash Cab",
+			"trip_start_timestamp": "2023-01-01T00:15:00.000",
+			"trip_end_timestamp":   "2023-01-01T00:30:00.000",
+		}
+		for k, v := range want {
+			if f.Properties[k] != v {
+				t.Errorf("feature %d %s = %v, want %v", i, k, f.Properties[k], v)
+			}
+		}
+		if len(f.Properties) != len(want) {
+			t.Errorf("feature %d properties = %v, want %v", i, f.Properties, want)
+		}
+	}
+}
+
+// A trip without a pickup point is left out of the collection; one with
+// only the location point is placed by it. A non-Point location never
+// reaches the sink: the record fails to decode.
+func TestGeoJSONSinkLocations(t *testing.T) {
+	decode := func(record string) (data_fetched, error) {
+		var trip data_fetched
+		err := json.Unmarshal([]byte(record), &trip)
+		return trip, err
+	}
+	missing, err := decode(`{"trip_id":"missing"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pointOnly, err := decode(`{"trip_id":"point","pickup_centroid_location":{"type":"Point","coordinates":[-87.6,41.9]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = decode(`{"trip_id":"multipoint","pickup_centroid_location":{"type":"MultiPoint","coordinates":[-87.6,41.9]}}`)
+	if err == nil || !strings.Contains(err.Error(), `location type "MultiPoint" is not Point`) {
+		t.Errorf("decoding a MultiPoint pickup location: error = %v", err)
+	}
+
+	fc := writeGeoJSON(t, []data_fetched{missing, pointOnly, sampleTrip(t, "full")})
+	var ids []any
+	for _, f := range fc.Features {
+		ids = append(ids, f.Properties["trip_id"])
+	}
+	if len(ids) != 2 || ids[0] != "point" || ids[1] != "full" {
+		t.Fatalf("features for trips %v, want point and full", ids)
+	}
+	if c := fc.Features[0].Geometry.Coordinates; len(c) != 2 || c[0] != -87.6 || c[1] != 41.9 {
+		t.Errorf("point-only trip at %v, want its location point", c)
+	}
+}
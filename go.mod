@@ -2,9 +2,15 @@ module packages
 
 go 1.22.3
 
-require github.com/lib/pq v1.10.9
+require (
+	github.com/DATA-DOG/go-sqlmock v1.5.2
+	github.com/lib/pq v1.10.9
+	github.com/olekukonko/tablewriter v0.0.5
+	github.com/segmentio/kafka-go v0.4.47
+)
 
 require (
+	github.com/klauspost/compress v1.15.9 // indirect
 	github.com/mattn/go-runewidth v0.0.9 // indirect
-	github.com/olekukonko/tablewriter v0.0.5 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
 )
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// With -ids-file the run fetches only the listed trips, with one
+// $where trip_id in (...) query per chunk of ids instead of paging through
+// the dataset. Each chunk stands in for one page: chunk k is fetched at
+// offset 100*k, so progress, logging and -page-deadline work unchanged.
+const (
+	idsPerChunk = 100 // at most a page of rows, as the offset mapping needs
+	idsMaxQuery = 4000
+)
+
+// readIDsFile returns the trip_ids listed one per line in path, without
+// duplicates, in file order. Blank lines are ignored.
+func readIDsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ids []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		id := strings.TrimSpace(scanner.Text())
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%s lists no trip_ids", path)
+	}
+	return ids, nil
+}
+
+// chunkIDs splits ids into chunks of at most perChunk ids whose condition,
+// URL-encoded, is at most maxQuery bytes, so each request stays well under
+// the URL length servers and proxies accept. A single id longer than that
+// still gets a chunk of its own.
+func chunkIDs(ids []string, perChunk, maxQuery int) [][]string {
+	var chunks [][]string
+	var chunk []string
+	size := 0
+	for _, id := range ids {
+		// The quoted, escaped id and its separator.
+		n := len(url.QueryEscape(soqlString(id) + ", "))
+		if len(chunk) > 0 && (len(chunk) >= perChunk || size+n > maxQuery) {
+			chunks = append(chunks, chunk)
+			chunk, size = nil, 0
+		}
+		chunk = append(chunk, id)
+		size += n
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// idsCondition selects the trips of one chunk.
+func idsCondition(chunk []string) string {
+	list := make([]string, len(chunk))
+	for i, id := range chunk {
+		list[i] = soqlString(id)
+	}
+	return sourceField("trip_id") + " in (" + strings.Join(list, ", ") + ")"
+}
+
+// idChunk returns the chunk fetched at offset, or nil past the last one.
+func idChunk(offset int) []string {
+	if k := offset / 100; k < len(cfg.IDChunks) {
+		return cfg.IDChunks[k]
+	}
+	return nil
+}
+
+// idCount returns the number of -ids-file trip_ids.
+func idCount() int {
+	n := 0
+	for _, chunk := range cfg.IDChunks {
+		n += len(chunk)
+	}
+	return n
+}
+
+// idsURL builds the query for the chunk at offset.
+func idsURL(offset int) string {
+	chunk := idChunk(offset)
+	return queryURL(url.Values{"$limit": {fmt.Sprint(len(chunk))}}, idsCondition(chunk))
+}
+
+// idsFound records which -ids-file trips the API returned.
+type idsFound map[string]bool
+
+func (f idsFound) add(trips []data_fetched) {
+	for _, t := range trips {
+		f[t.TripID] = true
+	}
+}
+
+// report logs how many of the listed ids were not returned and prints them,
+// one per line, to the text output. Ids filtered out by -companies, -where
+// and the other filters count as not found.
+func (f idsFound) report() {
+	var missing []string
+	for _, chunk := range cfg.IDChunks {
+		for _, id := range chunk {
+			if !f[id] {
+				missing = append(missing, id)
+			}
+		}
+	}
+	log.Printf("Found %d of %d -ids-file trip_ids\n", idCount()-len(missing), idCount())
+	if len(missing) == 0 {
+		return
+	}
+	fmt.Fprintf(textOut, "%d trip_ids not found:\n", len(missing))
+	for _, id := range missing {
+		fmt.Fprintln(textOut, id)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Each single-letter id costs 11 bytes of query: %27a%27%2C+.
+func TestChunkIDs(t *testing.T) {
+	long := strings.Repeat("x", 30)
+	tests := []struct {
+		name     string
+		ids      []string
+		perChunk int
+		maxQuery int
+		want     [][]string
+	}{
+		{"none", nil, 2, 1000, nil},
+		{"count limit", []string{"a", "b", "c", "d", "e"}, 2, 1000, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}},
+		{"size limit", []string{"a", "b", "c", "d"}, 100, 25, [][]string{{"a", "b"}, {"c", "d"}}},
+		{"size limit reached exactly", []string{"a", "b", "c"}, 100, 22, [][]string{{"a", "b"}, {"c"}}},
+		{"size limit one byte short", []string{"a", "b", "c"}, 100, 21, [][]string{{"a"}, {"b"}, {"c"}}},
+		{"id over the size limit", []string{"a", long, "b"}, 100, 25, [][]string{{"a"}, {long}, {"b"}}},
+		{"quotes are escaped", []string{"a'b", "c"}, 100, 25, [][]string{{"a'b"}, {"c"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chunkIDs(tt.ids, tt.perChunk, tt.maxQuery); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chunkIDs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
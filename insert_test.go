@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// sampleRecord is a trip as the API returns it, every source column set.
+const sampleRecord = `{"trip_id":"0d5f2a0e9c1b","taxi_id":"a1b2c3",` +
+	`"trip_start_timestamp":"2023-01-01T00:15:00.000","trip_end_timestamp":"2023-01-01T00:30:00.000",` +
+	`"trip_seconds":"900","trip_miles":"3.2","pickup_census_tract":"17031081500","dropoff_census_tract":"17031320100",` +
+	`"pickup_community_area":"8","dropoff_community_area":"32",` +
+	`"fare":"12.25","tips":"3.00","tolls":"0","extras":"1.50","trip_total":"16.75",` +
+	`"payment_type":"Credit Card","company":"Flash Cab",` +
+	`"pickup_centroid_latitude":"41.892508","pickup_centroid_longitude":"-87.626215",` +
+	`"pickup_centroid_location":{"type":"Point","coordinates":[-87.626215,41.892508]},` +
+	`"dropoff_centroid_latitude":"41.884987","dropoff_centroid_longitude":"-87.620992",` +
+	`"dropoff_centroid_location":{"type":"Point","coordinates":[-87.620992,41.884987]}}`
+
+// sampleArgs are the values sampleRecord is inserted with: the 23 source
+// columns, then pickup_geohash and row_hash.
+var sampleArgs = []driver.Value{
+	"0d5f2a0e9c1b", "a1b2c3",
+	time.Date(2023, 1, 1, 0, 15, 0, 0, time.UTC), time.Date(2023, 1, 1, 0, 30, 0, 0, time.UTC),
+	900, 3.2, "17031081500", "17031320100", 8, 32,
+	12.25, 3.0, 0.0, 1.5, 16.75,
+	"Credit Card", "Flash Cab",
+	41.892508, -87.626215, "POINT(-87.626215 41.892508)",
+	41.884987, -87.620992, "POINT(-87.620992 41.884987)",
+	"dp3wq42", "0152efaa7795812ecbb0952b814145fe769c47f15965d49f80bd653ca77f067e",
+}
+
+// wantInsertSQL is insertSQL spelled out.
+const wantInsertSQL = `INSERT INTO "taxi_trips" ("trip_id", "taxi_id", "trip_start_timestamp", "trip_end_timestamp", "trip_seconds", "trip_miles", "pickup_census_tract", "dropoff_census_tract", "pickup_community_area", "dropoff_community_area", "fare", "tips", "tolls", "extras", "trip_total", "payment_type", "company", "pickup_centroid_latitude", "pickup_centroid_longitude", "pickup_centroid_location", "dropoff_centroid_latitude", "dropoff_centroid_longitude", "dropoff_centroid_location", "pickup_geohash", "row_hash") ` +
+	`VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25) ` +
+	`ON CONFLICT ("trip_id") DO UPDATE SET "taxi_id" = EXCLUDED."taxi_id", "trip_start_timestamp" = EXCLUDED."trip_start_timestamp", "trip_end_timestamp" = EXCLUDED."trip_end_timestamp", "trip_seconds" = EXCLUDED."trip_seconds", "trip_miles" = EXCLUDED."trip_miles", "pickup_census_tract" = EXCLUDED."pickup_census_tract", "dropoff_census_tract" = EXCLUDED."dropoff_census_tract", "pickup_community_area" = EXCLUDED."pickup_community_area", "dropoff_community_area" = EXCLUDED."dropoff_community_area", "fare" = EXCLUDED."fare", "tips" = EXCLUDED."tips", "tolls" = EXCLUDED."tolls", "extras" = EXCLUDED."extras", "trip_total" = EXCLUDED."trip_total", "payment_type" = EXCLUDED."payment_type", "company" = EXCLUDED."company", "pickup_centroid_latitude" = EXCLUDED."pickup_centroid_latitude", "pickup_centroid_longitude" = EXCLUDED."pickup_centroid_longitude", "pickup_centroid_location" = EXCLUDED."pickup_centroid_location", "dropoff_centroid_latitude" = EXCLUDED."dropoff_centroid_latitude", "dropoff_centroid_longitude" = EXCLUDED."dropoff_centroid_longitude", "dropoff_centroid_location" = EXCLUDED."dropoff_centroid_location", "pickup_geohash" = EXCLUDED."pickup_geohash", "row_hash" = EXCLUDED."row_hash"`
+
+func sampleTrip(t testing.TB, tripID string) data_fetched {
+	t.Helper()
+	var trip data_fetched
+	if err := json.Unmarshal([]byte(sampleRecord), &trip); err != nil {
+		t.Fatal(err)
+	}
+	trip.Normalize(cfg.Normalize)
+	if tripID != "" {
+		trip.TripID = tripID
+	}
+	return trip
+}
+
+func newMock(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, mock
+}
+
+func driverValues(values []any) []driver.Value {
+	dv := make([]driver.Value, len(values))
+	for i, v := range values {
+		dv[i] = v
+	}
+	return dv
+}
+
+func TestInsertSQL(t *testing.T) {
+	if insertSQL != wantInsertSQL {
+		t.Errorf("insertSQL =\n%s\nwant\n%s", insertSQL, wantInsertSQL)
+	}
+	if got := tripValues(sampleTrip(t, "")); len(got) != len(sampleArgs) {
+		t.Errorf("tripValues returned %d values, want %d", len(got), len(sampleArgs))
+	}
+}
+
+func TestInsertTripsSingleRow(t *testing.T) {
+	db, mock := newMock(t)
+	mock.ExpectBegin()
+	mock.ExpectPrepare(anomalySQL)
+	mock.ExpectPrepare(wantInsertSQL).ExpectExec().WithArgs(sampleArgs...).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	b := batch{trips: []data_fetched{sampleTrip(t, "")}}
+	if err := insertTrips(context.Background(), db, b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// A page is one transaction, with the statement prepared once and run per
+// trip in page order.
+func TestInsertTripsPage(t *testing.T) {
+	db, mock := newMock(t)
+	trips := []data_fetched{sampleTrip(t, ""), sampleTrip(t, "1e6a3b1fad2c"), sampleTrip(t, "2f7b4c20be3d")}
+	mock.ExpectBegin()
+	mock.ExpectPrepare(anomalySQL)
+	stmt := mock.ExpectPrepare(wantInsertSQL)
+	stmt.ExpectExec().WithArgs(sampleArgs...).WillReturnResult(sqlmock.NewResult(0, 1))
+	for _, trip := range trips[1:] {
+		args := append([]driver.Value{trip.TripID}, sampleArgs[1:23]...)
+		args = append(args, "dp3wq42", rowHash(sourceValues(trip)))
+		stmt.ExpectExec().WithArgs(args...).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+
+	if err := insertTrips(context.Background(), db, batch{trips: trips}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInsertRows(t *testing.T) {
+	second := sampleTrip(t, "1e6a3b1fad2c")
+	rows := [][]any{tripValues(sampleTrip(t, "")), tripValues(second)}
+
+	t.Run("batch", func(t *testing.T) {
+		db, mock := newMock(t)
+		mock.ExpectBegin()
+		stmt := mock.ExpectPrepare(wantInsertSQL)
+		stmt.ExpectExec().WithArgs(sampleArgs...).WillReturnResult(sqlmock.NewResult(0, 1))
+		stmt.ExpectExec().WithArgs(driverValues(rows[1])...).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		if err := insertRows(context.Background(), db, insertSQL, rows); err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	// A failed row rolls the whole batch back.
+	t.Run("failed row", func(t *testing.T) {
+		db, mock := newMock(t)
+		mock.ExpectBegin()
+		stmt := mock.ExpectPrepare(wantInsertSQL)
+		stmt.ExpectExec().WithArgs(sampleArgs...).WillReturnResult(sqlmock.NewResult(0, 1))
+		stmt.ExpectExec().WithArgs(driverValues(rows[1])...).WillReturnError(errors.New("value too long"))
+		mock.ExpectRollback()
+		err := insertRows(context.Background(), db, insertSQL, rows)
+		if err == nil || err.Error() != "row 1: value too long" {
+			t.Errorf("insertRows = %v, want the error of row 1", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// BenchmarkDBWorkers inserts pages of the sample trip through a DBSink
+// with 1 and 4 workers, each statement taking a millisecond as in a busy
+// database, to show the gain from inserting pages concurrently.
+func BenchmarkDBWorkers(b *testing.B) {
+	const pageSize = 10
+	saved := cfg
+	b.Cleanup(func() { cfg = saved })
+	cfg.Diff = false
+	cfg.StoreRaw = false
+	cfg.WithProvenance = false
+	cfg.DBAttempts = 1
+	page := make([]data_fetched, pageSize)
+	for i := range page {
+		page[i] = sampleTrip(b, "")
+	}
+
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+			db.SetMaxOpenConns(workers)
+			mock.MatchExpectationsInOrder(false)
+			for i := 0; i < b.N; i++ {
+				mock.ExpectBegin()
+				mock.ExpectPrepare(anomalySQL)
+				stmt := mock.ExpectPrepare(wantInsertSQL)
+				for range page {
+					stmt.ExpectExec().WithArgs(sampleArgs...).WillDelayFor(time.Millisecond).WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				mock.ExpectCommit()
+			}
+
+			ctx := context.Background()
+			sink := newDBSink(ctx, db, workers, nil)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				sink.Write(ctx, batch{seq: i, trips: page})
+			}
+			sink.Close()
+			b.StopTimer()
+			if err := mock.ExpectationsWereMet(); err != nil {
+				b.Error(err)
+			}
+			b.ReportMetric(float64(b.N*pageSize)/b.Elapsed().Seconds(), "rows/s")
+		})
+	}
+}
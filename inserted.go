@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"sync"
+)
+
+// insertedIDs collects the trip_ids that -inserted-ids asks for: trips that
+// were new to taxi_trips rather than updated. It is nil when the flag is
+// unset, and its methods do nothing on nil.
+var insertedIDs *idLog
+
+// returningSQL makes an upsert report whether it inserted the row. xmax is
+// 0 for a freshly inserted row version and set for one written by the ON
+// CONFLICT update.
+const returningSQL = " RETURNING (xmax = 0) AS inserted"
+
+// idLog writes one trip_id per line to a file that is moved into place when
+// the run ends cleanly. DB workers add to it concurrently.
+type idLog struct {
+	mu    sync.Mutex
+	f     *partialFile
+	w     *bufio.Writer
+	count int
+}
+
+func openIDLog(path string) (*idLog, error) {
+	f, err := createPartial(path)
+	if err != nil {
+		return nil, err
+	}
+	return &idLog{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// add records the ids of a committed batch.
+func (l *idLog) add(ids []string) {
+	if l == nil || len(ids) == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, id := range ids {
+		l.w.WriteString(id)
+		l.w.WriteByte('\n')
+	}
+	l.count += len(ids)
+}
+
+// close moves the file into place if the run ended cleanly, and otherwise
+// discards it like a failed export, so a file in place lists every trip the
+// run inserted.
+func (l *idLog) close(clean bool) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !clean {
+		l.f.abort(errors.New("the run did not end cleanly"))
+	}
+	if err := l.f.commit(l.w.Flush()); err != nil {
+		log.Printf("-inserted-ids: %v\n", err)
+		return
+	}
+	if !clean {
+		return
+	}
+	log.Printf("Wrote %d newly inserted trip ids to %s\n", l.count, l.f.path)
+}
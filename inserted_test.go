@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestInsertedIDs upserts a page where the database reports some trips as
+// new and the rest as updated: only the new ones reach the -inserted-ids
+// file, and only when the run ends cleanly.
+func TestInsertedIDs(t *testing.T) {
+	for _, clean := range []bool{true, false} {
+		path := filepath.Join(t.TempDir(), "inserted.txt")
+		ids, err := openIDLog(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		trips := []data_fetched{sampleTrip(t, ""), sampleTrip(t, "1e6a3b1fad2c"), sampleTrip(t, "2f7b4c20be3d")}
+		isNew := []bool{true, false, true}
+		db, mock := newMock(t)
+		mock.ExpectBegin()
+		mock.ExpectPrepare(anomalySQL)
+		stmt := mock.ExpectPrepare(wantInsertSQL + returningSQL)
+		for i, trip := range trips {
+			args := append([]driver.Value{trip.TripID}, sampleArgs[1:24]...)
+			args = append(args, rowHash(sourceValues(trip)))
+			stmt.ExpectQuery().WithArgs(args...).WillReturnRows(sqlmock.NewRows([]string{"inserted"}).AddRow(isNew[i]))
+		}
+		mock.ExpectCommit()
+
+		if err := insertTrips(context.Background(), db, batch{trips: trips}, ids); err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		ids.close(clean)
+
+		data, err := os.ReadFile(path)
+		if !clean {
+			if !os.IsNotExist(err) {
+				t.Errorf("unclean run left %s (read: %v)", path, err)
+			}
+			if _, err := os.Stat(path + ".partial"); !os.IsNotExist(err) {
+				t.Errorf("unclean run left the .partial file (stat: %v)", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "0d5f2a0e9c1b\n2f7b4c20be3d\n"; string(data) != want {
+			t.Errorf("inserted ids file holds %q, want %q", data, want)
+		}
+	}
+}
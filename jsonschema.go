@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Schemas for the wrapper types, which the API sends as strings. Each also
+// allows null, since the API sends null or omits a field it has no value for.
+var typeSchemas = map[reflect.Type]map[string]any{
+	reflect.TypeOf(CustomTime{}): {
+		"type":        []string{"string", "null"},
+		"pattern":     `^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{3}$`,
+		"description": "timestamp without zone, layout " + ctLayout,
+	},
+	reflect.TypeOf(CustomInt{}): {
+		"type":    []string{"string", "null"},
+		"pattern": `^-?\d+$`,
+	},
+	reflect.TypeOf(CustomFloat64{}): {
+		"type":    []string{"string", "null"},
+		"pattern": `^-?\d+(\.\d+)?([eE][-+]?\d+)?$`,
+	},
+	reflect.TypeOf(Location{}): {
+		"type":     []string{"object", "null"},
+		"required": []string{"type", "coordinates"},
+		"properties": map[string]any{
+			"type": map[string]any{"type": "string", "const": "Point"},
+			"coordinates": map[string]any{
+				"type":     "array",
+				"items":    map[string]any{"type": "number"},
+				"minItems": 2,
+				"maxItems": 2,
+			},
+		},
+	},
+}
+
+// tripJSONSchema derives a JSON Schema for a trip record from the struct
+// tags of t, so it stays in sync with the decoder.
+func tripJSONSchema(t reflect.Type) (map[string]any, error) {
+	props := make(map[string]any, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		if s, ok := typeSchemas[f.Type]; ok {
+			props[name] = s
+			continue
+		}
+		switch f.Type.Kind() {
+		case reflect.String:
+			props[name] = map[string]any{"type": "string"}
+		case reflect.Bool:
+			props[name] = map[string]any{"type": "boolean"}
+		default:
+			return nil, fmt.Errorf("field %s: no schema for %s", f.Name, f.Type)
+		}
+	}
+	return map[string]any{
+		"$schema":    "https://json-schema.org/draft/2020-12/schema",
+		"title":      "Chicago taxi trip",
+		"type":       "object",
+		"properties": props,
+	}, nil
+}
+
+// writeSchema writes the trip JSON Schema to path for -schema-out.
+func writeSchema(path string) error {
+	schema, err := tripJSONSchema(reflect.TypeOf(data_fetched{}))
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,71 @@
+//go:build kafka
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaBuilt reports whether this binary can publish to -kafka-brokers.
+const kafkaBuilt = true
+
+// KafkaSink publishes each trip to -kafka-topic as a JSON message in the
+// -jsonl format, keyed by trip_id so every version of a trip lands on the
+// same partition and consumers see them in order.
+//
+// Messages are sent in batches of up to -kafka-batch-size, waiting at most
+// -kafka-batch-timeout for a batch to fill, and every broker in sync must
+// acknowledge them. Write returns once the batch is delivered or has failed;
+// with -kafka-errors warn a failed delivery is logged and the run goes on.
+type KafkaSink struct {
+	w        *kafka.Writer
+	warnOnly bool
+}
+
+func newKafkaSink() (Sink, error) {
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(cfg.KafkaBrokers...),
+		Topic:        cfg.KafkaTopic,
+		Balancer:     &kafka.Hash{},
+		BatchSize:    cfg.KafkaBatchSize,
+		BatchTimeout: cfg.KafkaBatchTimeout,
+		RequiredAcks: kafka.RequireAll,
+	}
+	return &KafkaSink{w: w, warnOnly: cfg.KafkaErrors == "warn"}, nil
+}
+
+func (s *KafkaSink) Name() string { return "kafka " + s.w.Topic }
+
+func (s *KafkaSink) Write(ctx context.Context, b batch) error {
+	msgs := make([]kafka.Message, 0, len(b.trips))
+	for _, trip := range b.trips {
+		value, err := json.Marshal(exportTrip(trip, b))
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, kafka.Message{Key: []byte(trip.TripID), Value: value})
+	}
+	err := s.w.WriteMessages(ctx, msgs...)
+	if err == nil {
+		return nil
+	}
+	failed := len(msgs)
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) {
+		failed = writeErrs.Count()
+	}
+	stats.count("kafka_failed", failed)
+	if s.warnOnly {
+		logger(ctx).Warn("Kafka delivery failed", "sink", s.Name(), "messages", failed, "err", err)
+		return nil
+	}
+	return err
+}
+
+func (s *KafkaSink) Close() error {
+	return s.w.Close()
+}
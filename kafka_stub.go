@@ -0,0 +1,13 @@
+//go:build !kafka
+
+package main
+
+import "errors"
+
+// kafkaBuilt reports whether this binary can publish to -kafka-brokers.
+// The Kafka client is only linked into builds with -tags kafka.
+const kafkaBuilt = false
+
+func newKafkaSink() (Sink, error) {
+	return nil, errors.New("built without Kafka support; rebuild with -tags kafka")
+}
@@ -0,0 +1,30 @@
+package main
+
+// taxiLimiter keeps at most max trips per TaxiID across a run, for sampling
+// evenly over the fleet. It holds one map entry per distinct taxi seen,
+// roughly the length of the 128-character taxi id plus map overhead, so a
+// few thousand taxis cost on the order of a megabyte.
+type taxiLimiter struct {
+	max     int
+	counts  map[string]int
+	dropped int
+}
+
+func newTaxiLimiter(max int) *taxiLimiter {
+	return &taxiLimiter{max: max, counts: make(map[string]int)}
+}
+
+// filter returns the trips whose taxi is still under the cap, reusing the
+// backing array of trips.
+func (l *taxiLimiter) filter(trips []data_fetched) []data_fetched {
+	kept := trips[:0]
+	for _, t := range trips {
+		if l.counts[t.TaxiID] >= l.max {
+			l.dropped++
+			continue
+		}
+		l.counts[t.TaxiID]++
+		kept = append(kept, t)
+	}
+	return kept
+}
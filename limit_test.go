@@ -0,0 +1,43 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// The cap holds across pages: a taxi that filled it on one page gets no
+// more trips on the next, and every trip held back is counted.
+func TestTaxiLimiterFilter(t *testing.T) {
+	l := newTaxiLimiter(2)
+	pages := []struct {
+		taxis []string
+		want  []string
+	}{
+		{[]string{"a", "b", "a", "a", "c"}, []string{"a1", "b2", "a3", "c5"}},
+		{[]string{"b", "a", "c", "b", "d", "c"}, []string{"b1", "c3", "d5"}},
+	}
+	dropped := 0
+	for p, page := range pages {
+		var trips []data_fetched
+		for i, taxi := range page.taxis {
+			trips = append(trips, data_fetched{TripID: taxi + string(rune('1'+i)), TaxiID: taxi})
+		}
+		kept := l.filter(trips)
+		var got []string
+		for _, trip := range kept {
+			got = append(got, trip.TripID)
+		}
+		if !slices.Equal(got, page.want) {
+			t.Errorf("page %d kept %v, want %v", p+1, got, page.want)
+		}
+		dropped += len(page.taxis) - len(page.want)
+		if l.dropped != dropped {
+			t.Errorf("after page %d dropped = %d, want %d", p+1, l.dropped, dropped)
+		}
+	}
+	for taxi, n := range l.counts {
+		if n > l.max {
+			t.Errorf("taxi %s kept %d trips, over the cap of %d", taxi, n, l.max)
+		}
+	}
+}
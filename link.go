@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// nextLink returns the rel="next" target of a response's Link headers,
+// resolved against the request URL, or "" when there is none. A target on
+// another host or scheme is ignored, like a redirect there, since following
+// it would send the app token along; the next page is then computed.
+func nextLink(resp *http.Response) string {
+	for _, header := range resp.Header.Values("Link") {
+		for _, link := range strings.Split(header, ",") {
+			target, params, ok := strings.Cut(link, ";")
+			target = strings.TrimSpace(target)
+			if !ok || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, p := range strings.Split(params, ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+				if !strings.EqualFold(name, "rel") || !hasRel(strings.Trim(value, `"`), "next") {
+					continue
+				}
+				u, err := url.Parse(target[1 : len(target)-1])
+				if err != nil {
+					return ""
+				}
+				from := resp.Request.URL
+				next := from.ResolveReference(u)
+				if next.Host != from.Host || next.Scheme != from.Scheme {
+					logger(resp.Request.Context()).Warn("Ignoring Link to another host or scheme", "from", from.Scheme+"://"+from.Host, "to", next.Scheme+"://"+next.Host)
+					return ""
+				}
+				return next.String()
+			}
+		}
+	}
+	return ""
+}
+
+// hasRel reports whether the space-separated rel list contains rel.
+func hasRel(list, rel string) bool {
+	for _, r := range strings.Fields(list) {
+		if strings.EqualFold(r, rel) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNextLink(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"none", "", ""},
+		{"relative", `</resource.json?$offset=100>; rel="next"`, "https://data.example.com/resource.json?$offset=100"},
+		{"absolute", `<https://data.example.com/p2>; rel="next"`, "https://data.example.com/p2"},
+		{"among others", `<https://data.example.com/p0>; rel="prev", <https://data.example.com/p2>; rel="next last"`, "https://data.example.com/p2"},
+		{"other host", `<https://attacker.example.net/p2>; rel="next"`, ""},
+		{"other scheme", `<http://data.example.com/p2>; rel="next"`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "https://data.example.com/resource.json", nil)
+			resp := &http.Response{Header: http.Header{}, Request: req}
+			if tt.header != "" {
+				resp.Header.Set("Link", tt.header)
+			}
+			if got := nextLink(resp); got != tt.want {
+				t.Errorf("nextLink = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// The fetch loop follows Link headers page by page, but not to another
+// host, which would be sent the app token.
+func TestFetchFollowsLinkWithinHost(t *testing.T) {
+	var evilRequests int
+	var mu sync.Mutex
+	evil := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		evilRequests++
+		mu.Unlock()
+		fmt.Fprint(w, `[]`)
+	}))
+	defer evil.Close()
+
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.Path+" "+r.URL.Query().Get("$offset"))
+		mu.Unlock()
+		if got := r.Header.Get("X-App-Token"); got != "app-token" {
+			t.Errorf("request for %s has app token %q", r.URL, got)
+		}
+		switch {
+		case r.URL.Path == "/page2":
+			w.Header().Set("Link", "<"+evil.URL+`/page3>; rel="next"`)
+			fmt.Fprint(w, `[{"trip_id":"c"}]`)
+		case r.URL.Query().Get("$offset") == "0":
+			w.Header().Set("Link", `</page2>; rel="next"`)
+			fmt.Fprint(w, `[{"trip_id":"a"},{"trip_id":"b"}]`)
+		default:
+			fmt.Fprint(w, `[]`)
+		}
+	}))
+	defer srv.Close()
+
+	withConfig(t, func(c *config) {
+		c.DatasetURL = srv.URL + "/resource.json"
+		c.AppToken = "app-token"
+		c.DB = false
+		c.SummaryInterval = 0 // no count query
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rows, err := fetchAndPrinttaxitrips(ctx, ctx, nil, nil, &progress{start: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 3 {
+		t.Errorf("fetched %d rows, want 3", rows)
+	}
+	want := []string{"/resource.json 0", "/page2 ", "/resource.json 200"}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests = %q, want %q", requests, want)
+	}
+	if evilRequests != 0 {
+		t.Errorf("the other host got %d requests", evilRequests)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// newRunID returns a random id that tags every log line of one run.
+func newRunID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// setupLogging sends all logging, including the log package's, through slog
+// with run_id on every line, so one run's lines can be found in a shared log.
+// Warnings and errors are colored when stderr is, per -color.
+func setupLogging(runID string) {
+	var w io.Writer = os.Stderr
+	if colorEnabled(os.Stderr) {
+		w = colorLogWriter{w: os.Stderr}
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(w, nil)).With("run_id", runID))
+}
+
+type logAttrsKey struct{}
+
+// withLogAttrs returns a context whose logger adds args, as slog key-value
+// pairs, to the ones already carried by ctx.
+func withLogAttrs(ctx context.Context, args ...any) context.Context {
+	prev, _ := ctx.Value(logAttrsKey{}).([]any)
+	return context.WithValue(ctx, logAttrsKey{}, append(append([]any{}, prev...), args...))
+}
+
+// logger returns the default logger with the attributes carried by ctx.
+func logger(ctx context.Context) *slog.Logger {
+	args, _ := ctx.Value(logAttrsKey{}).([]any)
+	return slog.Default().With(args...)
+}
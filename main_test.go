@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"testing"
+)
+
+// TestMain starts every test from the flag defaults, the settings of a run
+// without flags, and keeps the trip table out of the test output.
+func TestMain(m *testing.M) {
+	args := os.Args
+	os.Args = args[:1]
+	parseFlags()
+	os.Args = args
+	flag.CommandLine.Parse(args[1:])
+	textOut = io.Discard
+	os.Exit(m.Run())
+}
+
+// withConfig changes cfg for the rest of the test and restores it after.
+func withConfig(t *testing.T, set func(c *config)) {
+	t.Helper()
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	set(&cfg)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log"
+	"runtime"
+	"time"
+)
+
+// memGuard holds back fetches while the heap is over -max-memory, so pages
+// do not pile up in memory faster than the inserts take them off the queue.
+// It is used by the fetch loop only. A nil guard never waits.
+type memGuard struct {
+	limit    uint64
+	interval time.Duration
+	// heap reads the heap size. Tests can replace it to force a pause.
+	heap    func() uint64
+	checked time.Time // of the last reading
+	warned  bool      // that the heap is over the limit with nothing queued
+}
+
+func newMemGuard(limit int64, interval time.Duration) *memGuard {
+	if limit <= 0 {
+		return nil
+	}
+	return &memGuard{limit: uint64(limit), interval: interval, heap: heapAlloc}
+}
+
+// heapAlloc returns the bytes of allocated heap objects. ReadMemStats stops
+// the world briefly, which is why the guard reads it once per interval
+// rather than once per page.
+func heapAlloc() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// wait returns once the heap is under the limit, waiting as long as it is
+// over and queued reports batches still waiting to be inserted. With nothing
+// queued there is nothing to wait for, since the heap is then held by
+// something other than pending pages, so fetching goes on with a warning,
+// given once until the heap is back under the limit.
+func (g *memGuard) wait(ctx context.Context, queued func() int) {
+	if g == nil || time.Since(g.checked) < g.interval {
+		return
+	}
+	defer func() { g.checked = time.Now() }()
+	heap := g.heap()
+	stats.gauge("heap_bytes", int(heap))
+	if heap <= g.limit {
+		g.warned = false
+		return
+	}
+
+	var paused time.Time
+	for {
+		// Inserted pages are garbage until the next collection, which
+		// would be long in coming with fetching stopped.
+		runtime.GC()
+		if heap = g.heap(); heap <= g.limit {
+			g.warned = false
+			break
+		}
+		if queued() == 0 {
+			if g.warned {
+				return
+			}
+			g.warned = true
+			log.Printf("WARNING: heap at %d MiB is over -max-memory %d MiB with no batches queued; fetching anyway\n", heap>>20, g.limit>>20)
+			return
+		}
+		if paused.IsZero() {
+			paused = time.Now()
+			log.Printf("Heap at %d MiB is over -max-memory %d MiB; pausing fetches until the insert queue drains\n", heap>>20, g.limit>>20)
+			stats.count("memory_pauses", 1)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(g.interval):
+		}
+	}
+	if !paused.IsZero() {
+		log.Printf("Heap down to %d MiB; resuming fetches after %s\n", heap>>20, time.Since(paused).Round(time.Second))
+		stats.timing("memory_pause", time.Since(paused))
+	}
+}
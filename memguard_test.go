@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemGuardPausesUntilHeapDrops(t *testing.T) {
+	var heap atomic.Uint64
+	heap.Store(200 << 20)
+	g := newMemGuard(100<<20, time.Millisecond)
+	g.heap = heap.Load
+
+	done := make(chan struct{})
+	go func() {
+		g.wait(context.Background(), func() int { return 1 })
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("wait returned with the heap over the limit and batches queued")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	heap.Store(50 << 20)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("wait did not resume once the heap dropped under the limit")
+	}
+}
+
+func TestMemGuardNothingQueued(t *testing.T) {
+	g := newMemGuard(100<<20, time.Millisecond)
+	g.heap = func() uint64 { return 200 << 20 }
+
+	done := make(chan struct{})
+	go func() {
+		g.wait(context.Background(), func() int { return 0 })
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("wait paused with no batches queued to drain")
+	}
+	if !g.warned {
+		t.Error("no warning that the heap is over the limit")
+	}
+}
+
+func TestMemGuardStopsWithContext(t *testing.T) {
+	g := newMemGuard(100<<20, time.Millisecond)
+	g.heap = func() uint64 { return 200 << 20 }
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		g.wait(ctx, func() int { return 1 })
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("wait kept pausing after the context was canceled")
+	}
+}
+
+func TestMemGuardDisabled(t *testing.T) {
+	if g := newMemGuard(0, time.Second); g != nil {
+		t.Fatalf("newMemGuard(0) = %v, want nil", g)
+	}
+	var g *memGuard
+	g.wait(context.Background(), func() int { return 1 })
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// NormalizeOptions selects the transformations Normalize applies to a trip.
+type NormalizeOptions struct {
+	// Company collapses whitespace in the company name and fixes casing
+	// when the name is entirely upper or lower case.
+	Company bool
+	// Payment maps the payment type onto paymentTypes.
+	Payment bool
+	// MoneyScale multiplies the fare, tips, tolls, extras and total.
+	// Zero or one leaves them unchanged.
+	MoneyScale float64
+	// Location reinterprets the wall-clock timestamps in this zone.
+	// Timestamps sent with an offset keep it. Nil leaves them unchanged.
+	Location *time.Location
+}
+
+// Normalize applies the selected transformations in a fixed order:
+// company name, payment type, money scaling, then timezone.
+func (t *data_fetched) Normalize(opts NormalizeOptions) {
+	if opts.Company {
+		t.Company = normalizeCompany(t.Company)
+	}
+	if opts.Payment {
+		t.PaymentType = normalizePayment(t.PaymentType)
+	}
+	if opts.MoneyScale != 0 && opts.MoneyScale != 1 {
+		for _, f := range []*CustomFloat64{&t.Fare, &t.Tips, &t.Tolls, &t.Extras, &t.TripTotal} {
+			f.Float64 *= opts.MoneyScale
+		}
+	}
+	if opts.Location != nil {
+		for _, ct := range []*CustomTime{&t.TripStartTimestamp, &t.TripEndTimestamp} {
+			if !ct.zoned {
+				ct.Time = inLocation(ct.Time, opts.Location)
+			}
+		}
+	}
+}
+
+func normalizeCompany(name string) string {
+	name = strings.Join(strings.Fields(name), " ")
+	if name != strings.ToUpper(name) && name != strings.ToLower(name) {
+		return name
+	}
+	words := strings.Fields(strings.ToLower(name))
+	for i, w := range words {
+		r := []rune(w)
+		r[0] = unicode.ToUpper(r[0])
+		words[i] = string(r)
+	}
+	return strings.Join(words, " ")
+}
+
+// paymentTypes maps payment type variants, lower-cased with whitespace
+// collapsed, to their canonical names.
+var paymentTypes = map[string]string{
+	"cash":        "Cash",
+	"credit card": "Credit Card",
+	"creditcard":  "Credit Card",
+	"mobile":      "Mobile",
+	"prcard":      "Prcard",
+	"pr card":     "Prcard",
+	"no charge":   "No Charge",
+	"nocharge":    "No Charge",
+	"dispute":     "Dispute",
+	"unknown":     "Unknown",
+}
+
+// reportedPayments remembers unrecognised payment types already logged.
+var reportedPayments = map[string]bool{}
+
+// normalizePayment returns the canonical name of a payment type, or
+// "Unknown" for one not in paymentTypes. An absent payment type stays empty.
+func normalizePayment(payment string) string {
+	key := strings.ToLower(strings.Join(strings.Fields(payment), " "))
+	if key == "" {
+		return ""
+	}
+	if canonical, ok := paymentTypes[key]; ok {
+		return canonical
+	}
+	if !reportedPayments[payment] {
+		reportedPayments[payment] = true
+		log.Printf("Unrecognised payment type %q, stored as Unknown\n", payment)
+	}
+	return "Unknown"
+}
+
+// inLocation keeps the wall clock of t but places it in loc. The API sends
+// timestamps without an offset, so they are parsed as UTC.
+func inLocation(t time.Time, loc *time.Location) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
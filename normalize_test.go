@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+const normalizeRecord = `{"trip_id":"t1","company":"  FLASH   CAB ","payment_type":"creditcard",` +
+	`"fare":"12.5","tips":"2","tolls":"0.25","extras":"1","trip_total":"15.75",` +
+	`"trip_start_timestamp":"2023-03-12T01:30:00.000","trip_end_timestamp":"2023-03-12T03:15:00.000-05:00"}`
+
+func decodeNormalizeRecord(t *testing.T) data_fetched {
+	t.Helper()
+	var trip data_fetched
+	if err := json.Unmarshal([]byte(normalizeRecord), &trip); err != nil {
+		t.Fatal(err)
+	}
+	return trip
+}
+
+func TestNormalizeAll(t *testing.T) {
+	cst := time.FixedZone("CST", -6*60*60)
+	trip := decodeNormalizeRecord(t)
+	trip.Normalize(NormalizeOptions{Company: true, Payment: true, MoneyScale: 100, Location: cst})
+
+	if trip.Company != "Flash Cab" {
+		t.Errorf("company = %q, want %q", trip.Company, "Flash Cab")
+	}
+	if trip.PaymentType != "Credit Card" {
+		t.Errorf("payment_type = %q, want %q", trip.PaymentType, "Credit Card")
+	}
+	for _, f := range []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"fare", trip.Fare.Float64, 1250},
+		{"tips", trip.Tips.Float64, 200},
+		{"tolls", trip.Tolls.Float64, 25},
+		{"extras", trip.Extras.Float64, 100},
+		{"trip_total", trip.TripTotal.Float64, 1575},
+	} {
+		if f.got != f.want {
+			t.Errorf("%s = %g, want %g", f.name, f.got, f.want)
+		}
+	}
+	// The wall clock is kept in the new zone; a timestamp sent with an
+	// offset keeps it.
+	if want := time.Date(2023, 3, 12, 1, 30, 0, 0, cst); !trip.TripStartTimestamp.Time.Equal(want) {
+		t.Errorf("trip_start_timestamp = %s, want %s", trip.TripStartTimestamp.Time, want)
+	}
+	if want := time.Date(2023, 3, 12, 3, 15, 0, 0, time.FixedZone("", -5*60*60)); !trip.TripEndTimestamp.Time.Equal(want) {
+		t.Errorf("trip_end_timestamp = %s, want %s", trip.TripEndTimestamp.Time, want)
+	}
+	// The normalized trip still passes validation.
+	if reasons := validateTrip(trip); len(reasons) > 0 {
+		t.Errorf("normalized trip flagged: %v", reasons)
+	}
+}
+
+// Each option changes its own fields and no others.
+func TestNormalizeEachOption(t *testing.T) {
+	original := decodeNormalizeRecord(t)
+	tests := []struct {
+		name  string
+		opts  NormalizeOptions
+		check func(got data_fetched) bool
+	}{
+		{"none", NormalizeOptions{}, func(got data_fetched) bool { return true }},
+		{"company", NormalizeOptions{Company: true}, func(got data_fetched) bool { return got.Company == "Flash Cab" }},
+		{"payment", NormalizeOptions{Payment: true}, func(got data_fetched) bool { return got.PaymentType == "Credit Card" }},
+		{"money scale 1", NormalizeOptions{MoneyScale: 1}, func(got data_fetched) bool { return true }},
+		{"money scale", NormalizeOptions{MoneyScale: 0.5}, func(got data_fetched) bool { return got.Fare.Float64 == 6.25 }},
+		{"location", NormalizeOptions{Location: time.FixedZone("CST", -6*60*60)},
+			func(got data_fetched) bool {
+				return got.TripStartTimestamp.Time.Sub(original.TripStartTimestamp.Time) == 6*time.Hour
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decodeNormalizeRecord(t)
+			got.Normalize(tt.opts)
+			if !tt.check(got) {
+				t.Errorf("Normalize(%+v) did not apply", tt.opts)
+			}
+			if !tt.opts.Company && got.Company != original.Company {
+				t.Errorf("company changed to %q", got.Company)
+			}
+			if !tt.opts.Payment && got.PaymentType != original.PaymentType {
+				t.Errorf("payment_type changed to %q", got.PaymentType)
+			}
+			if (tt.opts.MoneyScale == 0 || tt.opts.MoneyScale == 1) && got.Fare != original.Fare {
+				t.Errorf("fare changed to %g", got.Fare.Float64)
+			}
+			if tt.opts.Location == nil && !got.TripStartTimestamp.Time.Equal(original.TripStartTimestamp.Time) {
+				t.Errorf("trip_start_timestamp changed to %s", got.TripStartTimestamp.Time)
+			}
+		})
+	}
+}
+
+func TestNormalizePayment(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Cash", "Cash"},
+		{"CASH", "Cash"},
+		{"Credit Card", "Credit Card"},
+		{"credit  card", "Credit Card"},
+		{"CreditCard", "Credit Card"},
+		{"Mobile", "Mobile"},
+		{"Prcard", "Prcard"},
+		{"PR Card", "Prcard"},
+		{"No Charge", "No Charge"},
+		{"NoCharge", "No Charge"},
+		{"Dispute", "Dispute"},
+		{"Unknown", "Unknown"},
+		{"", ""},
+		{"credit", "Unknown"},
+		{"Way2ride", "Unknown"},
+	}
+	for _, tt := range tests {
+		if got := normalizePayment(tt.in); got != tt.want {
+			t.Errorf("normalizePayment(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
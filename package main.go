@@ -2,18 +2,32 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	_ "github.com/lib/pq"
-	"github.com/olekukonko/tablewriter"
+	"github.com/lib/pq"
 )
 
 type data_fetched struct {
@@ -40,179 +54,2259 @@ type data_fetched struct {
 	DropoffCentroidLatitude  CustomFloat64 `json:"dropoff_centroid_latitude"`
 	DropoffCentroidLongitude CustomFloat64 `json:"dropoff_centroid_longitude"`
 	DropoffCentroidLocation  Location      `json:"dropoff_centroid_location"`
+
+	raw json.RawMessage // the source record, kept for -store-raw
 }
 
+// CustomTime, CustomInt and CustomFloat64 set Valid when the field was
+// present and non-null in the source JSON.
 type CustomTime struct {
 	time.Time
+	Valid bool
+
+	zoned bool // the source gave an explicit offset, kept by -tz
 }
 
+// Location is a GeoJSON point. The zero value, with an empty Type, is a
+// location that was absent or null in the source.
 type Location struct {
 	Type        string     `json:"type"`
 	Coordinates [2]float64 `json:"coordinates"`
 }
 
+// UnmarshalJSON accepts a GeoJSON Point or null. Any other geometry is an
+// error, so the trip is skipped rather than stored with a point made up of
+// the wrong coordinates.
+func (l *Location) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	type location Location // without UnmarshalJSON
+	var v location
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if v.Type != "Point" {
+		return fmt.Errorf("location type %q is not Point", v.Type)
+	}
+	*l = Location(v)
+	return nil
+}
+
 const ctLayout = "2006-01-02T15:04:05.000"
 
+// ctZonedLayout is ctLayout with the offset, as RFC 3339. Times that carry
+// a zone, from the source or from -tz-output, are written with it.
+const ctZonedLayout = ctLayout + "Z07:00"
+
 // UnmarshalJSON parses the time string into a CustomTime struct
 func (ct *CustomTime) UnmarshalJSON(b []byte) error {
-	str := string(b[1 : len(b)-1])
+	if string(b) == "null" {
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return err
+	}
 	t, err := time.Parse(ctLayout, str)
 	if err != nil {
-		return err
+		// Some dataset variants append an offset, as in RFC 3339.
+		var rerr error
+		if t, rerr = time.Parse(time.RFC3339Nano, str); rerr != nil {
+			return err
+		}
+		ct.zoned = true
 	}
 	ct.Time = t
+	ct.Valid = true
 	return nil
 }
 
+// MarshalJSON writes the time in the API's format, or null when absent.
+func (ct CustomTime) MarshalJSON() ([]byte, error) {
+	if !ct.Valid {
+		return []byte("null"), nil
+	}
+	return json.Marshal(ct.format())
+}
+
+// format renders the time in the API's format, with its offset when it has
+// a zone.
+func (ct CustomTime) format() string {
+	if ct.zoned {
+		return ct.Time.Format(ctZonedLayout)
+	}
+	return ct.Time.Format(ctLayout)
+}
+
+// MarshalJSON writes the point as GeoJSON, or null when absent.
+func (l Location) MarshalJSON() ([]byte, error) {
+	if l.Type == "" {
+		return []byte("null"), nil
+	}
+	type location Location // without MarshalJSON
+	return json.Marshal(location(l))
+}
+
+// wkt renders the point as well-known text, or nil when it is missing.
+func (l Location) wkt() any {
+	if l.Type == "" {
+		return nil
+	}
+	return fmt.Sprintf("POINT(%v %v)", l.Coordinates[0], l.Coordinates[1])
+}
+
 type CustomInt struct {
-	Int int
+	Int   int
+	Valid bool
 }
 
-// UnmarshalJSON parses the int string into a CustomInt struct
+// UnmarshalJSON parses a quoted or bare int into a CustomInt struct. Some
+// datasets send whole numbers as floats, so "123.0" is truncated to 123
+// unless -strict-types is set.
 func (ci *CustomInt) UnmarshalJSON(b []byte) error {
-	str := string(b[1 : len(b)-1])
+	if string(b) == "null" {
+		return nil
+	}
+	str, err := numberText(b)
+	if err != nil {
+		return err
+	}
 	i, err := strconv.Atoi(str)
+	if err != nil && !cfg.StrictTypes {
+		if f, ferr := strconv.ParseFloat(str, 64); ferr == nil && f >= math.MinInt && f < math.MaxInt {
+			i, err = int(f), nil
+		}
+	}
 	if err != nil {
 		return err
 	}
 	ci.Int = i
+	ci.Valid = true
 	return nil
 }
 
+// numberText returns the text of a number sent either as a JSON string, as
+// the API does, or as a bare JSON number.
+func numberText(b []byte) (string, error) {
+	if len(b) > 0 && b[0] == '"' {
+		var str string
+		err := json.Unmarshal(b, &str)
+		return str, err
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return "", err
+	}
+	return n.String(), nil
+}
+
+// MarshalJSON writes the int as a string like the API does, or null when
+// absent.
+func (ci CustomInt) MarshalJSON() ([]byte, error) {
+	if !ci.Valid {
+		return []byte("null"), nil
+	}
+	return json.Marshal(strconv.Itoa(ci.Int))
+}
+
 // CustomFloat64 is a wrapper to handle JSON numbers that might be strings
 type CustomFloat64 struct {
 	Float64 float64
+	Valid   bool
 }
 
-// UnmarshalJSON parses the float string into a CustomFloat64 struct
+// UnmarshalJSON parses a quoted or bare float into a CustomFloat64 struct.
+// Integers such as "12" are accepted as they are valid floats.
 func (cf *CustomFloat64) UnmarshalJSON(b []byte) error {
-	str := string(b[1 : len(b)-1])
+	if string(b) == "null" {
+		return nil
+	}
+	str, err := numberText(b)
+	if err != nil {
+		return err
+	}
 	f, err := strconv.ParseFloat(str, 64)
 	if err != nil {
 		return err
 	}
 	cf.Float64 = f
+	cf.Valid = true
 	return nil
 }
 
-func main() {
-	var (
-		Hostname = "localhost"
-		Port     = 5432
-		Username = "mdidris"
-		Password = "postgres"
-		Database = "extraction"
-	)
-	conn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		Hostname, Port, Username, Password, Database)
-	db, err := sql.Open("postgres", conn)
-	if err != nil {
-		log.Fatal(err)
+// MarshalJSON writes the float as a string like the API does, or null when
+// absent.
+func (cf CustomFloat64) MarshalJSON() ([]byte, error) {
+	if !cf.Valid {
+		return []byte("null"), nil
 	}
-	defer db.Close()
+	return json.Marshal(strconv.FormatFloat(cf.Float64, 'f', -1, 64))
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-	defer cancel()
+// config holds the options set on the command line.
+type config struct {
+	Strict               bool
+	StrictTypes          bool
+	SummaryInterval      time.Duration
+	CountTimeout         time.Duration
+	Normalize            NormalizeOptions
+	MaxConsecutiveErrors int
+	RetryBase            time.Duration
+	RetryMax             time.Duration
+	RetryJitter          bool
+	CountAttempts        int
+	DBAttempts           int
+	BreakerFailures      int
+	BreakerWindow        time.Duration
+	BreakerCooldown      time.Duration
+	Where                string
+	PickupAreas          []int
+	Companies            []string
+	MinFare, MaxFare     *float64 // nil when not set
+	CountOnly            bool
+	Distinct             string
+	CompareSchema        bool
+	MaxPrintRows         int
+	FloatPrecision       map[string]int
 
-	// Set up timer
-	timer := time.NewTimer(10 * time.Minute)
+	DBHost     string
+	DBPort     int
+	DBUser     string
+	DBPassword string
+	DBName     string
+	DSN        string
+	// ReplicaDSNs are the -dsn values after the first. Every batch is
+	// also written to them.
+	ReplicaDSNs   []string
+	ReplicaErrors string
 
-	go func() {
-		<-timer.C
-		log.Println("Timer expired. Exiting program.")
-		cancel()
-	}()
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+
+	GeohashPrecision int
+	Diff             bool
+	Verbose          bool
+	DatasetURL       string
+	Loader           recordLoader
+	DBWorkers        int
+	QueueSize        int
+	DBLoadThreshold  int
+	DBLoadInterval   time.Duration
+	Shards           int
+	PartitionRange   *monthRange // nil leaves taxi_trips unpartitioned
+	PrintSchema      bool
+	Preflight        bool
+	Analyze          bool
+	Vacuum           bool
+	Keyset           bool
+	ResumeFile       string
+	WithComments     bool
+	StatsdAddr       string
+	StatsdRate       float64
+	CPUProfile       string
+	MemProfile       string
+	MaxResponseBytes int64
+	MaxMemory        int64
+	MemoryInterval   time.Duration
+	ConfirmThreshold int
+	Yes              bool
+	CSVPath          string
+	CSVNull          string
+	KeepPartial      bool
+	WithProvenance   bool
+	StoreRaw         bool
+	Reparse          bool
+	Explain          bool
+	FailOnEmpty      bool
+	StatsDB          bool
+	RunLog           string
+	ValidateOnly     bool
+	ValidateMax      map[string]int
+	FieldMap         map[string]string // trip field to dataset column
+	IDChunks         [][]string        // -ids-file trip_ids, one query each
+	InsertedIDsPath  string
+	ErrorDumpPath    string
+	ErrorDumpMax     int
+	StartFromDate    time.Time
+	SinceLastRun     bool
+	DropTable        bool
+	Since            time.Time // lower bound found by -since-last-run
+	OutputLocation   *time.Location
+	Report           string
+	ReportSource     string
+	MaxPerTaxi       int
+	ReplayPath       string
+	ImportCSV        string
+	Complete         *completeFilter
+	AdminAddr        string
+	DrainTimeout     time.Duration
+	MaxIdleTime      time.Duration
+	MaxOffset        int
+	PageDeadline     time.Duration
+	FormatIn         string
+	CheckpointEvery  int
+	ServerSample     int
+	OtelEndpoint     string
+	SchemaOut        string
+	AdminToken       string
+	AppToken         string
+	TokenAsParam     bool
+	PrintURL         bool
+	SplitWeekend     bool
+	DB               bool
+	JSONLPath        string
+	StdoutNDJSON     bool
+	Color            string
+	GeoJSONPath      string
 
-	createTable(ctx, db)
-	fetchAndPrinttaxitrips(ctx, db)
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	HTTP2               bool
+
+	KafkaBrokers      []string
+	KafkaTopic        string
+	KafkaBatchSize    int
+	KafkaBatchTimeout time.Duration
+	KafkaErrors       string
+
+	ContinueOnSinkError bool
 }
 
-func createTable(ctx context.Context, db *sql.DB) {
-	_, err := db.ExecContext(ctx, `
-        CREATE TABLE IF NOT EXISTS taxi_trips (
-            trip_id TEXT PRIMARY KEY,
-            taxi_id TEXT,
-            trip_start_timestamp TIMESTAMP,
-            trip_end_timestamp TIMESTAMP,
-            trip_seconds INTEGER,
-            trip_miles FLOAT,
-            pickup_census_tract TEXT,
-            dropoff_census_tract TEXT,
-            pickup_community_area INTEGER,
-            dropoff_community_area INTEGER,
-            fare FLOAT,
-            tips FLOAT,
-            tolls FLOAT,
-            extras FLOAT,
-            trip_total FLOAT,
-            payment_type TEXT,
-            company TEXT,
-            pickup_centroid_latitude FLOAT,
-            pickup_centroid_longitude FLOAT,
-            pickup_centroid_location FLOAT,
-            dropoff_centroid_latitude FLOAT,
-            dropoff_centroid_longitude FLOAT,
-            dropoff_centroid_location FLOAT
-        );
-    `)
+// envFlags maps flags to the environment variables that supply their value
+// when the flag is not given on the command line.
+var envFlags = map[string]string{
+	"db-host":     "PGHOST",
+	"db-port":     "PGPORT",
+	"db-user":     "PGUSER",
+	"db-password": "PGPASSWORD",
+	"db-name":     "PGDATABASE",
+	"dsn":         "DATABASE_URL",
+	"sslmode":     "PGSSLMODE",
+	"sslrootcert": "PGSSLROOTCERT",
+	"sslcert":     "PGSSLCERT",
+	"sslkey":      "PGSSLKEY",
+	"admin-token": "ADMIN_TOKEN",
+	"app-token":   "SOCRATA_APP_TOKEN",
+}
+
+var cfg config
+
+func parseFlags() {
+	flag.BoolVar(&cfg.Strict, "strict", false, "abort on unmodeled API fields and keep invalid trips out of taxi_trips")
+	flag.BoolVar(&cfg.StrictTypes, "strict-types", false, "reject fractional values in integer fields instead of truncating them")
+	flag.DurationVar(&cfg.SummaryInterval, "summary-interval", 30*time.Second, "how often to log a progress summary (0 disables)")
+	flag.DurationVar(&cfg.CountTimeout, "count-timeout", 15*time.Second, "how long to wait for the total row count, retries included (0 waits indefinitely)")
+	flag.BoolVar(&cfg.Normalize.Company, "normalize-company", false, "collapse whitespace and fix casing in company names")
+	flag.BoolVar(&cfg.Normalize.Payment, "normalize-payment", false, "map payment type variants to Cash, Credit Card, Mobile, Prcard, No Charge, Dispute or Unknown")
+	flag.Float64Var(&cfg.Normalize.MoneyScale, "money-scale", 1, "multiply money fields by this factor (e.g. 100 for cents)")
+	flag.IntVar(&cfg.MaxConsecutiveErrors, "max-consecutive-errors", 10, "exit after this many page fetches fail in a row")
+	flag.DurationVar(&cfg.RetryBase, "retry-base", 5*time.Second, "delay before the first retry of a fetch, count query or insert, doubling after each failure")
+	flag.DurationVar(&cfg.RetryMax, "retry-max", 2*time.Minute, "cap on the doubling retry delay")
+	flag.BoolVar(&cfg.RetryJitter, "retry-jitter", true, "wait a random part of each retry delay, so clients that failed together do not retry together")
+	flag.IntVar(&cfg.CountAttempts, "count-attempts", 3, "how often to try the total count query on network and server errors (1 disables retries)")
+	flag.IntVar(&cfg.DBAttempts, "db-attempts", 3, "how often to try inserting a batch on lost connections, deadlocks and serialization failures (1 disables retries)")
+	flag.IntVar(&cfg.BreakerFailures, "breaker-failures", 0, "pause fetching for -breaker-cooldown after this many failures within -breaker-window (0 disables)")
+	flag.DurationVar(&cfg.BreakerWindow, "breaker-window", time.Minute, "window in which -breaker-failures are counted")
+	flag.DurationVar(&cfg.BreakerCooldown, "breaker-cooldown", time.Minute, "how long fetching pauses once the circuit breaker opens")
+	flag.StringVar(&cfg.Where, "where", "", "SoQL $where filter applied to every query")
+	flag.StringVar(&cfg.Distinct, "distinct", "", "print the distinct values of this field (e.g. company) with their row counts and exit")
+	flag.BoolVar(&cfg.CompareSchema, "compare-schema", false, "compare the dataset's column metadata with the modelled fields and exit")
+	companies := flag.String("companies", "", "only fetch trips from these comma-separated companies, e.g. \"Flash Cab,Taxi Affiliation Services\"")
+	minFare := flag.Float64("min-fare", 0, "only fetch trips with a fare of at least this much")
+	maxFare := flag.Float64("max-fare", 0, "only fetch trips with a fare of at most this much")
+	pickupAreas := flag.String("pickup-areas", "", "only fetch trips starting in these comma-separated community areas, e.g. 8,32,33")
+	flag.BoolVar(&cfg.CountOnly, "count-only", false, "print the number of matching rows and exit")
+	flag.IntVar(&cfg.MaxPrintRows, "max-print-rows", 50, "stop printing trips after this many rows in total (0 prints all)")
+	flag.StringVar(&cfg.DBHost, "db-host", "localhost", "Postgres host (env PGHOST)")
+	flag.IntVar(&cfg.DBPort, "db-port", 5432, "Postgres port (env PGPORT)")
+	flag.StringVar(&cfg.DBUser, "db-user", "mdidris", "Postgres user (env PGUSER)")
+	flag.StringVar(&cfg.DBPassword, "db-password", "postgres", "Postgres password (env PGPASSWORD)")
+	flag.StringVar(&cfg.DBName, "db-name", "extraction", "Postgres database (env PGDATABASE)")
+	flag.Var(dsnFlag{}, "dsn", "Postgres connection URL or DSN; overrides the -db-* flags (env DATABASE_URL). Repeat to also write to replicas")
+	flag.StringVar(&cfg.ReplicaErrors, "replica-errors", "fatal", "what a failed write to a replica -dsn does: fatal or warn")
+	flag.StringVar(&cfg.SSLMode, "sslmode", "require", "Postgres sslmode: disable, require, verify-ca or verify-full (env PGSSLMODE)")
+	flag.StringVar(&cfg.SSLRootCert, "sslrootcert", "", "CA certificate file used to verify the server (env PGSSLROOTCERT)")
+	flag.StringVar(&cfg.SSLCert, "sslcert", "", "client certificate file (env PGSSLCERT)")
+	flag.StringVar(&cfg.SSLKey, "sslkey", "", "client private key file (env PGSSLKEY)")
+	flag.IntVar(&cfg.GeohashPrecision, "geohash-precision", 7, "characters of pickup geohash to store (0 disables)")
+	flag.BoolVar(&cfg.Diff, "diff", false, "compare fetched trips with the stored rows instead of inserting them")
+	flag.BoolVar(&cfg.Verbose, "verbose", false, "log per-field detail (changed columns in -diff mode)")
+	flag.StringVar(&cfg.AppToken, "app-token", "", "Socrata app token sent as X-App-Token with every API request")
+	flag.BoolVar(&cfg.TokenAsParam, "token-as-param", false, "send -app-token as the $$app_token query parameter instead of the header, for proxies that strip it")
+	flag.BoolVar(&cfg.PrintURL, "print-url", false, "print the URL of the first page request, with the app token masked, and exit")
+	flag.IntVar(&cfg.MaxIdleConnsPerHost, "max-idle-conns-per-host", 8, "idle API connections kept open for reuse")
+	flag.DurationVar(&cfg.IdleConnTimeout, "idle-conn-timeout", 90*time.Second, "how long an idle API connection is kept for reuse")
+	flag.BoolVar(&cfg.HTTP2, "http2", true, "allow HTTP/2 to the API; -http2=false forces HTTP/1.1")
+	flag.StringVar(&cfg.DatasetURL, "dataset-url", "https://data.cityofchicago.org/resource/wrvz-psew.json", "Socrata resource URL to fetch")
+	schema := flag.String("schema", "taxi", "dataset schema: taxi, or tnp for rideshare trips")
+	fieldsFile := flag.String("fields-file", "", "JSON file describing the columns of a dataset other than taxi trips")
+	flag.IntVar(&cfg.DBLoadThreshold, "db-load-threshold", 0, "slow inserts down while the database has more active sessions than this (0 disables; adds a pg_stat_activity query per -db-load-interval)")
+	flag.DurationVar(&cfg.DBLoadInterval, "db-load-interval", 5*time.Second, "how often -db-load-threshold checks the database load")
+	flag.IntVar(&cfg.DBWorkers, "db-workers", 1, "number of goroutines inserting pages concurrently")
+	partitionRange := flag.String("partition-range", "", "create taxi_trips partitioned by month of trip_start_timestamp, with partitions for FROM:TO months, e.g. 2023-01:2024-12")
+	flag.BoolVar(&cfg.Analyze, "analyze", false, "run ANALYZE on the loaded tables after the load")
+	flag.BoolVar(&cfg.Vacuum, "vacuum", false, "with -analyze, run VACUUM (ANALYZE) instead")
+	flag.BoolVar(&cfg.Preflight, "preflight", false, "check the database, API, credentials and output paths the run would use, print the results and exit")
+	flag.BoolVar(&cfg.PrintSchema, "print-schema", false, "print the DDL that creates the taxi_trips tables and exit")
+	flag.IntVar(&cfg.Shards, "shards", 0, "spread taxi trips over this many tables, taxi_trips_0 and up, by trip_id hash (0 keeps one table)")
+	flag.IntVar(&cfg.QueueSize, "queue-size", 4, "pages buffered between fetching and inserting; larger smooths out slow inserts at the cost of memory")
+	flag.BoolVar(&cfg.Keyset, "keyset", false, "page by trip_id instead of $offset")
+	flag.StringVar(&cfg.ResumeFile, "resume-file", "", "save the keyset cursor here after each batch and resume from it (implies -keyset)")
+	flag.BoolVar(&cfg.WithComments, "with-comments", false, "describe each taxi_trips column with COMMENT ON COLUMN")
+	flag.StringVar(&cfg.StatsdAddr, "statsd-addr", "", "send metrics to this StatsD host:port over UDP")
+	flag.Float64Var(&cfg.StatsdRate, "statsd-sample-rate", 1, "fraction of StatsD metrics to send (0-1]")
+	flag.StringVar(&cfg.CPUProfile, "cpuprofile", "", "write a CPU profile to this file (view with go tool pprof)")
+	flag.StringVar(&cfg.MemProfile, "memprofile", "", "write a heap profile to this file at exit (view with go tool pprof)")
+	flag.Int64Var(&cfg.MaxResponseBytes, "max-response-bytes", 50<<20, "fail a page whose response body is larger than this")
+	flag.Int64Var(&cfg.MaxMemory, "max-memory", 0, "pause fetching while the heap is over this many bytes, until queued inserts drain it (0 disables)")
+	flag.DurationVar(&cfg.MemoryInterval, "memory-check-interval", time.Second, "how often -max-memory reads the heap size")
+	flag.IntVar(&cfg.ConfirmThreshold, "confirm-threshold", 0, "ask before fetching more than this many rows, e.g. 1000000 (0 never asks)")
+	flag.BoolVar(&cfg.Yes, "yes", false, "proceed without asking for confirmation")
+	flag.BoolVar(&cfg.DropTable, "drop-table", false, "drop the tables a load writes to, with their shards and partitions, after confirmation (or -yes), and exit")
+	flag.BoolVar(&cfg.DB, "db", true, "load fetched trips into Postgres")
+	flag.StringVar(&cfg.JSONLPath, "jsonl", "", "also write fetched trips to this JSON Lines file")
+	flag.StringVar(&cfg.GeoJSONPath, "geojson", "", "also write the pickup points of fetched trips to this GeoJSON file")
+	flag.BoolVar(&cfg.ContinueOnSinkError, "continue-on-sink-error", false, "log output errors instead of exiting")
+	flag.StringVar(&cfg.SchemaOut, "schema-out", "", "write a JSON Schema of the trip record to this file and exit")
+	flag.DurationVar(&cfg.MaxIdleTime, "max-idle-time", 0, "keep polling after an empty page, exiting with code 0 once pages have been empty for this long, with no 10-minute run limit (0 exits on the first empty page)")
+	flag.StringVar(&cfg.OtelEndpoint, "otel-endpoint", "", "export OpenTelemetry traces as OTLP/HTTP to this collector, e.g. http://localhost:4318")
+	flag.IntVar(&cfg.ServerSample, "server-sample", 0, "fetch this many rows in one request instead of the whole dataset: a block in :id order at a random offset, so only roughly random (0 disables)")
+	flag.IntVar(&cfg.CheckpointEvery, "checkpoint-every", 0, "every this many rows, flush all outputs, save the -resume-file cursor and log a checkpoint (0 disables)")
+	flag.StringVar(&cfg.FormatIn, "format-in", "auto", "response format: "+formatNames()+" (auto picks by Content-Type)")
+	flag.DurationVar(&cfg.PageDeadline, "page-deadline", 0, "give up on a page, retries included, after this long and skip it (fatal with -strict or -keyset; 0 disables)")
+	flag.IntVar(&cfg.MaxOffset, "max-offset", 0, "stop before fetching at or past this offset, as a safety cap (0 disables)")
+	flag.DurationVar(&cfg.DrainTimeout, "drain-timeout", 30*time.Second, "on timeout or shutdown, how long to keep writing already fetched batches (0 stops at once)")
+	flag.StringVar(&cfg.AdminAddr, "admin-addr", "", "serve the admin endpoint (POST /shutdown) on this address, e.g. localhost:8081")
+	flag.StringVar(&cfg.AdminToken, "admin-token", "", "bearer token required by the admin endpoint (env ADMIN_TOKEN)")
+	onlyComplete := flag.Bool("only-complete", false, "drop trips missing any of the -required fields")
+	required := flag.String("required", "start_time,miles,fare", "comma-separated fields -only-complete requires")
+	flag.StringVar(&cfg.ReplayPath, "replay", "", "load trips from a JSONL file written by -jsonl instead of fetching them from the API")
+	flag.IntVar(&cfg.MaxPerTaxi, "max-per-taxi", 0, "keep at most this many trips per taxi_id across the run (0 keeps all; holds one map entry per taxi in memory)")
+	flag.StringVar(&cfg.Report, "report", "", "print an aggregate report at the end of the run: hourly, company, taxi, area or payment")
+	flag.StringVar(&cfg.ReportSource, "report-source", "fetch", "where -report reads trips from: fetch, or db to query taxi_trips instead of fetching")
+	flag.BoolVar(&cfg.SplitWeekend, "split-weekend", false, "split the hourly report into weekdays and weekends")
+	flag.StringVar(&cfg.Color, "color", "auto", "color table headers, failed checks and warnings: auto (when writing to a terminal), always or never")
+	flag.BoolVar(&cfg.StdoutNDJSON, "stdout-ndjson", false, "stream fetched trips to stdout as JSON Lines, with the trip table left out and the summary and reports on stderr")
+	flag.StringVar(&cfg.CSVPath, "csv", "", "also write fetched trips to this CSV file")
+	flag.StringVar(&cfg.InsertedIDsPath, "inserted-ids", "", "write the trip_ids newly inserted into taxi_trips (not updated) to this file")
+	flag.StringVar(&cfg.ErrorDumpPath, "error-dump", "", "write records that fail to decode, with the error and offset, to this JSON Lines file")
+	flag.IntVar(&cfg.ErrorDumpMax, "error-dump-max", 100, "most records to write to -error-dump (0 for no limit)")
+	flag.BoolVar(&cfg.WithProvenance, "with-provenance", false, "store fetched_at and source_url on every row and in exports")
+	flag.BoolVar(&cfg.StoreRaw, "store-raw", false, "store each trip's source record in a raw_json column")
+	flag.BoolVar(&cfg.ValidateOnly, "validate-only", false, "check the rows in taxi_trips for invalid data, print violations per check and exit")
+	validateThresholds := flag.String("validate-thresholds", "", "violations allowed per -validate-only check before it fails, e.g. total_mismatch=100 (default 0)")
+	flag.BoolVar(&cfg.StatsDB, "stats-db", false, "record each run's times, row counts, offset, exit status and filter in the extraction_runs table")
+	flag.BoolVar(&cfg.SinceLastRun, "since-last-run", false, "only fetch trips starting at or after the latest one loaded by the last successful -stats-db run (all trips when there is none)")
+	kafkaBrokers := flag.String("kafka-brokers", "", "also publish trips to Kafka through these comma-separated host:port brokers (needs a build with -tags kafka)")
+	flag.StringVar(&cfg.KafkaTopic, "kafka-topic", "", "Kafka topic trips are published to, keyed by trip_id")
+	flag.IntVar(&cfg.KafkaBatchSize, "kafka-batch-size", 100, "most trips per Kafka produce request")
+	flag.DurationVar(&cfg.KafkaBatchTimeout, "kafka-batch-timeout", time.Second, "longest a Kafka batch waits to fill before it is sent")
+	flag.StringVar(&cfg.KafkaErrors, "kafka-errors", "fatal", "what a failed Kafka delivery does: fatal or warn")
+	flag.StringVar(&cfg.RunLog, "run-log", "", "append each run's times, row counts, offset, exit status and filter to this CSV file")
+	flag.BoolVar(&cfg.FailOnEmpty, "fail-on-empty", false, "exit with a non-zero code when the run fetches no records")
+	flag.BoolVar(&cfg.Explain, "explain", false, "print what the run would do with the resolved settings and exit, without touching the network or database")
+	flag.BoolVar(&cfg.Reparse, "reparse", false, "recompute the derived columns of rows stored with -store-raw from raw_json and exit")
+	flag.BoolVar(&cfg.KeepPartial, "keep-partial", false, "keep a failed -csv or -jsonl export as <path>.partial instead of removing it")
+	flag.StringVar(&cfg.ImportCSV, "import-csv", "", "load trips from a CSV file in the -csv format instead of fetching them from the API")
+	flag.StringVar(&cfg.CSVNull, "csv-null", "", "text written in -csv output, and read by -import-csv, for fields absent from the source")
+	startFromDate := flag.String("start-from-date", "", "fetch trips starting on or after this date (YYYY-MM-DD), paging by start time (implies -keyset)")
+	envFile := flag.String("env-file", "", "load KEY=VALUE lines from this file into the environment")
+	profile := flag.String("profile", "", "layer config.NAME.yaml over config.yaml; flags, then the environment, take precedence over both")
+	tzOutput := flag.String("tz-output", "", "display and export timestamps in this IANA zone with their offset (default: the source zone)")
+	floatPrecision := flag.String("float-precision", "", "decimals per float column in the table and CSV, e.g. trip_miles=4,fare=2 (table default 2, CSV default all)")
+	idsFile := flag.String("ids-file", "", "fetch only the trips whose trip_ids are listed in this file, one per line, and report the ones not found")
+	fieldMap := flag.String("field-map", "", "read trip fields from differently named dataset columns, e.g. fare=total_fare,company=operator")
+	tz := flag.String("tz", "", "interpret trip timestamps in this IANA zone (e.g. America/Chicago)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *envFile != "" {
+		if err := loadEnvFile(*envFile); err != nil {
+			log.Fatalf("invalid -env-file: %v", err)
+		}
+	}
+	applyEnv()
+	if err := loadConfigFiles(*profile); err != nil {
+		log.Fatalf("invalid config: %v", err)
+	}
+
+	for _, c := range strings.Split(*companies, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			cfg.Companies = append(cfg.Companies, c)
+		}
+	}
+
+	if isFlagSet("min-fare") {
+		cfg.MinFare = minFare
+	}
+	if isFlagSet("max-fare") {
+		cfg.MaxFare = maxFare
+	}
+	if cfg.MinFare != nil && cfg.MaxFare != nil && *cfg.MinFare > *cfg.MaxFare {
+		log.Fatalf("invalid fare range: -min-fare %g is above -max-fare %g", *cfg.MinFare, *cfg.MaxFare)
+	}
+
+	thresholds, err := parseThresholds(*validateThresholds)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("invalid -validate-thresholds: %v", err)
 	}
-}
+	cfg.ValidateMax = thresholds
 
-func fetchAndPrinttaxitrips(ctx context.Context, db *sql.DB) {
-	offset := 0
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Context canceled. Exiting fetchAndPrinttaxitrips.")
-			return
-		default:
-			url := fmt.Sprintf("https://data.cityofchicago.org/resource/wrvz-psew.json?$limit=100&$offset=%d", offset)
-			log.Printf("Fetching data from: %s\n", url)
-			resp, err := http.Get(url)
-			if err != nil {
-				log.Fatal(err)
-			}
-			defer resp.Body.Close()
-			log.Println("Response received from the API")
+	if *partitionRange != "" {
+		r, err := parseMonthRange(*partitionRange)
+		if err != nil {
+			log.Fatalf("invalid -partition-range: %v", err)
+		}
+		cfg.PartitionRange = r
+	}
 
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				log.Fatal(err)
-			}
+	precision, err := parseFloatPrecision(*floatPrecision)
+	if err != nil {
+		log.Fatalf("invalid -float-precision: %v", err)
+	}
+	cfg.FloatPrecision = precision
 
-			var trips []data_fetched
-			err = json.Unmarshal(body, &trips)
+	if *pickupAreas != "" {
+		for _, a := range strings.Split(*pickupAreas, ",") {
+			area, err := strconv.Atoi(strings.TrimSpace(a))
 			if err != nil {
-				log.Fatal(err)
+				log.Fatalf("invalid -pickup-areas: %q is not an integer", a)
 			}
+			cfg.PickupAreas = append(cfg.PickupAreas, area)
+		}
+	}
 
-			if len(trips) == 0 {
-				break // Exit the loop if no more data is returned
-			}
+	if *tz != "" {
+		loc, err := time.LoadLocation(*tz)
+		if err != nil {
+			log.Fatalf("invalid -tz: %v", err)
+		}
+		cfg.Normalize.Location = loc
+	}
+	if *tzOutput != "" {
+		loc, err := time.LoadLocation(*tzOutput)
+		if err != nil {
+			log.Fatalf("invalid -tz-output %q: %v\nUse an IANA zone name such as UTC or America/Chicago; "+
+				"valid names are listed under /usr/share/zoneinfo and at https://en.wikipedia.org/wiki/List_of_tz_database_time_zones",
+				*tzOutput, err)
+		}
+		cfg.OutputLocation = loc
+	}
 
-			printTable(trips)
-			offset += 100
+	if *startFromDate != "" {
+		d, err := time.Parse("2006-01-02", *startFromDate)
+		if err != nil {
+			log.Fatalf("invalid -start-from-date: %v", err)
+		}
+		cfg.StartFromDate = d
+		cfg.Keyset = true
+	}
+	if cfg.ResumeFile != "" {
+		cfg.Keyset = true
+	}
+	if cfg.SinceLastRun {
+		switch {
+		case !cfg.StatsDB || !cfg.DB || cfg.Diff:
+			log.Fatal("-since-last-run needs -stats-db and trips loaded into Postgres, so the run records where the next one starts")
+		case cfg.Loader != nil || fromFile() || *idsFile != "":
+			log.Fatal("-since-last-run is only supported when fetching taxi trips from the API")
+		case !cfg.StartFromDate.IsZero():
+			log.Fatal("-since-last-run and -start-from-date both set where the load starts; use one")
+		}
+	}
+	if _, ok := reports[cfg.Report]; cfg.Report != "" && !ok {
+		log.Fatalf("invalid -report %q", cfg.Report)
+	}
+	switch cfg.ReportSource {
+	case "fetch":
+	case "db":
+		if cfg.Report == "" {
+			log.Fatal("-report-source db needs a -report")
+		}
+		if cfg.Where != "" {
+			log.Fatal("-where is SoQL and cannot be applied with -report-source db")
 		}
+	default:
+		log.Fatalf("invalid -report-source %q: want fetch or db", cfg.ReportSource)
+	}
+
+	switch *schema {
+	case "taxi":
+	case "tnp":
+		cfg.Loader = tnpSchema{}
+		if !isFlagSet("dataset-url") {
+			cfg.DatasetURL = tnpDatasetURL
+		}
+	default:
+		log.Fatalf("invalid -schema %q: want taxi or tnp", *schema)
+	}
+
+	if *fieldsFile != "" {
+		spec, err := loadFieldsFile(*fieldsFile)
+		if err != nil {
+			log.Fatalf("invalid -fields-file: %v", err)
+		}
+		cfg.Loader = spec
+	}
+
+	if cfg.Loader != nil {
+		if cfg.Diff {
+			log.Fatal("-diff is only supported for taxi trips")
+		}
+		if cfg.Keyset {
+			log.Fatal("-keyset, -resume-file and -start-from-date are only supported for taxi trips")
+		}
+		if cfg.CSVPath != "" || cfg.JSONLPath != "" || cfg.GeoJSONPath != "" || cfg.Report != "" {
+			log.Fatal("-csv, -jsonl, -geojson and -report are only supported for taxi trips")
+		}
+		knownFields = cfg.Loader.names()
+	}
+
+	for _, b := range strings.Split(*kafkaBrokers, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			cfg.KafkaBrokers = append(cfg.KafkaBrokers, b)
+		}
+	}
+	if len(cfg.KafkaBrokers) > 0 {
+		switch {
+		case !kafkaBuilt:
+			log.Fatal("-kafka-brokers: this binary was built without Kafka support; rebuild with -tags kafka")
+		case cfg.KafkaTopic == "":
+			log.Fatal("-kafka-brokers needs -kafka-topic")
+		case cfg.Loader != nil:
+			log.Fatal("-kafka-brokers is only supported for taxi trips")
+		case cfg.KafkaBatchSize < 1:
+			log.Fatalf("invalid -kafka-batch-size %d: must be at least 1", cfg.KafkaBatchSize)
+		case cfg.KafkaErrors != "fatal" && cfg.KafkaErrors != "warn":
+			log.Fatalf("invalid -kafka-errors %q: want fatal or warn", cfg.KafkaErrors)
+		}
+	}
+
+	if *fieldMap != "" {
+		if cfg.Loader != nil {
+			log.Fatal("-field-map is only supported for taxi trips")
+		}
+		m, err := parseFieldMap(*fieldMap)
+		if err != nil {
+			log.Fatalf("invalid -field-map: %v", err)
+		}
+		cfg.FieldMap = m
+	}
+
+	if *onlyComplete {
+		if cfg.Loader != nil {
+			log.Fatal("-only-complete is only supported for taxi trips")
+		}
+		f, err := newCompleteFilter(*required)
+		if err != nil {
+			log.Fatalf("invalid -required: %v", err)
+		}
+		cfg.Complete = f
+	}
+
+	if _, err := selectDecoder(""); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.ServerSample > 0 && (cfg.Loader != nil || cfg.Keyset || fromFile()) {
+		log.Fatal("-server-sample is only supported for taxi trips fetched by offset")
+	}
+	if cfg.InsertedIDsPath != "" && (cfg.Loader != nil || !cfg.DB || cfg.Diff) {
+		log.Fatal("-inserted-ids needs taxi trips loaded into Postgres")
+	}
+	if cfg.Shards < 0 {
+		log.Fatalf("invalid -shards %d: must not be negative", cfg.Shards)
+	}
+	if cfg.Shards > 0 && cfg.Loader != nil {
+		log.Fatal("-shards is only supported for taxi trips")
+	}
+	if cfg.PartitionRange != nil && (cfg.Shards > 0 || cfg.Loader != nil) {
+		log.Fatal("-partition-range is only supported for taxi trips without -shards")
+	}
+	if cfg.DBLoadThreshold > 0 && cfg.DBLoadInterval <= 0 {
+		log.Fatalf("invalid -db-load-interval %s: must be positive", cfg.DBLoadInterval)
+	}
+	if cfg.MaxMemory > 0 && cfg.MemoryInterval <= 0 {
+		log.Fatalf("invalid -memory-check-interval %s: must be positive", cfg.MemoryInterval)
+	}
+	if cfg.QueueSize < 0 {
+		log.Fatalf("invalid -queue-size %d: must not be negative", cfg.QueueSize)
+	}
+	if cfg.Vacuum && !cfg.Analyze {
+		log.Fatal("-vacuum needs -analyze")
+	}
+	if cfg.Color != "auto" && cfg.Color != "always" && cfg.Color != "never" {
+		log.Fatalf("invalid -color %q: want auto, always or never", cfg.Color)
+	}
+	if cfg.TokenAsParam && cfg.AppToken == "" {
+		log.Fatal("-token-as-param needs -app-token")
+	}
+	if cfg.PrintURL && fromFile() {
+		log.Fatal("-print-url cannot be combined with -replay or -import-csv")
+	}
+	if cfg.CountAttempts < 1 {
+		log.Fatalf("invalid -count-attempts %d: must be at least 1", cfg.CountAttempts)
+	}
+	if cfg.DBAttempts < 1 {
+		log.Fatalf("invalid -db-attempts %d: must be at least 1", cfg.DBAttempts)
+	}
+	if cfg.RetryBase < 0 || cfg.RetryMax < cfg.RetryBase {
+		log.Fatalf("invalid -retry-base %s and -retry-max %s: want 0 <= base <= max", cfg.RetryBase, cfg.RetryMax)
+	}
+	if cfg.MaxIdleConnsPerHost < 1 {
+		log.Fatalf("invalid -max-idle-conns-per-host %d: must be at least 1", cfg.MaxIdleConnsPerHost)
+	}
+	if cfg.IdleConnTimeout < 0 {
+		log.Fatalf("invalid -idle-conn-timeout %s: must not be negative", cfg.IdleConnTimeout)
+	}
+	apiClient.Transport = newAPITransport()
+	if cfg.ReplicaErrors != "fatal" && cfg.ReplicaErrors != "warn" {
+		log.Fatalf("invalid -replica-errors %q: want fatal or warn", cfg.ReplicaErrors)
+	}
+	if len(cfg.ReplicaDSNs) > 0 && (!cfg.DB || cfg.Diff) {
+		log.Fatal("a second -dsn needs trips loaded into Postgres")
+	}
+	if cfg.WithProvenance && cfg.Loader != nil {
+		log.Fatal("-with-provenance is only supported for taxi trips")
+	}
+	if (cfg.StoreRaw || cfg.Reparse) && (cfg.Loader != nil || !cfg.DB || cfg.Diff) {
+		log.Fatal("-store-raw and -reparse need taxi trips loaded into Postgres")
+	}
+	if fromFile() {
+		// Files exported with -with-provenance carry these as well.
+		for _, c := range provenanceColumns {
+			knownFields[c] = true
+		}
+	}
+
+	if cfg.Distinct != "" && !knownFields[cfg.Distinct] {
+		log.Fatalf("invalid -distinct: unknown field %q", cfg.Distinct)
+	}
+
+	if *idsFile != "" {
+		if cfg.Loader != nil || cfg.Keyset || fromFile() || cfg.ServerSample > 0 {
+			log.Fatal("-ids-file is only supported for taxi trips fetched by offset")
+		}
+		ids, err := readIDsFile(*idsFile)
+		if err != nil {
+			log.Fatalf("invalid -ids-file: %v", err)
+		}
+		cfg.IDChunks = chunkIDs(ids, idsPerChunk, idsMaxQuery)
+		// At most the listed trips are fetched, whatever the dataset holds.
+		cfg.ConfirmThreshold = 0
+	}
+
+	if cfg.StdoutNDJSON {
+		if cfg.Loader != nil {
+			log.Fatal("-stdout-ndjson is only supported for taxi trips")
+		}
+		textOut = os.Stderr
+	}
+
+	if cfg.ReplayPath != "" && cfg.ImportCSV != "" {
+		log.Fatal("-replay and -import-csv cannot be combined")
+	}
+	if fromFile() {
+		if cfg.Loader != nil {
+			log.Fatal("-replay and -import-csv are only supported for taxi trips")
+		}
+		if cfg.CountOnly || cfg.Keyset {
+			log.Fatal("-replay and -import-csv cannot be combined with -count-only, -keyset, -resume-file or -start-from-date")
+		}
+		// There is nothing to count or confirm without the API.
+		cfg.ConfirmThreshold = 0
 	}
 }
 
-func printTable(trips []data_fetched) {
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Trip ID", "Taxi ID", "Start Time", "End Time", "Seconds", "Miles", "Fare", "Tips", "Total"})
-	for _, trip := range trips {
-		table.Append([]string{
-			trip.TripID,
-			trip.TaxiID,
-			trip.TripStartTimestamp.Time.Format(time.RFC3339),
-			trip.TripEndTimestamp.Time.Format(time.RFC3339),
-			strconv.Itoa(trip.TripSeconds.Int),
-			strconv.FormatFloat(trip.TripMiles.Float64, 'f', 2, 64),
-			strconv.FormatFloat(trip.Fare.Float64, 'f', 2, 64),
-			strconv.FormatFloat(trip.Tips.Float64, 'f', 2, 64),
-			strconv.FormatFloat(trip.TripTotal.Float64, 'f', 2, 64),
-		})
+// fromFile reports whether trips are loaded from -replay or -import-csv
+// instead of the API.
+func fromFile() bool {
+	return cfg.ReplayPath != "" || cfg.ImportCSV != ""
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+	return set
+}
+
+// applyEnv fills flags that were not set explicitly from their environment
+// variables, so the precedence is flag, then environment, then default.
+func applyEnv() {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for name, env := range envFlags {
+		value, ok := os.LookupEnv(env)
+		if set[name] || !ok {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			log.Fatalf("invalid %s: %v", env, err)
+		}
+	}
+}
+
+// Exit codes. They are listed in the -h output.
+const (
+	exitOK       = 0 // every fetched record was loaded
+	exitFatal    = 1 // bad configuration, no database connection or another fatal error
+	exitSkipped  = 2 // the run completed but some records were skipped
+	exitCanceled = 3 // the run was stopped by the timeout, a signal or POST /shutdown
+	exitEmpty    = 4 // -fail-on-empty was set and no records were fetched
+	exitInvalid  = 5 // -validate-only found more violations than allowed
+	exitFetch    = 6 // the API kept failing (a FetchError)
+	exitParse    = 7 // a record could not be decoded with -strict (a ParseError)
+	exitDB       = 8 // the database rejected a write during the run (a DBError)
+)
+
+var exitDescriptions = map[int]string{
+	exitOK:       "success",
+	exitFatal:    "fatal error",
+	exitSkipped:  "completed with skipped records",
+	exitCanceled: "canceled by timeout, signal or shutdown request",
+	exitEmpty:    "no records fetched",
+	exitInvalid:  "validation checks failed",
+	exitFetch:    "API requests failed",
+	exitParse:    "record could not be decoded",
+	exitDB:       "database error",
+}
+
+// textOut receives the human-readable output of a load: the trip table,
+// reports and the summary. It is stderr with -stdout-ndjson, so stdout
+// carries nothing but trips.
+var textOut io.Writer = os.Stdout
+
+// skippedRecords counts records that were fetched but not loaded: ones that
+// failed to decode, were kept out of taxi_trips by -strict, or were in a
+// batch that could not be written.
+var skippedRecords atomic.Int64
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(flag.CommandLine.Output(), "\nExit codes:\n")
+	for code := exitOK; code <= exitDB; code++ {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %d  %s\n", code, exitDescriptions[code])
+	}
+}
+
+func main() {
+	os.Exit(run())
+}
+
+func run() (code int) {
+	parseFlags()
+
+	runID := newRunID()
+	setupLogging(runID)
+
+	if cfg.Explain {
+		explain(os.Stdout)
+		return exitOK
+	}
+
+	if cfg.PrintSchema {
+		fmt.Print(schemaDDL())
+		return exitOK
+	}
+
+	if cfg.PrintURL {
+		if err := printURL(os.Stdout); err != nil {
+			return failed(err)
+		}
+		return exitOK
+	}
+
+	if cfg.SchemaOut != "" {
+		if err := writeSchema(cfg.SchemaOut); err != nil {
+			return failed(err)
+		}
+		log.Printf("Wrote JSON Schema to %s\n", cfg.SchemaOut)
+		return exitOK
+	}
+
+	if cfg.CompareSchema {
+		columns, err := fetchMetadata()
+		if err != nil {
+			return failed(err)
+		}
+		compareSchema(os.Stdout, columns)
+		return exitOK
+	}
+
+	if cfg.Distinct != "" {
+		values, err := fetchDistinct(cfg.Distinct)
+		if err != nil && values == nil {
+			return failed(err)
+		}
+		printDistinct(os.Stdout, cfg.Distinct, values)
+		if err != nil {
+			log.Println(err)
+		}
+		return exitOK
+	}
+
+	if cfg.CountOnly {
+		total, err := fetchTotalCount(context.Background())
+		if err != nil {
+			return failed(err)
+		}
+		if cfg.Where != "" {
+			fmt.Printf("%d rows match $where %s\n", total, cfg.Where)
+		} else {
+			fmt.Printf("%d rows in dataset\n", total)
+		}
+		return exitOK
+	}
+
+	if cfg.Preflight {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if !preflight(ctx, os.Stdout) {
+			return exitFatal
+		}
+		return exitOK
+	}
+
+	if cfg.SinceLastRun {
+		if err := applySinceLastRun(); err != nil {
+			return failed(err)
+		}
+	}
+
+	if err := confirmLoad(); err != nil {
+		return failed(err)
+	}
+
+	defer startProfiling()()
+
+	if cfg.StatsdAddr != "" {
+		stats = newStatsdClient(cfg.StatsdAddr, cfg.StatsdRate)
+		defer stats.close()
+	}
+	if cfg.OtelEndpoint != "" {
+		otel = newTracer(cfg.OtelEndpoint)
+		defer otel.close()
+	}
+
+	log.Printf("Starting run %s\n", runID)
+
+	conn, err := connString()
+	if err != nil {
+		return failed(err)
+	}
+	log.Printf("Connecting to %s\n", redactDSN(conn))
+	db, err := sql.Open("postgres", conn)
+	if err != nil {
+		return failed(err)
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// A run polling with -max-idle-time has no time limit: it runs until it
+	// is idle for that long, a signal or POST /shutdown.
+	var cancel context.CancelFunc
+	if cfg.MaxIdleTime > 0 {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, 10*time.Minute)
+	}
+	defer cancel()
+	runFailure.cancel = cancel
+	ctx, runSpan := otel.start(ctx, "run")
+
+	// Batches already fetched are still written after ctx is done, until
+	// the drain timeout.
+	work, hardStop := drainContext(ctx, cfg.DrainTimeout)
+	defer hardStop()
+
+	// Set up timer
+	if cfg.MaxIdleTime <= 0 {
+		timer := time.NewTimer(10 * time.Minute)
+
+		go func() {
+			<-timer.C
+			log.Println("Timer expired. Exiting program.")
+			cancel()
+		}()
+	}
+
+	if cfg.DB || cfg.Diff || cfg.ReportSource == "db" || cfg.ValidateOnly || cfg.StatsDB || cfg.DropTable {
+		if err := db.PingContext(ctx); err != nil {
+			return failed(describeConnError(err))
+		}
+	}
+
+	if cfg.DropTable {
+		if err := dropTables(ctx, db); err != nil {
+			return failed(fmt.Errorf("drop-table: %w", err))
+		}
+		return exitOK
+	}
+
+	if cfg.ValidateOnly {
+		passed, err := validateDB(ctx, db, os.Stdout)
+		if err != nil {
+			return failed(fmt.Errorf("validate: %w", err))
+		}
+		if !passed {
+			return exitInvalid
+		}
+		return exitOK
+	}
+
+	if cfg.ReportSource == "db" {
+		if err := reportFromDB(ctx, db, os.Stdout); err != nil {
+			return failed(fmt.Errorf("report: %w", err))
+		}
+		return exitOK
+	}
+
+	// The run is recorded from here on, failures included: every later
+	// error returns through run() rather than exiting.
+	prog := &progress{start: time.Now()}
+	if cfg.StatsDB {
+		if err := createRunsTable(ctx, db); err != nil {
+			return failed(err)
+		}
+		defer func() { recordRun(db, collectRunStats(runID, prog, code)) }()
+	}
+	if cfg.RunLog != "" {
+		defer func() { logRun(cfg.RunLog, collectRunStats(runID, prog, code)) }()
+	}
+
+	switch {
+	case !cfg.DB || cfg.Diff:
+	case cfg.Loader != nil:
+		if err := cfg.Loader.createTable(ctx, db); err != nil {
+			return failed(fmt.Errorf("creating tables: %w", err))
+		}
+	default:
+		if err := createTable(ctx, db); err != nil {
+			return failed(err)
+		}
+	}
+	if cfg.InsertedIDsPath != "" {
+		if insertedIDs, err = openIDLog(cfg.InsertedIDsPath); err != nil {
+			return failed(err)
+		}
+		defer func() { insertedIDs.close(code == exitOK || code == exitSkipped) }()
+	}
+	if cfg.ErrorDumpPath != "" {
+		if errorDump, err = openDumpFile(cfg.ErrorDumpPath, cfg.ErrorDumpMax); err != nil {
+			return failed(err)
+		}
+		defer errorDump.close()
+	}
+	replicas, err := openReplicas(ctx)
+	if err != nil {
+		return failed(err)
+	}
+	for _, r := range replicas {
+		defer r.Close()
+	}
+
+	if cfg.Reparse {
+		updated, err := reparse(ctx, db)
+		if err != nil {
+			return failed(fmt.Errorf("reparse: %w", err))
+		}
+		fmt.Printf("%d rows reparsed\n", updated)
+		return exitOK
+	}
+
+	if cfg.AdminAddr != "" {
+		srv, err := serveAdmin(cfg.AdminAddr, cfg.AdminToken, cancel, prog)
+		if err != nil {
+			return failed(err)
+		}
+		defer srv.Close()
+	}
+	rows, err := fetchAndPrinttaxitrips(ctx, work, db, replicas, prog)
+	if err == nil {
+		err = runFailure.get()
+	}
+	if err != nil {
+		log.Printf("Run failed: %v\n", err)
+	}
+
+	// Nothing was loaded with -db=false or -diff, and a failed or canceled
+	// run is better restarted than followed by a long VACUUM.
+	if cfg.Analyze && cfg.DB && !cfg.Diff && rows > 0 && err == nil && ctx.Err() == nil {
+		if err := analyze(ctx, db, cfg.Vacuum); err != nil {
+			log.Printf("WARNING: %v\n", err)
+		}
+	}
+
+	skipped := skippedRecords.Load()
+	code = outcomeCode(err, ctx.Err() != nil, rows, skipped)
+	fmt.Fprintf(textOut, "Summary: %d rows fetched, %d skipped; exiting with code %d (%s)\n", rows, skipped, code, exitDescriptions[code])
+	runSpan.set("rows", rows)
+	runSpan.set("skipped", int(skipped))
+	runSpan.set("exit_code", code)
+	if err == nil {
+		err = ctx.Err()
+	}
+	runSpan.finish(err)
+	return code
+}
+
+// failed logs err, which ends the run before anything was fetched, and
+// returns its exitCode.
+func failed(err error) int {
+	log.Print(err)
+	return exitCode(err)
+}
+
+// outcomeCode returns the exit code of a run that fetched rows and then
+// ended with err, which is nil unless it failed, and whether it was
+// canceled.
+func outcomeCode(err error, canceled bool, rows int, skipped int64) int {
+	switch {
+	case err != nil:
+		return exitCode(err)
+	case canceled:
+		return exitCanceled
+	case skipped > 0:
+		return exitSkipped
+	case rows == 0 && cfg.FailOnEmpty:
+		return exitEmpty
+	}
+	return exitOK
+}
+
+// drainContext returns a context that outlives parent by timeout, so work
+// already started can finish after a signal or the run timeout. A timeout
+// of 0 ends it together with parent.
+func drainContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(parent))
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-parent.Done():
+		}
+		if timeout > 0 {
+			log.Printf("Stopping; draining in-flight batches for up to %s\n", timeout)
+			t := time.NewTimer(timeout)
+			defer t.Stop()
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				log.Println("Drain timeout reached; abandoning remaining batches.")
+			}
+		}
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// schemaDDL returns the statements that create taxi_trips and
+// taxi_trips_anomalies, and with -partition-range the monthly partitions.
+func schemaDDL() string {
+	// A partitioned table's key must include its partition column.
+	key, partition := "trip_id TEXT PRIMARY KEY,", ")"
+	if cfg.PartitionRange != nil {
+		key = "trip_id TEXT,"
+		partition = ",\n            PRIMARY KEY (trip_id, trip_start_timestamp)\n        ) PARTITION BY RANGE (trip_start_timestamp)"
+	}
+	ddl := `
+        CREATE TABLE IF NOT EXISTS taxi_trips (
+            ` + key + `
+            taxi_id TEXT,
+            trip_start_timestamp TIMESTAMP,
+            trip_end_timestamp TIMESTAMP,
+            trip_seconds INTEGER,
+            trip_miles FLOAT,
+            pickup_census_tract TEXT,
+            dropoff_census_tract TEXT,
+            pickup_community_area INTEGER,
+            dropoff_community_area INTEGER,
+            fare FLOAT,
+            tips FLOAT,
+            tolls FLOAT,
+            extras FLOAT,
+            trip_total FLOAT,
+            payment_type TEXT,
+            company TEXT,
+            pickup_centroid_latitude FLOAT,
+            pickup_centroid_longitude FLOAT,
+            pickup_centroid_location TEXT,
+            dropoff_centroid_latitude FLOAT,
+            dropoff_centroid_longitude FLOAT,
+            dropoff_centroid_location TEXT,
+            pickup_geohash TEXT,
+            row_hash TEXT` + partition + `;
+        ALTER TABLE taxi_trips ADD COLUMN IF NOT EXISTS pickup_geohash TEXT;
+        ALTER TABLE taxi_trips ADD COLUMN IF NOT EXISTS row_hash TEXT;
+        CREATE INDEX IF NOT EXISTS taxi_trips_pickup_geohash_idx ON taxi_trips (pickup_geohash);
+        CREATE TABLE IF NOT EXISTS taxi_trips_anomalies (
+            LIKE taxi_trips,
+            reason TEXT
+        );
+    `
+	if cfg.PartitionRange != nil {
+		ddl = strings.TrimRight(ddl, " ") + partitionDDL(*cfg.PartitionRange)
+	}
+	return ddl
+}
+
+func createTable(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, schemaDDL()); err != nil {
+		return fmt.Errorf("creating tables: %w", err)
+	}
+
+	if cfg.WithProvenance {
+		if err := addProvenanceColumns(ctx, db); err != nil {
+			return fmt.Errorf("creating tables: %w", err)
+		}
+	}
+	if cfg.StoreRaw || cfg.Reparse {
+		if err := addRawColumns(ctx, db); err != nil {
+			return fmt.Errorf("creating tables: %w", err)
+		}
+	}
+
+	if cfg.WithComments {
+		if err := commentColumns(ctx, db); err != nil {
+			return fmt.Errorf("creating tables: %w", err)
+		}
+	}
+
+	if cfg.Shards > 0 {
+		if err := createShards(ctx, db, cfg.Shards); err != nil {
+			return fmt.Errorf("creating tables: %w", err)
+		}
+	}
+	return nil
+}
+
+// rowHash fingerprints a row's source values so changed rows can be found
+// without comparing every column.
+func rowHash(values []any) string {
+	h := sha256.New()
+	for _, v := range values {
+		io.WriteString(h, formatValue(v))
+		h.Write([]byte{0x1f})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// formatValue renders a column value the same way whether it came from a
+// decoded trip or was scanned back from Postgres. Timestamps are compared by
+// wall clock because the columns are TIMESTAMP without time zone.
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format("2006-01-02T15:04:05.999999999")
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// insertSQL upserts one trip, replacing the stored row when trip_id exists.
+var insertSQL = upsertSQL("taxi_trips", tripColumns, "trip_id")
+
+// quoteIdentifiers quotes names for use as SQL identifiers, so reserved
+// words and mixed case work.
+func quoteIdentifiers(names []string) []string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = pq.QuoteIdentifier(name)
+	}
+	return quoted
+}
+
+// upsertSQL builds an INSERT of one row into table that updates the other
+// columns when key already exists. An empty key gives a plain INSERT. All
+// names are quoted.
+func upsertSQL(table string, columns []string, key string) string {
+	if key == "" {
+		return upsertKeySQL(table, columns, nil)
+	}
+	return upsertKeySQL(table, columns, []string{key})
+}
+
+// upsertKeySQL is upsertSQL for a key of any number of columns.
+func upsertKeySQL(table string, columns []string, key []string) string {
+	placeholders := make([]string, len(columns))
+	var updates []string
+	for i, col := range columns {
+		placeholders[i] = "$" + strconv.Itoa(i+1)
+		if !slices.Contains(key, col) {
+			col = pq.QuoteIdentifier(col)
+			updates = append(updates, col+" = EXCLUDED."+col)
+		}
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", pq.QuoteIdentifier(table),
+		strings.Join(quoteIdentifiers(columns), ", "), strings.Join(placeholders, ", "))
+	conflict := strings.Join(quoteIdentifiers(key), ", ")
+	switch {
+	case len(key) == 0:
+	case len(updates) == 0:
+		query += fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", conflict)
+	default:
+		query += fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", conflict, strings.Join(updates, ", "))
+	}
+	return query
+}
+
+// insertRows executes query once per row in a single transaction.
+func insertRows(ctx context.Context, db *sql.DB, query string, rows [][]any) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// recordLoader loads raw records into a table other than taxi_trips, for
+// -schema and -fields-file. Pages go through the same fetch loop and insert
+// workers as taxi trips.
+type recordLoader interface {
+	createTable(ctx context.Context, db *sql.DB) error
+	insertRecords(ctx context.Context, db *sql.DB, records []json.RawMessage) error
+	// names returns the JSON keys the loader models, for checkSchemaDrift.
+	names() map[string]bool
+}
+
+// anomalySQL records a trip flagged by validateTrip along with its reasons.
+var anomalySQL = anomalyInsertSQL(tripColumns)
+
+func anomalyInsertSQL(columns []string) string {
+	placeholders := make([]string, len(columns)+1)
+	for i := range placeholders {
+		placeholders[i] = "$" + strconv.Itoa(i+1)
+	}
+	return fmt.Sprintf("INSERT INTO taxi_trips_anomalies (%s, reason) VALUES (%s)",
+		strings.Join(quoteIdentifiers(columns), ", "), strings.Join(placeholders, ", "))
+}
+
+// insertTrips upserts a page of trips in a single transaction. Trips that
+// fail validateTrip are also copied to taxi_trips_anomalies, and in strict
+// mode only go there. With -with-provenance the batch's fetch time and URL
+// are stored on every row, with -store-raw the source record is too, and
+// with ids set the ids of new trips are collected once the transaction
+// commits.
+func insertTrips(ctx context.Context, db *sql.DB, b batch, ids *idLog) error {
+	specs := insertSpecs()
+	columns := columnNames(specs)
+	anomalyQuery := anomalySQL
+	if len(columns) > len(tripColumns) {
+		anomalyQuery = anomalyInsertSQL(columns)
+	}
+	var inserted []string
+	skipped := 0 // counted once the transaction commits, as it may be retried
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// One statement per table written, which is more than one only with
+	// -shards.
+	stmts := make(map[string]*sql.Stmt)
+	defer func() {
+		for _, stmt := range stmts {
+			stmt.Close()
+		}
+	}()
+	prepare := func(table string) (*sql.Stmt, error) {
+		if stmt, ok := stmts[table]; ok {
+			return stmt, nil
+		}
+		query := insertSQL
+		if table != "taxi_trips" || len(columns) > len(tripColumns) || cfg.PartitionRange != nil {
+			query = upsertKeySQL(table, columns, tripKey())
+		}
+		if ids != nil {
+			query += returningSQL
+		}
+		stmt, err := tx.PrepareContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		stmts[table] = stmt
+		return stmt, nil
+	}
+
+	anomalyStmt, err := tx.PrepareContext(ctx, anomalyQuery)
+	if err != nil {
+		return err
+	}
+	defer anomalyStmt.Close()
+
+	for _, trip := range b.trips {
+		// The partition key is part of the primary key, so it cannot be
+		// NULL, in taxi_trips_anomalies either.
+		if cfg.PartitionRange != nil && !trip.TripStartTimestamp.Valid {
+			logger(ctx).Warn("Trip skipped: no trip_start_timestamp to partition by", "trip_id", trip.TripID)
+			skipped++
+			continue
+		}
+		values := columnValues(specs, trip, b)
+		if reasons := validateTrip(trip); len(reasons) > 0 {
+			reason := strings.Join(reasons, "; ")
+			logger(ctx).Warn("Trip flagged", "trip_id", trip.TripID, "reason", reason)
+			if _, err := anomalyStmt.ExecContext(ctx, append(values, reason)...); err != nil {
+				return fmt.Errorf("quarantine trip %s: %w", trip.TripID, err)
+			}
+			// Strict mode keeps flagged trips out of the main table.
+			if cfg.Strict {
+				skipped++
+				continue
+			}
+		}
+		stmt, err := prepare(tripTable(trip.TripID))
+		if err != nil {
+			return err
+		}
+		if ids == nil {
+			if _, err := stmt.ExecContext(ctx, values...); err != nil {
+				return fmt.Errorf("insert trip %s: %w", trip.TripID, err)
+			}
+			continue
+		}
+		var isNew bool
+		if err := stmt.QueryRowContext(ctx, values...).Scan(&isNew); err != nil {
+			return fmt.Errorf("insert trip %s: %w", trip.TripID, err)
+		}
+		if isNew {
+			inserted = append(inserted, trip.TripID)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	skippedRecords.Add(int64(skipped))
+	ids.add(inserted)
+	return nil
+}
+
+// batch is one page handed to the sinks: decoded taxi trips, or raw records
+// for cfg.Loader.
+type batch struct {
+	trips   []data_fetched
+	records []json.RawMessage
+	seq     int // position in the run, for the resume cursor
+	offset  int // $offset of the page, for logging
+
+	fetchedAt time.Time
+	source    string // page URL, or the file trips were read from
+}
+
+func (b batch) size() int {
+	return len(b.trips) + len(b.records)
+}
+
+// exportTime converts ct to -tz-output for export, marking it zoned so it
+// is written with its offset and reads back as the same instant.
+func exportTime(ct CustomTime) CustomTime {
+	if cfg.OutputLocation != nil && ct.Valid {
+		ct.Time, ct.zoned = ct.Time.In(cfg.OutputLocation), true
+	}
+	return ct
+}
+
+// outputTime converts t to -tz-output for display and export. Missing
+// timestamps stay zero.
+func outputTime(t time.Time) time.Time {
+	if cfg.OutputLocation == nil || t.IsZero() {
+		return t
+	}
+	return t.In(cfg.OutputLocation)
+}
+
+// nullTime maps the zero time, used for missing timestamps, to NULL.
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// queryURL builds a dataset URL from the given SoQL parameters. The
+// configured $where filter, -pickup-areas, -companies, the fare range and
+// any extra conditions are combined with AND.
+func queryURL(params url.Values, conds ...string) string {
+	if c := fareCondition(cfg.MinFare, cfg.MaxFare); c != "" {
+		conds = append([]string{c}, conds...)
+	}
+	if len(cfg.Companies) > 0 {
+		conds = append([]string{companiesCondition(cfg.Companies)}, conds...)
+	}
+	if len(cfg.PickupAreas) > 0 {
+		conds = append([]string{pickupAreasCondition(cfg.PickupAreas)}, conds...)
+	}
+	if !cfg.Since.IsZero() {
+		conds = append([]string{sourceField("trip_start_timestamp") + " >= " + soqlString(cfg.Since.Format(ctLayout))}, conds...)
+	}
+	if cfg.Where != "" {
+		conds = append([]string{cfg.Where}, conds...)
+	}
+	switch len(conds) {
+	case 0:
+	case 1:
+		params.Set("$where", conds[0])
+	default:
+		params.Set("$where", "("+strings.Join(conds, ") AND (")+")")
+	}
+	return cfg.DatasetURL + "?" + params.Encode()
+}
+
+// pageURL builds the URL of the next page, either by $offset or, with keyset
+// paging, as the trips that come after cur. Keyset pages are ordered by
+// trip_id, or with -start-from-date by start timestamp and then trip_id so
+// trips sharing a timestamp are neither skipped nor repeated.
+func pageURL(offset int, cur cursor) string {
+	if cfg.IDChunks != nil {
+		return idsURL(offset)
+	}
+	params := url.Values{"$limit": {"100"}}
+	if !cfg.Keyset {
+		params.Set("$offset", strconv.Itoa(offset))
+		return queryURL(params)
+	}
+	tripID, start := sourceField("trip_id"), sourceField("trip_start_timestamp")
+
+	if cfg.StartFromDate.IsZero() {
+		params.Set("$order", tripID)
+		if cur.TripID == "" {
+			return queryURL(params)
+		}
+		return queryURL(params, tripID+" > "+soqlString(cur.TripID))
+	}
+
+	params.Set("$order", start+", "+tripID)
+	conds := []string{start + " >= " + soqlString(cfg.StartFromDate.Format(ctLayout))}
+	if cur.TripID != "" {
+		ts, id := soqlString(cur.Time.Format(ctLayout)), soqlString(cur.TripID)
+		conds = append(conds, fmt.Sprintf("%[1]s > %[2]s OR (%[1]s = %[2]s AND %[3]s > %[4]s)", start, ts, tripID, id))
+	}
+	return queryURL(params, conds...)
+}
+
+// pickupAreasCondition selects trips starting in any of areas.
+func pickupAreasCondition(areas []int) string {
+	list := make([]string, len(areas))
+	for i, a := range areas {
+		list[i] = strconv.Itoa(a)
+	}
+	return sourceField("pickup_community_area") + " in (" + strings.Join(list, ", ") + ")"
+}
+
+// companiesCondition selects trips from any of companies.
+func companiesCondition(companies []string) string {
+	list := make([]string, len(companies))
+	for i, c := range companies {
+		list[i] = soqlString(c)
+	}
+	return sourceField("company") + " in (" + strings.Join(list, ", ") + ")"
+}
+
+// fareCondition bounds the fare by -min-fare and -max-fare, or is empty
+// when neither is set.
+func fareCondition(lo, hi *float64) string {
+	var bounds []string
+	if lo != nil {
+		bounds = append(bounds, sourceField("fare")+" >= "+strconv.FormatFloat(*lo, 'f', -1, 64))
+	}
+	if hi != nil {
+		bounds = append(bounds, sourceField("fare")+" <= "+strconv.FormatFloat(*hi, 'f', -1, 64))
+	}
+	return strings.Join(bounds, " AND ")
+}
+
+// filterCompanies applies -companies client-side, for trips read from a file
+// where there is no server to filter them.
+func filterCompanies(trips []data_fetched) []data_fetched {
+	kept := trips[:0]
+	for _, t := range trips {
+		for _, c := range cfg.Companies {
+			if t.Company == c {
+				kept = append(kept, t)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// soqlString quotes s as a SoQL string literal.
+func soqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// fetchTotalCount asks the API how many rows match the configured filter,
+// retrying network and server errors up to -count-attempts times and giving
+// up after -count-timeout.
+func fetchTotalCount(ctx context.Context) (int, error) {
+	if cfg.CountTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.CountTimeout)
+		defer cancel()
+	}
+	var total int
+	err := countRetry().Do(ctx, func() error {
+		var err error
+		total, err = queryTotalCount(ctx)
+		return err
+	})
+	u := maskToken(queryURL(url.Values{"$select": {"count(*) AS count"}}))
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil {
+		err = fmt.Errorf("count query: no answer within -count-timeout %s", cfg.CountTimeout)
+	}
+	if err != nil {
+		return 0, &FetchError{URL: u, Err: err}
+	}
+	return total, nil
+}
+
+// queryTotalCount makes one attempt at the count query.
+func queryTotalCount(ctx context.Context) (int, error) {
+	resp, err := apiGet(ctx, queryURL(url.Values{"$select": {"count(*) AS count"}}))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("count query: %w", &statusError{StatusCode: resp.StatusCode, Status: resp.Status})
+	}
+
+	var result []struct {
+		Count string `json:"count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	if len(result) == 0 {
+		return 0, fmt.Errorf("count query: empty response")
+	}
+	return strconv.Atoi(result[0].Count)
+}
+
+// progress tracks how far a run has got for the periodic summary. It is
+// also read by the admin server, so access goes through its methods.
+type progress struct {
+	mu     sync.Mutex
+	start  time.Time
+	rows   int
+	total  int       // 0 when the total count is unknown
+	offset int       // of the last page added
+	latest time.Time // latest trip start handed to the sinks, for -stats-db
+}
+
+// add counts the rows of the page fetched at offset.
+func (p *progress) add(rows, offset int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rows += rows
+	p.offset = offset
+}
+
+// addTrips notes the start times of trips handed to the sinks.
+func (p *progress) addTrips(trips []data_fetched) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, t := range trips {
+		if start := t.TripStartTimestamp.Time; start.After(p.latest) {
+			p.latest = start
+		}
+	}
+}
+
+func (p *progress) maxStart() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.latest
+}
+
+func (p *progress) lastOffset() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.offset
+}
+
+func (p *progress) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rows
+}
+
+func (p *progress) setTotal(total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total = total
+}
+
+func (p *progress) summary() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	elapsed := time.Since(p.start)
+	rate := float64(p.rows) / elapsed.Seconds()
+	if p.total == 0 {
+		return fmt.Sprintf("Progress: %d rows in %s (%.1f rows/s)", p.rows, elapsed.Round(time.Second), rate)
+	}
+	eta := "unknown"
+	if rate > 0 && p.total > p.rows {
+		eta = time.Duration(float64(p.total-p.rows) / rate * float64(time.Second)).Round(time.Second).String()
+	}
+	return fmt.Sprintf("Progress: %d/%d rows (%.1f%%) in %s (%.1f rows/s), ETA %s",
+		p.rows, p.total, 100*float64(p.rows)/float64(p.total), elapsed.Round(time.Second), rate, eta)
+}
+
+// idlePollDelay is how long to wait before fetching again after an empty page
+// with -max-idle-time.
+const idlePollDelay = 30 * time.Second
+
+// fetchAndPrinttaxitrips pages through the dataset until it is exhausted or
+// ctx is done, and returns the number of rows fetched. Fetched pages are
+// written under work, so the page in flight when ctx ends is still stored.
+func fetchAndPrinttaxitrips(ctx, work context.Context, db *sql.DB, replicas []*sql.DB, prog *progress) (rows int, err error) {
+	var summaryC <-chan time.Time
+	if cfg.SummaryInterval > 0 {
+		// A file has no dataset total to estimate against, and with
+		// -ids-file the total is at most the ids listed.
+		if cfg.IDChunks != nil {
+			prog.setTotal(idCount())
+		} else if !fromFile() {
+			if total, err := fetchTotalCount(ctx); err != nil {
+				log.Printf("Total unknown, progress is reported without a percentage or ETA: %v\n", err)
+			} else {
+				prog.setTotal(total)
+			}
+		}
+		ticker := time.NewTicker(cfg.SummaryInterval)
+		defer ticker.Stop()
+		summaryC = ticker.C
+	}
+
+	defer printTableFooter()
+
+	var diff diffSummary
+	if cfg.Diff {
+		defer func() { fmt.Fprintln(textOut, diff) }()
+	}
+
+	var cur cursor
+	var tracker *cursorTracker
+	if cfg.ResumeFile != "" {
+		saved, err := readResumeFile(cfg.ResumeFile)
+		if err != nil {
+			return 0, err
+		}
+		if saved != "" {
+			if cur, err = parseCursor(saved); err != nil {
+				return 0, fmt.Errorf("invalid cursor in %s: %w", cfg.ResumeFile, err)
+			}
+			log.Printf("Resuming after %s\n", cur)
+		}
+		tracker = newCursorTracker(cfg.ResumeFile)
+	}
+
+	if cfg.Complete != nil {
+		defer func() { log.Println(cfg.Complete) }()
+	}
+
+	var limiter *taxiLimiter
+	if cfg.MaxPerTaxi > 0 {
+		limiter = newTaxiLimiter(cfg.MaxPerTaxi)
+		defer func() { log.Printf("Dropped %d trips over -max-per-taxi %d\n", limiter.dropped, cfg.MaxPerTaxi) }()
+	}
+
+	var found idsFound
+	if cfg.IDChunks != nil {
+		found = idsFound{}
+		defer found.report()
+	}
+
+	sinks, err := openSinks(work, db, replicas, tracker)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { closeSinks(sinks, err) }()
+
+	var replay recordSource
+	switch {
+	case cfg.ReplayPath != "":
+		replay, err = openReplay(cfg.ReplayPath)
+	case cfg.ImportCSV != "":
+		replay, err = openCSVImport(cfg.ImportCSV)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if replay != nil {
+		defer replay.Close()
+	}
+
+	offset := 0
+	seq := 0
+	consecutiveErrors := 0
+	circuit := newBreaker(cfg.BreakerFailures, cfg.BreakerWindow, cfg.BreakerCooldown)
+	mem := newMemGuard(cfg.MaxMemory, cfg.MemoryInterval)
+	sinceCheckpoint := 0 // rows since the last -checkpoint-every
+	var sampleURL string // the one -server-sample request, until it is made
+	var sampleTotal int
+	sampled := false
+	var sampler *clientSampler // -server-sample fallback
+	if cfg.ServerSample > 0 {
+		if sampleURL, sampleTotal, err = planSample(ctx, cfg.ServerSample); err != nil {
+			return 0, err
+		}
+	}
+
+	var link string         // Link rel="next" URL of the last page, if any
+	var idleSince time.Time // start of the current run of empty pages, with -max-idle-time
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Context canceled. Exiting fetchAndPrinttaxitrips.")
+			return prog.count(), nil
+		case <-summaryC:
+			log.Println(prog.summary())
+		default:
+			mem.wait(ctx, func() int { return queuedBatches(sinks) })
+			var records []json.RawMessage
+			var source string
+			fetchedAt := time.Now()
+			if replay != nil {
+				source = replay.name()
+				if records, err = replay.page(100); err != nil {
+					return prog.count(), err
+				}
+				if len(records) == 0 {
+					log.Printf("Finished reading %s\n", replay.name())
+					return prog.count(), nil
+				}
+			} else {
+				if sampled {
+					log.Printf("Finished the -server-sample of %d rows\n", cfg.ServerSample)
+					return prog.count(), nil
+				}
+				if cfg.IDChunks != nil && idChunk(offset) == nil {
+					log.Printf("Fetched all %d -ids-file queries\n", len(cfg.IDChunks))
+					return prog.count(), nil
+				}
+				if cfg.MaxOffset > 0 && offset >= cfg.MaxOffset {
+					log.Printf("Stopping at offset %d (-max-offset %d)\n", offset, cfg.MaxOffset)
+					return prog.count(), nil
+				}
+				// Follow the server's Link header when it sent one, and
+				// compute the next page otherwise.
+				next := link
+				switch {
+				case sampleURL != "":
+					next = sampleURL
+				case next == "":
+					next = pageURL(offset, cur)
+				}
+				source = next
+				pageCtx := withLogAttrs(work, "offset", offset)
+				// Fetches use work, so one under way when ctx is done can
+				// finish, but the retries stop with ctx. -page-deadline
+				// covers every attempt at the page and the waits between
+				// them.
+				fetchCtx, cancelFetch := pageCtx, context.CancelFunc(func() {})
+				retryCtx, cancelRetry := withLogAttrs(ctx, "offset", offset), context.CancelFunc(func() {})
+				if cfg.PageDeadline > 0 {
+					deadline := time.Now().Add(cfg.PageDeadline)
+					fetchCtx, cancelFetch = context.WithDeadline(fetchCtx, deadline)
+					retryCtx, cancelRetry = context.WithDeadline(retryCtx, deadline)
+				}
+				// A server that cannot sample is not retried; the sample is
+				// taken client-side instead.
+				unsupported := func(err error) bool { return sampleURL != "" && sampleUnsupported(err) }
+				policy := fetchRetry()
+				// The failures of earlier pages skipped at their deadline
+				// count towards -max-consecutive-errors.
+				policy.MaxAttempts -= consecutiveErrors
+				policy.Retryable = func(err error) bool { return !unsupported(err) }
+				var nextPage string
+				err = policy.Do(retryCtx, func() error {
+					if wait := circuit.wait(); wait > 0 {
+						log.Printf("Circuit open; not fetching for %s\n", wait.Round(time.Second))
+						select {
+						case <-retryCtx.Done():
+							return retryCtx.Err()
+						case <-time.After(wait):
+						}
+					}
+					logger(pageCtx).Info("Fetching data", "url", next)
+					fetchStart := time.Now()
+					attemptCtx, pageSpan := otel.start(fetchCtx, "fetch page")
+					pageSpan.set("offset", offset)
+					pageSpan.set("url", next)
+					var err error
+					records, nextPage, err = fetchPage(attemptCtx, next)
+					pageSpan.set("rows", len(records))
+					pageSpan.finish(err)
+					stats.timing("fetch", time.Since(fetchStart))
+					if err != nil && !unsupported(err) {
+						stats.count("errors", 1)
+						circuit.failure()
+						consecutiveErrors++
+						logger(pageCtx).Warn("Fetch failed", "consecutive", consecutiveErrors, "err", err)
+					}
+					return err
+				})
+				pastDeadline := cfg.PageDeadline > 0 && retryCtx.Err() != nil
+				cancelFetch()
+				cancelRetry()
+				switch {
+				case err == nil:
+				case unsupported(err):
+					log.Printf("Server rejected the sample query (%v); sampling client-side instead\n", err)
+					sampleURL, sampler = "", newClientSampler(sampleTotal, cfg.ServerSample)
+					continue
+				case ctx.Err() != nil:
+					continue
+				case pastDeadline:
+					if err := skipPage(offset, cfg.PageDeadline); err != nil {
+						return prog.count(), err
+					}
+					offset += 100
+					link = ""
+					continue
+				default:
+					return prog.count(), fmt.Errorf("giving up after %d consecutive fetch errors at offset %d (%d rows fetched); last error: %w",
+						consecutiveErrors, offset, prog.count(), err)
+				}
+				link = nextPage
+				if sampleURL != "" {
+					// The sample is a single page; stop after loading it.
+					sampleURL, link, sampled = "", "", true
+				}
+				consecutiveErrors = 0
+				circuit.success()
+				stats.count("pages", 1)
+			}
+
+			if len(records) == 0 && cfg.IDChunks != nil {
+				// None of the chunk's ids exist; the next chunk may.
+				offset += 100
+				continue
+			}
+			if len(records) == 0 {
+				// An empty page ends the run, unless -max-idle-time asks to
+				// keep polling for new trips.
+				if cfg.MaxIdleTime <= 0 {
+					log.Println("No more data.")
+					return prog.count(), nil
+				}
+				if idleSince.IsZero() {
+					idleSince = time.Now()
+				}
+				if time.Since(idleSince) >= cfg.MaxIdleTime {
+					log.Printf("Idle exit: no new trips for %s (-max-idle-time %s)\n",
+						time.Since(idleSince).Round(time.Second), cfg.MaxIdleTime)
+					return prog.count(), nil
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(idlePollDelay):
+				}
+				continue
+			}
+			idleSince = time.Time{}
+
+			if len(cfg.FieldMap) > 0 {
+				remapRecords(records)
+			}
+			if err := checkSchemaDrift(records[0]); err != nil && cfg.Strict {
+				return prog.count(), err
+			}
+
+			if cfg.Loader != nil {
+				writeSinks(work, sinks, batch{records: records, offset: offset})
+				prog.add(len(records), offset)
+				stats.count("rows", len(records))
+				offset += 100
+				if sinceCheckpoint += len(records); cfg.CheckpointEvery > 0 && sinceCheckpoint >= cfg.CheckpointEvery {
+					if err := checkpoint(sinks, cur, prog.count()); err != nil {
+						return prog.count(), err
+					}
+					sinceCheckpoint = 0
+				}
+				continue
+			}
+
+			trips := make([]data_fetched, 0, len(records))
+			for i, record := range records {
+				var trip data_fetched
+				if err := json.Unmarshal(record, &trip); err != nil {
+					errorDump.add(offset, i, record, err)
+					perr := &ParseError{Offset: offset, Index: i, TripID: recordTripID(record), Err: err}
+					if cfg.Strict {
+						return prog.count(), perr
+					}
+					log.Printf("Skipping %v\n", perr)
+					skippedRecords.Add(1)
+					continue
+				}
+				trip.Normalize(cfg.Normalize)
+				if replay != nil {
+					undoOutputZone(&trip)
+				}
+				if cfg.StoreRaw {
+					trip.raw = record
+				}
+				trips = append(trips, trip)
+			}
+			if len(trips) == 0 {
+				if cfg.Keyset {
+					return prog.count(), fmt.Errorf("no record on the page after %s could be decoded; cannot advance the keyset cursor", cur)
+				}
+				offset += 100
+				continue
+			}
+
+			fetched, last := len(trips), trips[len(trips)-1]
+			if found != nil {
+				found.add(trips)
+			}
+			if sampler != nil {
+				trips = sampler.filter(trips)
+			}
+			if replay != nil && len(cfg.Companies) > 0 {
+				trips = filterCompanies(trips)
+			}
+			if cfg.Complete != nil {
+				trips = cfg.Complete.filter(trips)
+			}
+			if limiter != nil {
+				trips = limiter.filter(trips)
+			}
+
+			if len(trips) > 0 {
+				printTable(trips)
+				if cfg.Diff {
+					if err := diffTrips(work, db, trips, &diff); err != nil {
+						return prog.count(), err
+					}
+				}
+				writeSinks(work, sinks, batch{trips: trips, seq: seq, offset: offset, fetchedAt: fetchedAt, source: source})
+				prog.addTrips(trips)
+				seq++
+			}
+			prog.add(fetched, offset)
+			stats.count("rows", fetched)
+			offset += 100
+			cur = tripCursor(last)
+			if sinceCheckpoint += fetched; cfg.CheckpointEvery > 0 && sinceCheckpoint >= cfg.CheckpointEvery {
+				if err := checkpoint(sinks, cur, prog.count()); err != nil {
+					return prog.count(), err
+				}
+				sinceCheckpoint = 0
+			}
+		}
+	}
+}
+
+// skipPage gives up on the page at offset once -page-deadline has passed.
+// Its records are never seen, so they cannot be counted as skipped. Keyset
+// paging cannot step over a page without its last trip, and -strict refuses
+// to lose data, so both stop the run instead.
+func skipPage(offset int, deadline time.Duration) error {
+	if cfg.Strict || cfg.Keyset {
+		return fmt.Errorf("page at offset %d did not load within -page-deadline %s", offset, deadline)
+	}
+	log.Printf("WARNING: skipping the page at offset %d, which did not load within -page-deadline %s\n", offset, deadline)
+	stats.count("pages_skipped", 1)
+	return nil
+}
+
+// fetchPage requests one page of the dataset and splits it into raw records
+// with the Decoder for its format. It also returns the page's Link
+// rel="next" URL, if the server sent one.
+func fetchPage(ctx context.Context, url string) (records []json.RawMessage, next string, err error) {
+	defer func() {
+		if err != nil {
+			err = &FetchError{URL: maskToken(url), Err: err}
+		}
+	}()
+	resp, err := apiGet(ctx, url)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	logger(ctx).Info("Response received from the API", "status", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", &statusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	// Read one byte past the limit to tell a body that exactly fits from
+	// one that was cut off.
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, cfg.MaxResponseBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(body)) > cfg.MaxResponseBytes {
+		return nil, "", fmt.Errorf("response body exceeds -max-response-bytes (%d bytes)", cfg.MaxResponseBytes)
+	}
+
+	dec, err := selectDecoder(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, "", err
+	}
+	records, err = dec.Decode(body)
+	return records, nextLink(resp), err
+}
+
+// knownFields is the set of JSON keys mapped by data_fetched's struct tags.
+var knownFields = jsonFields(reflect.TypeOf(data_fetched{}))
+
+// reportedFields remembers unexpected keys that have already been logged.
+var reportedFields = map[string]bool{}
+
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// recordTripID returns the trip_id of a record, to name it in decode
+// errors, or "" when it cannot be read.
+func recordTripID(record json.RawMessage) string {
+	var r struct {
+		TripID string `json:"trip_id"`
+	}
+	if json.Unmarshal(record, &r) != nil {
+		return ""
+	}
+	return r.TripID
+}
+
+// checkSchemaDrift compares the keys of a raw record against knownFields.
+// Each unexpected key is logged once per run; the returned error lists every
+// unexpected key in the record so strict mode can abort on it.
+func checkSchemaDrift(record json.RawMessage) error {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(record, &keys); err != nil {
+		return err
+	}
+	var unknown []string
+	for key := range keys {
+		// Socrata system and computed-region columns start with ':' and are
+		// not part of the dataset schema.
+		if !knownFields[key] && !strings.HasPrefix(key, ":") {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		if !reportedFields[key] {
+			reportedFields[key] = true
+			log.Printf("Schema drift: API returned unknown field %q\n", key)
+		}
+	}
+	return fmt.Errorf("schema drift: unknown fields %s", strings.Join(unknown, ", "))
+}
+
+// printedRows and hiddenRows count the rows printTable has shown and
+// suppressed so far in this run.
+var printedRows, hiddenRows int
+
+// printTable renders trips until -max-print-rows rows have been printed in
+// total; rows beyond the cap are only counted. Nothing is printed with
+// -stdout-ndjson, which keeps stdout for the trips.
+func printTable(trips []data_fetched) {
+	if cfg.StdoutNDJSON {
+		return
+	}
+	if cfg.MaxPrintRows > 0 {
+		remaining := cfg.MaxPrintRows - printedRows
+		if remaining < 0 {
+			remaining = 0
+		}
+		if len(trips) > remaining {
+			hiddenRows += len(trips) - remaining
+			trips = trips[:remaining]
+		}
+	}
+	if len(trips) == 0 {
+		return
+	}
+	printedRows += len(trips)
+
+	table := newTable(textOut, tableHeader())
+	for _, trip := range trips {
+		table.Append(tableRow(trip))
+	}
+	table.Render()
+}
+
+// tableColumn is one printTable column: its header and how to render a trip.
+type tableColumn struct {
+	header string
+	value  func(t data_fetched) string
+}
+
+// tableColumns drives both the header and the rows of printTable, so the two
+// cannot drift apart.
+var tableColumns = []tableColumn{
+	{"Trip ID", func(t data_fetched) string { return t.TripID }},
+	{"Taxi ID", func(t data_fetched) string { return t.TaxiID }},
+	{"Start Time", func(t data_fetched) string { return outputTime(t.TripStartTimestamp.Time).Format(time.RFC3339) }},
+	{"End Time", func(t data_fetched) string { return outputTime(t.TripEndTimestamp.Time).Format(time.RFC3339) }},
+	{"Seconds", func(t data_fetched) string { return strconv.Itoa(t.TripSeconds.Int) }},
+	{"Miles", func(t data_fetched) string { return formatFloat("trip_miles", t.TripMiles.Float64, 2) }},
+	{"Fare", func(t data_fetched) string { return formatFloat("fare", t.Fare.Float64, 2) }},
+	{"Tips", func(t data_fetched) string { return formatFloat("tips", t.Tips.Float64, 2) }},
+	{"Total", func(t data_fetched) string { return formatFloat("trip_total", t.TripTotal.Float64, 2) }},
+}
+
+func tableHeader() []string {
+	header := make([]string, len(tableColumns))
+	for i, col := range tableColumns {
+		header[i] = col.header
+	}
+	return header
+}
+
+func tableRow(t data_fetched) []string {
+	row := make([]string, len(tableColumns))
+	for i, col := range tableColumns {
+		row[i] = col.value(t)
+	}
+	return row
+}
+
+// printTableFooter reports how many rows printTable left out.
+func printTableFooter() {
+	if hiddenRows > 0 {
+		fmt.Fprintf(textOut, "... and %d more\n", hiddenRows)
 	}
-	table.Render()
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// With -partition-range taxi_trips is created as a table partitioned by
+// month on trip_start_timestamp, with one partition per month of the range
+// and a default partition for trips outside it. Inserts go to taxi_trips and
+// Postgres routes each row to its partition.
+//
+// A partitioned table's primary key must include the partition column, so
+// the key becomes (trip_id, trip_start_timestamp) and trips without a start
+// timestamp cannot be stored. Upserts conflict on both columns: a trip whose
+// start timestamp changes at the source is stored again rather than updated.
+// An existing unpartitioned taxi_trips is left as it is.
+
+// monthRange is the first and last month, inclusive, of -partition-range.
+type monthRange struct {
+	from, to time.Time
+}
+
+const monthLayout = "2006-01"
+
+// parseMonthRange parses "YYYY-MM:YYYY-MM".
+func parseMonthRange(s string) (*monthRange, error) {
+	from, to, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, fmt.Errorf("%q: want FROM:TO months, e.g. 2023-01:2024-12", s)
+	}
+	var r monthRange
+	var err error
+	if r.from, err = time.Parse(monthLayout, strings.TrimSpace(from)); err != nil {
+		return nil, fmt.Errorf("%q: %w", from, err)
+	}
+	if r.to, err = time.Parse(monthLayout, strings.TrimSpace(to)); err != nil {
+		return nil, fmt.Errorf("%q: %w", to, err)
+	}
+	if r.to.Before(r.from) {
+		return nil, fmt.Errorf("%q: %s is before %s", s, to, from)
+	}
+	return &r, nil
+}
+
+func (r monthRange) String() string {
+	return r.from.Format(monthLayout) + ":" + r.to.Format(monthLayout)
+}
+
+// months returns the number of months in the range.
+func (r monthRange) months() int {
+	return (r.to.Year()-r.from.Year())*12 + int(r.to.Month()-r.from.Month()) + 1
+}
+
+func partitionName(month time.Time) string {
+	return month.Format("taxi_trips_y2006m01")
+}
+
+// partitionDDL creates the monthly partitions of taxi_trips that are
+// missing, followed by the default partition.
+func partitionDDL(r monthRange) string {
+	var b strings.Builder
+	for m := r.from; !m.After(r.to); m = m.AddDate(0, 1, 0) {
+		fmt.Fprintf(&b, "        CREATE TABLE IF NOT EXISTS %s PARTITION OF taxi_trips FOR VALUES FROM ('%s') TO ('%s');\n",
+			pq.QuoteIdentifier(partitionName(m)), m.Format(time.DateOnly), m.AddDate(0, 1, 0).Format(time.DateOnly))
+	}
+	b.WriteString("        CREATE TABLE IF NOT EXISTS taxi_trips_default PARTITION OF taxi_trips DEFAULT;\n")
+	return b.String()
+}
+
+// tripKey returns the columns that identify a stored trip.
+func tripKey() []string {
+	if cfg.PartitionRange != nil {
+		return []string{"trip_id", "trip_start_timestamp"}
+	}
+	return []string{"trip_id"}
+}
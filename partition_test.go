@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestPartitionDDL creates taxi_trips partitioned over a range that spans a
+// year end: one partition per month, each bounded by the first of its month
+// and of the next, then the default partition.
+func TestPartitionDDL(t *testing.T) {
+	r, err := parseMonthRange("2023-11:2024-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withConfig(t, func(c *config) {
+		c.PartitionRange = r
+		c.WithProvenance = false
+		c.StoreRaw = false
+		c.Reparse = false
+		c.WithComments = false
+		c.Shards = 0
+	})
+	want := []string{
+		`CREATE TABLE IF NOT EXISTS "taxi_trips_y2023m11" PARTITION OF taxi_trips FOR VALUES FROM ('2023-11-01') TO ('2023-12-01');`,
+		`CREATE TABLE IF NOT EXISTS "taxi_trips_y2023m12" PARTITION OF taxi_trips FOR VALUES FROM ('2023-12-01') TO ('2024-01-01');`,
+		`CREATE TABLE IF NOT EXISTS "taxi_trips_y2024m01" PARTITION OF taxi_trips FOR VALUES FROM ('2024-01-01') TO ('2024-02-01');`,
+		`CREATE TABLE IF NOT EXISTS "taxi_trips_y2024m02" PARTITION OF taxi_trips FOR VALUES FROM ('2024-02-01') TO ('2024-03-01');`,
+		`CREATE TABLE IF NOT EXISTS taxi_trips_default PARTITION OF taxi_trips DEFAULT;`,
+	}
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherFunc(func(_, ddl string) error {
+		if !strings.Contains(ddl, "PRIMARY KEY (trip_id, trip_start_timestamp)\n        ) PARTITION BY RANGE (trip_start_timestamp);") {
+			return fmt.Errorf("taxi_trips is not partitioned by trip_start_timestamp:\n%s", ddl)
+		}
+		var got []string
+		for _, line := range strings.Split(ddl, "\n") {
+			if line = strings.TrimSpace(line); strings.Contains(line, "PARTITION OF") {
+				got = append(got, line)
+			}
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			return fmt.Errorf("partitions:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+		return nil
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mock.ExpectExec("CREATE TABLE").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := createTable(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if n := r.months(); n != 4 {
+		t.Errorf("%s has %d months, want 4", r, n)
+	}
+}
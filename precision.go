@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// floatColumns are the float columns whose decimals -float-precision sets.
+var floatColumns = map[string]bool{
+	"trip_miles": true, "fare": true, "tips": true, "tolls": true, "extras": true, "trip_total": true,
+	"pickup_centroid_latitude": true, "pickup_centroid_longitude": true,
+	"dropoff_centroid_latitude": true, "dropoff_centroid_longitude": true,
+}
+
+// parseFloatPrecision parses a -float-precision list such as
+// "trip_miles=4,fare=2" into decimals per column.
+func parseFloatPrecision(s string) (map[string]int, error) {
+	precision := make(map[string]int)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		column, digits, ok := strings.Cut(item, "=")
+		column = strings.TrimSpace(column)
+		if !ok {
+			return nil, fmt.Errorf("%q is not column=decimals", item)
+		}
+		if !floatColumns[column] {
+			return nil, fmt.Errorf("%q is not a float column", column)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(digits))
+		if err != nil || n < 0 || n > 17 {
+			return nil, fmt.Errorf("%s: decimals must be 0 to 17, got %q", column, digits)
+		}
+		precision[column] = n
+	}
+	return precision, nil
+}
+
+// formatFloat renders v with the -float-precision decimals of column, or def
+// when none were given; a def of -1 keeps every significant digit.
+func formatFloat(column string, v float64, def int) string {
+	if n, ok := cfg.FloatPrecision[column]; ok {
+		def = n
+	}
+	return strconv.FormatFloat(v, 'f', def, 64)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// preflightCheck is one line of the -preflight table. run returns what was
+// found, or why the check failed.
+type preflightCheck struct {
+	name string
+	run  func(ctx context.Context) (string, error)
+}
+
+// errSkipped marks a check that could not run because one it depends on
+// failed. It is shown but not counted as another failure.
+var errSkipped = errors.New("skipped")
+
+// preflight checks the database, the API, the input file and every output
+// path the resolved settings would use, prints a table of the results and
+// reports whether all of them passed. Nothing is fetched beyond one row and
+// nothing is created or written except a temporary file per output
+// directory.
+func preflight(ctx context.Context, w io.Writer) bool {
+	table := newTable(w, []string{"Check", "Result", "Detail"})
+	table.SetAutoWrapText(false)
+	passed := true
+	for _, c := range preflightChecks() {
+		detail, err := c.run(ctx)
+		result := "ok"
+		switch {
+		case errors.Is(err, errSkipped):
+			result, detail = "skipped", err.Error()
+		case err != nil:
+			result, detail, passed = "FAIL", err.Error(), false
+		}
+		appendResult(table, w, []string{c.name, result, detail}, result == "FAIL")
+	}
+	table.Render()
+	return passed
+}
+
+func preflightChecks() []preflightCheck {
+	var checks []preflightCheck
+	if cfg.DB || cfg.Diff || cfg.StatsDB {
+		var db *sql.DB
+		checks = append(checks,
+			preflightCheck{"postgres", func(ctx context.Context) (string, error) {
+				conn, err := connString()
+				if err != nil {
+					return "", err
+				}
+				d, err := pingDB(ctx, conn)
+				if err != nil {
+					return "", err
+				}
+				db = d
+				return "connected to " + redactDSN(conn), nil
+			}},
+			preflightCheck{"schema", func(ctx context.Context) (string, error) {
+				if db == nil {
+					return "", fmt.Errorf("%w: no database connection", errSkipped)
+				}
+				defer db.Close()
+				return checkTables(ctx, db, loadTables())
+			}},
+		)
+		for i, dsn := range cfg.ReplicaDSNs {
+			checks = append(checks, preflightCheck{fmt.Sprintf("replica %d", i+1), func(ctx context.Context) (string, error) {
+				conn, err := dsnConnString(dsn)
+				if err != nil {
+					return "", err
+				}
+				db, err := pingDB(ctx, conn)
+				if err != nil {
+					return "", err
+				}
+				db.Close()
+				return "connected to " + redactDSN(conn), nil
+			}})
+		}
+	}
+
+	switch {
+	case cfg.ReplayPath != "":
+		checks = append(checks, readableCheck("-replay", cfg.ReplayPath))
+	case cfg.ImportCSV != "":
+		checks = append(checks, readableCheck("-import-csv", cfg.ImportCSV))
+	default:
+		var status int // of the API's answer, 0 when there was none
+		checks = append(checks, preflightCheck{"api", func(ctx context.Context) (string, error) {
+			records, _, err := fetchPage(ctx, queryURL(url.Values{"$limit": {"1"}}))
+			var se *statusError
+			if errors.As(err, &se) {
+				status = se.StatusCode
+			}
+			if err != nil {
+				return "", err
+			}
+			status = http.StatusOK
+			return fmt.Sprintf("%s answered with %d record(s) of JSON", cfg.DatasetURL, len(records)), nil
+		}})
+		if cfg.AppToken != "" {
+			checks = append(checks, preflightCheck{"app token", func(ctx context.Context) (string, error) {
+				switch status {
+				case http.StatusUnauthorized, http.StatusForbidden:
+					return "", fmt.Errorf("rejected by the API (%s)", http.StatusText(status))
+				case 0:
+					return "", fmt.Errorf("%w: no answer from the API", errSkipped)
+				}
+				return "accepted", nil
+			}})
+		}
+	}
+
+	outputs := []struct{ flag, path string }{
+		{"-csv", cfg.CSVPath},
+		{"-jsonl", cfg.JSONLPath},
+		{"-geojson", cfg.GeoJSONPath},
+		{"-resume-file", cfg.ResumeFile},
+		{"-inserted-ids", cfg.InsertedIDsPath},
+		{"-error-dump", cfg.ErrorDumpPath},
+		{"-run-log", cfg.RunLog},
+	}
+	for _, o := range outputs {
+		if o.path != "" {
+			checks = append(checks, writableCheck(o.flag, o.path))
+		}
+	}
+	return checks
+}
+
+// pingDB opens and pings the database at conn.
+func pingDB(ctx context.Context, conn string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", conn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, describeConnError(err)
+	}
+	return db, nil
+}
+
+// loadTables returns the tables a load writes to.
+func loadTables() []string {
+	switch l := cfg.Loader.(type) {
+	case *fieldsSpec:
+		return []string{l.Table}
+	case tnpSchema:
+		return []string{"tnp_trips"}
+	}
+	return []string{"taxi_trips", "taxi_trips_anomalies"}
+}
+
+// checkTables fails when any of tables is missing. A run would create them,
+// so a missing one usually means the wrong database or search_path.
+func checkTables(ctx context.Context, db *sql.DB, tables []string) (string, error) {
+	var missing []string
+	for _, t := range tables {
+		var found sql.NullString
+		if err := db.QueryRowContext(ctx, "SELECT to_regclass($1)::text", t).Scan(&found); err != nil {
+			return "", err
+		}
+		if !found.Valid {
+			missing = append(missing, t)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing %s (a run creates them; check -db-name and the user's search_path)", strings.Join(missing, ", "))
+	}
+	return "found " + strings.Join(tables, ", "), nil
+}
+
+func readableCheck(flag, path string) preflightCheck {
+	return preflightCheck{flag, func(context.Context) (string, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		f.Close()
+		return path + " is readable", nil
+	}}
+}
+
+// writableCheck creates and removes a temporary file next to path, so the
+// path itself is left untouched.
+func writableCheck(flag, path string) preflightCheck {
+	return preflightCheck{flag, func(context.Context) (string, error) {
+		dir := filepath.Dir(path)
+		f, err := os.CreateTemp(dir, ".preflight*")
+		if err != nil {
+			return "", fmt.Errorf("cannot write to %s: %w", dir, err)
+		}
+		f.Close()
+		os.Remove(f.Name())
+		return dir + " is writable", nil
+	}}
+}
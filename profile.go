@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// startProfiling starts a CPU profile when -cpuprofile is set and returns a
+// func that stops it and writes the -memprofile heap profile. main defers
+// the returned func so both are flushed when the run ends, including when
+// the timeout cancels it. View the results with
+//
+//	go tool pprof -http=:8080 <binary> cpu.prof
+//	go tool pprof -sample_index=alloc_space <binary> mem.prof
+func startProfiling() func() {
+	var cpu *os.File
+	if cfg.CPUProfile != "" {
+		f, err := os.Create(cfg.CPUProfile)
+		if err != nil {
+			log.Fatalf("creating CPU profile: %v", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			log.Fatalf("starting CPU profile: %v", err)
+		}
+		cpu = f
+	}
+
+	return func() {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			cpu.Close()
+			log.Printf("Wrote CPU profile to %s\n", cfg.CPUProfile)
+		}
+		if cfg.MemProfile != "" {
+			f, err := os.Create(cfg.MemProfile)
+			if err != nil {
+				log.Printf("creating memory profile: %v\n", err)
+				return
+			}
+			defer f.Close()
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				log.Printf("writing memory profile: %v\n", err)
+				return
+			}
+			log.Printf("Wrote memory profile to %s\n", cfg.MemProfile)
+		}
+	}
+}
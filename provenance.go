@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// addProvenanceColumns adds the provenance columns to tables created
+// without them.
+func addProvenanceColumns(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+        ALTER TABLE taxi_trips ADD COLUMN IF NOT EXISTS fetched_at TIMESTAMP;
+        ALTER TABLE taxi_trips ADD COLUMN IF NOT EXISTS source_url TEXT;
+        ALTER TABLE taxi_trips_anomalies ADD COLUMN IF NOT EXISTS fetched_at TIMESTAMP;
+        ALTER TABLE taxi_trips_anomalies ADD COLUMN IF NOT EXISTS source_url TEXT;
+    `)
+	return err
+}
+
+// provenanceText renders the provenance of b for the file exports, with
+// fetched_at in UTC, or in -tz-output with its offset.
+func provenanceText(b batch) []string {
+	fetched := exportTime(CustomTime{Time: b.fetchedAt.UTC(), Valid: true})
+	return []string{fetched.format(), b.source}
+}
+
+// provenanceTrip is a trip with its provenance, as written to -jsonl.
+type provenanceTrip struct {
+	data_fetched
+	FetchedAt string `json:"fetched_at"`
+	SourceURL string `json:"source_url"`
+}
+
+func withProvenance(t data_fetched, b batch) provenanceTrip {
+	p := provenanceText(b)
+	return provenanceTrip{data_fetched: t, FetchedAt: p[0], SourceURL: p[1]}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+// pageWhere returns the $where of the first page's URL under the current
+// configuration.
+func pageWhere(t *testing.T) string {
+	t.Helper()
+	u, err := url.Parse(pageURL(0, cursor{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query().Get("$where")
+}
+
+func TestPickupAreasWhere(t *testing.T) {
+	tests := []struct {
+		name  string
+		areas []int
+		where string
+		want  string
+	}{
+		{"one area", []int{8}, "", "pickup_community_area in (8)"},
+		{"several areas", []int{8, 32, 33}, "", "pickup_community_area in (8, 32, 33)"},
+		{"with -where", []int{8, 32}, "trip_miles > 1", "(trip_miles > 1) AND (pickup_community_area in (8, 32))"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *config) {
+				c.PickupAreas = tt.areas
+				c.Where = tt.where
+				c.Keyset = false
+			})
+			if got := pageWhere(t); got != tt.want {
+				t.Errorf("$where = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFareWhere(t *testing.T) {
+	lo, hi := 5.0, 20.5
+	tests := []struct {
+		name   string
+		lo, hi *float64
+		want   string
+	}{
+		{"min only", &lo, nil, "fare >= 5"},
+		{"max only", nil, &hi, "fare <= 20.5"},
+		{"both", &lo, &hi, "fare >= 5 AND fare <= 20.5"},
+		{"neither", nil, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *config) {
+				c.MinFare, c.MaxFare = tt.lo, tt.hi
+				c.Keyset = false
+			})
+			if got := pageWhere(t); got != tt.want {
+				t.Errorf("$where = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Quotes in values are doubled wherever they reach a $where.
+func TestSOQLStringEscapes(t *testing.T) {
+	tests := map[string]string{
+		"O'Hare":       "'O''Hare'",
+		"'":            "''''",
+		"it''s":        "'it''''s'",
+		"plain":        "'plain'",
+		"":             "''",
+		"a' OR '1'='1": "'a'' OR ''1''=''1'",
+	}
+	for in, want := range tests {
+		if got := soqlString(in); got != want {
+			t.Errorf("soqlString(%q) = %s, want %s", in, got, want)
+		}
+	}
+
+	if got, want := idsCondition([]string{"O'Hare", "b"}), "trip_id in ('O''Hare', 'b')"; got != want {
+		t.Errorf("idsCondition = %q, want %q", got, want)
+	}
+	withConfig(t, func(c *config) {
+		c.Companies = []string{"O'Hare Cab", "Flash Cab"}
+		c.Keyset = true
+	})
+	u, err := url.Parse(pageURL(0, cursor{TripID: "x'y"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "(company in ('O''Hare Cab', 'Flash Cab')) AND (trip_id > 'x''y')"
+	if got := u.Query().Get("$where"); got != want {
+		t.Errorf("$where = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// rawColumn holds each trip's source record as received, written with
+// -store-raw so derived columns can be recomputed later with -reparse.
+const rawColumn = "raw_json"
+
+// addRawColumns adds raw_json to tables created without it.
+func addRawColumns(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+        ALTER TABLE taxi_trips ADD COLUMN IF NOT EXISTS raw_json JSONB;
+        ALTER TABLE taxi_trips_anomalies ADD COLUMN IF NOT EXISTS raw_json JSONB;
+    `)
+	return err
+}
+
+// rawValue returns the raw record of t, or NULL when it has none.
+func rawValue(t data_fetched) any {
+	if len(t.raw) == 0 {
+		return nil
+	}
+	return string(t.raw)
+}
+
+// reparseBatch is the number of rows read and updated per transaction by
+// -reparse.
+const reparseBatch = 1000
+
+const reparseSelectSQL = `SELECT trip_id, raw_json FROM taxi_trips
+        WHERE raw_json IS NOT NULL AND trip_id > $1 ORDER BY trip_id LIMIT $2`
+
+// reparseSpecs are the columns -reparse recomputes.
+var reparseSpecs = columnSpecs(columnDerived)
+
+// reparseUpdateSQL sets the reparseSpecs columns, from $2 on, of the row
+// whose trip_id is $1.
+var reparseUpdateSQL = updateSQL("taxi_trips", columnNames(reparseSpecs), "trip_id")
+
+// updateSQL builds an UPDATE of columns, from $2 on, in the row of table
+// whose key is $1. All names are quoted.
+func updateSQL(table string, columns []string, key string) string {
+	sets := make([]string, len(columns))
+	for i, col := range columns {
+		sets[i] = fmt.Sprintf("%s = $%d", pq.QuoteIdentifier(col), i+2)
+	}
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s = $1", pq.QuoteIdentifier(table),
+		strings.Join(sets, ", "), pq.QuoteIdentifier(key))
+}
+
+// reparse recomputes the derived columns of every row stored with
+// raw_json, decoding and normalizing the raw record the same way a fetch
+// does, and updates them in place. It returns the number of rows updated.
+func reparse(ctx context.Context, db *sql.DB) (int, error) {
+	updated, after := 0, ""
+	for {
+		ids, raws, err := reparsePage(ctx, db, after)
+		if err != nil {
+			return updated, err
+		}
+		if len(ids) == 0 {
+			return updated, nil
+		}
+		if err := reparseRows(ctx, db, ids, raws); err != nil {
+			return updated, err
+		}
+		updated += len(ids)
+		after = ids[len(ids)-1]
+		log.Printf("Reparsed %d rows\n", updated)
+	}
+}
+
+func reparsePage(ctx context.Context, db *sql.DB, after string) ([]string, [][]byte, error) {
+	rows, err := db.QueryContext(ctx, reparseSelectSQL, after, reparseBatch)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	var raws [][]byte
+	for rows.Next() {
+		var id string
+		var raw []byte
+		if err := rows.Scan(&id, &raw); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		raws = append(raws, raw)
+	}
+	return ids, raws, rows.Err()
+}
+
+// reparseRows updates one page of rows in a single transaction.
+func reparseRows(ctx context.Context, db *sql.DB, ids []string, raws [][]byte) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, reparseUpdateSQL)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i, id := range ids {
+		var trip data_fetched
+		if err := json.Unmarshal(raws[i], &trip); err != nil {
+			return fmt.Errorf("trip %s: %w", id, err)
+		}
+		trip.Normalize(cfg.Normalize)
+		values := tripValuesByName(trip)
+		args := []any{id}
+		for _, c := range reparseSpecs {
+			args = append(args, values[c.name])
+		}
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return fmt.Errorf("update trip %s: %w", id, err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestReparseUpdatesDerivedColumns(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	raws := map[string]string{
+		"t1": `{"trip_id":"t1","company":"Flash Cab","fare":"12.25",` +
+			`"pickup_centroid_latitude":"41.8781","pickup_centroid_longitude":"-87.6298"}`,
+		"t2": `{"trip_id":"t2","company":"Taxi Affiliation Services","fare":"8.00"}`, // no pickup location
+	}
+	rowHashOf := func(id string) string {
+		var trip data_fetched
+		if err := json.Unmarshal([]byte(raws[id]), &trip); err != nil {
+			t.Fatal(err)
+		}
+		trip.Normalize(cfg.Normalize)
+		return rowHash(sourceValues(trip))
+	}
+
+	mock.ExpectQuery(reparseSelectSQL).WithArgs("", reparseBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"trip_id", "raw_json"}).
+			AddRow("t1", []byte(raws["t1"])).
+			AddRow("t2", []byte(raws["t2"])))
+	mock.ExpectBegin()
+	update := mock.ExpectPrepare(`UPDATE "taxi_trips" SET "pickup_geohash" = $2, "row_hash" = $3 WHERE "trip_id" = $1`)
+	update.ExpectExec().WithArgs("t1", "dp3wjzt", rowHashOf("t1")).WillReturnResult(sqlmock.NewResult(0, 1))
+	update.ExpectExec().WithArgs("t2", nil, rowHashOf("t2")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(reparseSelectSQL).WithArgs("t2", reparseBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"trip_id", "raw_json"}))
+
+	updated, err := reparse(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != 2 {
+		t.Errorf("reparse updated %d rows, want 2", updated)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
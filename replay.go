@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// recordSource supplies pages of raw records from a file in place of the
+// API.
+type recordSource interface {
+	// page returns up to n records, or none once the source is exhausted.
+	page(n int) ([]json.RawMessage, error)
+	name() string
+	Close() error
+}
+
+// replayReader reads trips saved by -jsonl back as pages of raw records, so
+// -replay runs them through the same pipeline as a page from the API.
+// Timestamps exported with -tz-output carry their offset, and undoOutputZone
+// puts them back in the -tz zone.
+type replayReader struct {
+	path    string
+	f       *os.File
+	scanner *bufio.Scanner
+	line    int
+}
+
+func openReplay(path string) (*replayReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	return &replayReader{path: path, f: f, scanner: scanner}, nil
+}
+
+// page returns up to n records, or none once the file is exhausted. Blank
+// lines are ignored.
+func (r *replayReader) page(n int) ([]json.RawMessage, error) {
+	var records []json.RawMessage
+	for len(records) < n && r.scanner.Scan() {
+		r.line++
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		// The scanner reuses its buffer, so keep a copy.
+		records = append(records, append(json.RawMessage(nil), line...))
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s:%d: %w", r.path, r.line+1, err)
+	}
+	return records, nil
+}
+
+func (r *replayReader) name() string { return r.path }
+
+func (r *replayReader) Close() error {
+	return r.f.Close()
+}
+
+// undoOutputZone moves the timestamps of a trip read back from an export
+// that were written with an offset, by -tz-output, into the -tz zone, or UTC
+// without -tz. They are then stored with the wall clock a load of the trip
+// from the API has, whatever -tz-output the export was made with.
+func undoOutputZone(t *data_fetched) {
+	loc := cfg.Normalize.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	for _, ct := range []*CustomTime{&t.TripStartTimestamp, &t.TripEndTimestamp} {
+		if ct.zoned {
+			ct.Time, ct.zoned = ct.Time.In(loc), false
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestReplayInserts replays a file written by -jsonl into the database:
+// its trips are inserted as one page, in file order, as if fetched.
+func TestReplayInserts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trips.jsonl")
+	second := strings.Replace(sampleRecord, `"0d5f2a0e9c1b"`, `"1e6a3b1fad2c"`, 1)
+	if err := os.WriteFile(path, []byte(sampleRecord+"\n\n"+second+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	withConfig(t, func(c *config) {
+		c.ReplayPath = path
+		c.DB = true
+		c.Diff = false
+		c.DBAttempts = 1
+		c.SummaryInterval = 0
+	})
+	runFailure = failure{}
+	t.Cleanup(func() { runFailure = failure{} })
+
+	db, mock := newMock(t)
+	mock.ExpectBegin()
+	mock.ExpectPrepare(anomalySQL)
+	stmt := mock.ExpectPrepare(wantInsertSQL)
+	stmt.ExpectExec().WithArgs(sampleArgs...).WillReturnResult(sqlmock.NewResult(0, 1))
+	args := append([]driver.Value{"1e6a3b1fad2c"}, sampleArgs[1:24]...)
+	args = append(args, rowHash(sourceValues(sampleTrip(t, "1e6a3b1fad2c"))))
+	stmt.ExpectExec().WithArgs(args...).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	ctx := context.Background()
+	rows, err := fetchAndPrinttaxitrips(ctx, ctx, db, nil, &progress{start: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 2 {
+		t.Errorf("replayed %d rows, want 2", rows)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// dsnFlag collects repeated -dsn values. The first is the primary database;
+// each later one is a replica that every batch is also written to.
+type dsnFlag struct{}
+
+func (dsnFlag) String() string { return cfg.DSN }
+
+func (dsnFlag) Set(v string) error {
+	if cfg.DSN == "" {
+		cfg.DSN = v
+	} else {
+		cfg.ReplicaDSNs = append(cfg.ReplicaDSNs, v)
+	}
+	return nil
+}
+
+// openReplicas connects to every replica -dsn and creates its tables. With
+// -replica-errors warn a replica that cannot be reached is left out of the
+// run instead of ending it. On an error the replicas already opened are
+// closed.
+func openReplicas(ctx context.Context) (dbs []*sql.DB, err error) {
+	defer func() {
+		if err != nil {
+			for _, db := range dbs {
+				db.Close()
+			}
+			dbs = nil
+		}
+	}()
+	for _, dsn := range cfg.ReplicaDSNs {
+		conn, err := dsnConnString(dsn)
+		if err != nil {
+			return dbs, err
+		}
+		log.Printf("Connecting to replica %s\n", redactDSN(conn))
+		db, err := sql.Open("postgres", conn)
+		if err == nil {
+			err = db.PingContext(ctx)
+		}
+		if err != nil {
+			err = describeConnError(err)
+			if db != nil {
+				db.Close()
+			}
+			if cfg.ReplicaErrors != "warn" {
+				return dbs, err
+			}
+			log.Printf("Skipping replica %s: %v\n", redactDSN(conn), err)
+			continue
+		}
+		dbs = append(dbs, db)
+		if cfg.Loader != nil {
+			if err = cfg.Loader.createTable(ctx, db); err != nil {
+				err = fmt.Errorf("creating tables: %w", err)
+			}
+		} else {
+			err = createTable(ctx, db)
+		}
+		if err != nil {
+			return dbs, err
+		}
+	}
+	return dbs, nil
+}
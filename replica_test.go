@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectInsert expects the sample trip to be inserted, or the transaction
+// to fail to begin with err.
+func expectInsert(mock sqlmock.Sqlmock, err error) {
+	if err != nil {
+		mock.ExpectBegin().WillReturnError(err)
+		return
+	}
+	mock.ExpectBegin()
+	mock.ExpectPrepare(anomalySQL)
+	mock.ExpectPrepare(wantInsertSQL).ExpectExec().WithArgs(sampleArgs...).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+// TestReplicaSinks writes a batch to a primary and a replica database, each
+// a sqlmock connection, with the replica failing or not under either
+// -replica-errors.
+func TestReplicaSinks(t *testing.T) {
+	down := errors.New("replica down")
+	tests := []struct {
+		name          string
+		replicaErrors string
+		replicaErr    error
+		wantFailure   bool
+	}{
+		{"both written", "fatal", nil, false},
+		{"replica fails, fatal", "fatal", down, true},
+		{"replica fails, warn", "warn", down, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runFailure = failure{}
+			t.Cleanup(func() { runFailure = failure{} })
+			withConfig(t, func(c *config) {
+				c.DB = true
+				c.Diff = false
+				c.DBAttempts = 1
+				c.ReplicaErrors = tt.replicaErrors
+			})
+			primary, primaryMock := newMock(t)
+			replica, replicaMock := newMock(t)
+			expectInsert(primaryMock, nil)
+			expectInsert(replicaMock, tt.replicaErr)
+
+			inserted := insertedRows.Load()
+			ctx := context.Background()
+			sinks, err := openSinks(ctx, primary, []*sql.DB{replica}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sinks) != 2 {
+				t.Fatalf("opened %d sinks, want the primary and the replica", len(sinks))
+			}
+			writeSinks(ctx, sinks, batch{trips: []data_fetched{sampleTrip(t, "")}})
+			closeSinks(sinks, nil)
+
+			for name, mock := range map[string]sqlmock.Sqlmock{"primary": primaryMock, "replica": replicaMock} {
+				if err := mock.ExpectationsWereMet(); err != nil {
+					t.Errorf("%s: %v", name, err)
+				}
+			}
+			if got := insertedRows.Load() - inserted; got != 1 {
+				t.Errorf("counted %d inserted rows, want the primary's 1", got)
+			}
+			err = runFailure.get()
+			if tt.wantFailure != (err != nil) {
+				t.Errorf("run failure = %v, want failure %v", err, tt.wantFailure)
+			}
+			if err != nil && !errors.Is(err, down) {
+				t.Errorf("run failure = %v, want the replica's error", err)
+			}
+		})
+	}
+}
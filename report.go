@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// aggregator accumulates one report over the batches of a run. Only the
+// aggregate state is kept, so reports work over any number of rows.
+type aggregator interface {
+	add(trips []data_fetched)
+	render(w io.Writer)
+}
+
+// dbAggregator is an aggregator that can also be filled by querying
+// taxi_trips, for -report-source db.
+type dbAggregator interface {
+	aggregator
+	query(ctx context.Context, db *sql.DB, where string, args []any) error
+}
+
+// reports maps -report names to their aggregators.
+var reports = map[string]func() aggregator{
+	"hourly": func() aggregator { return &hourlyReport{split: cfg.SplitWeekend} },
+	"company": func() aggregator {
+		return newGroupReport("Company", "company", func(t data_fetched) string { return t.Company })
+	},
+	"taxi": func() aggregator {
+		return newGroupReport("Taxi", "taxi_id", func(t data_fetched) string { return t.TaxiID })
+	},
+	// A missing area is stored as 0, which no community area is numbered.
+	"area": func() aggregator {
+		return newGroupReport("Pickup Area", "NULLIF(pickup_community_area, 0)::text", func(t data_fetched) string {
+			if t.PickupCommunityArea.Int == 0 {
+				return ""
+			}
+			return strconv.Itoa(t.PickupCommunityArea.Int)
+		})
+	},
+	"payment": func() aggregator {
+		return newGroupReport("Payment Type", "payment_type", func(t data_fetched) string { return t.PaymentType })
+	},
+}
+
+// ReportSink feeds every batch to an aggregator and prints the report when
+// the run ends.
+type ReportSink struct {
+	name string
+	agg  aggregator
+}
+
+func (s *ReportSink) Name() string { return s.name + " report" }
+
+func (s *ReportSink) Write(ctx context.Context, b batch) error {
+	s.agg.add(b.trips)
+	return nil
+}
+
+func (s *ReportSink) Close() error {
+	s.agg.render(textOut)
+	return nil
+}
+
+// hourlyReport counts trips and revenue by the hour of day they started,
+// optionally split into weekdays and weekends.
+type hourlyReport struct {
+	split    bool
+	counts   [2][24]int // [weekend][hour]
+	revenue  [2][24]float64
+	excluded int // trips without a start timestamp
+}
+
+func (r *hourlyReport) add(trips []data_fetched) {
+	for _, t := range trips {
+		start := outputTime(t.TripStartTimestamp.Time)
+		if start.IsZero() {
+			r.excluded++
+			continue
+		}
+		weekend := 0
+		if r.split && (start.Weekday() == time.Saturday || start.Weekday() == time.Sunday) {
+			weekend = 1
+		}
+		r.counts[weekend][start.Hour()]++
+		r.revenue[weekend][start.Hour()] += t.TripTotal.Float64
+	}
+}
+
+// query groups taxi_trips by start hour in SQL. Timestamps are stored as
+// wall clock in the -tz zone, so with -tz-output they are converted from
+// that zone first.
+func (r *hourlyReport) query(ctx context.Context, db *sql.DB, where string, args []any) error {
+	start, groupArgs := "trip_start_timestamp", args
+	if cfg.OutputLocation != nil {
+		source := "UTC"
+		if cfg.Normalize.Location != nil {
+			source = cfg.Normalize.Location.String()
+		}
+		groupArgs = append(append([]any{}, args...), source, cfg.OutputLocation.String())
+		start = fmt.Sprintf("timezone($%d, timezone($%d, trip_start_timestamp))", len(groupArgs), len(groupArgs)-1)
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+        SELECT EXTRACT(ISODOW FROM %[1]s) >= 6, EXTRACT(HOUR FROM %[1]s)::int, count(*), COALESCE(sum(trip_total), 0)
+        FROM taxi_trips WHERE %[2]s AND trip_start_timestamp IS NOT NULL GROUP BY 1, 2`, start, where), groupArgs...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var isWeekend bool
+		var hour, count int
+		var revenue float64
+		if err := rows.Scan(&isWeekend, &hour, &count, &revenue); err != nil {
+			return err
+		}
+		weekend := 0
+		if r.split && isWeekend {
+			weekend = 1
+		}
+		r.counts[weekend][hour] += count
+		r.revenue[weekend][hour] += revenue
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return db.QueryRowContext(ctx, "SELECT count(*) FROM taxi_trips WHERE "+where+" AND trip_start_timestamp IS NULL",
+		args...).Scan(&r.excluded)
+}
+
+func (r *hourlyReport) render(w io.Writer) {
+	header := []string{"Hour", "Trips", "Revenue"}
+	if r.split {
+		header = []string{"Hour", "Weekday Trips", "Weekday Revenue", "Weekend Trips", "Weekend Revenue"}
+	}
+	table := newTable(w, header)
+	for hour := 0; hour < 24; hour++ {
+		row := []string{fmt.Sprintf("%02d:00", hour)}
+		for weekend := 0; weekend <= 1; weekend++ {
+			if weekend == 1 && !r.split {
+				break
+			}
+			row = append(row,
+				strconv.Itoa(r.counts[weekend][hour]),
+				strconv.FormatFloat(r.revenue[weekend][hour], 'f', 2, 64))
+		}
+		table.Append(row)
+	}
+	table.Render()
+	if r.excluded > 0 {
+		fmt.Fprintf(w, "%d trips without a start timestamp were excluded\n", r.excluded)
+	}
+}
+
+// groupReport totals trips, miles, revenue and tips per value of one
+// column, such as company. It keeps one entry per distinct value, which is
+// small next to the trips even for every taxi in the dataset.
+type groupReport struct {
+	title  string
+	keySQL string // the key as SQL text over taxi_trips, for query
+	key    func(data_fetched) string
+	groups map[string]*groupTotals
+}
+
+type groupTotals struct {
+	trips                int
+	miles, revenue, tips float64
+}
+
+func newGroupReport(title, keySQL string, key func(data_fetched) string) *groupReport {
+	return &groupReport{title: title, keySQL: keySQL, key: key, groups: make(map[string]*groupTotals)}
+}
+
+func (r *groupReport) group(key string) *groupTotals {
+	g, ok := r.groups[key]
+	if !ok {
+		g = &groupTotals{}
+		r.groups[key] = g
+	}
+	return g
+}
+
+func (r *groupReport) add(trips []data_fetched) {
+	for _, t := range trips {
+		g := r.group(r.key(t))
+		g.trips++
+		g.miles += t.TripMiles.Float64
+		g.revenue += t.TripTotal.Float64
+		g.tips += t.Tips.Float64
+	}
+}
+
+func (r *groupReport) query(ctx context.Context, db *sql.DB, where string, args []any) error {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+        SELECT COALESCE(%[1]s, ''), count(*), COALESCE(sum(trip_miles), 0), COALESCE(sum(trip_total), 0), COALESCE(sum(tips), 0)
+        FROM taxi_trips WHERE %[2]s GROUP BY 1`, r.keySQL, where), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var t groupTotals
+		if err := rows.Scan(&key, &t.trips, &t.miles, &t.revenue, &t.tips); err != nil {
+			return err
+		}
+		g := r.group(key)
+		g.trips += t.trips
+		g.miles += t.miles
+		g.revenue += t.revenue
+		g.tips += t.tips
+	}
+	return rows.Err()
+}
+
+// render lists the groups by number of trips, most first.
+func (r *groupReport) render(w io.Writer) {
+	keys := make([]string, 0, len(r.groups))
+	for k := range r.groups {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := r.groups[keys[i]], r.groups[keys[j]]
+		if a.trips != b.trips {
+			return a.trips > b.trips
+		}
+		return keys[i] < keys[j]
+	})
+
+	table := newTable(w, []string{r.title, "Trips", "Miles", "Revenue", "Tips", "Avg Total"})
+	money := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+	for _, k := range keys {
+		g := r.groups[k]
+		if k == "" {
+			k = "(none)"
+		}
+		table.Append([]string{k, strconv.Itoa(g.trips), money(g.miles), money(g.revenue), money(g.tips),
+			money(g.revenue / float64(g.trips))})
+	}
+	table.Render()
+}
+
+// reportWhere translates the fetch filters to SQL over taxi_trips for
+// -report-source db. parseFlags refuses -where, which is SoQL.
+func reportWhere() (string, []any) {
+	conds, args := []string{"TRUE"}, []any{}
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if !cfg.StartFromDate.IsZero() {
+		add("trip_start_timestamp >= $%d", cfg.StartFromDate)
+	}
+	if len(cfg.Companies) > 0 {
+		add("company = ANY($%d)", pq.Array(cfg.Companies))
+	}
+	if len(cfg.PickupAreas) > 0 {
+		add("pickup_community_area = ANY($%d)", pq.Array(cfg.PickupAreas))
+	}
+	if cfg.MinFare != nil {
+		add("fare >= $%d", *cfg.MinFare)
+	}
+	if cfg.MaxFare != nil {
+		add("fare <= $%d", *cfg.MaxFare)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// reportFromDB runs the -report over the rows already in taxi_trips instead
+// of fetching.
+func reportFromDB(ctx context.Context, db *sql.DB, w io.Writer) error {
+	agg, ok := reports[cfg.Report]().(dbAggregator)
+	if !ok {
+		return fmt.Errorf("the %s report cannot be run with -report-source db", cfg.Report)
+	}
+	where, args := reportWhere()
+	if err := agg.query(ctx, db, where, args); err != nil {
+		return err
+	}
+	agg.render(w)
+	return nil
+}
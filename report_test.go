@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func reportTrip(company, payment string, area int, miles, total, tips float64) data_fetched {
+	var t data_fetched
+	t.Company, t.PaymentType = company, payment
+	t.PickupCommunityArea = CustomInt{Int: area, Valid: area != 0}
+	t.TripMiles.Float64, t.TripTotal.Float64, t.Tips.Float64 = miles, total, tips
+	return t
+}
+
+var reportTrips = []data_fetched{
+	reportTrip("Flash Cab", "Cash", 8, 2.5, 12.25, 0),
+	reportTrip("Flash Cab", "Credit Card", 8, 4, 20.5, 3.5),
+	reportTrip("Sun Taxi", "Credit Card", 32, 1, 7.75, 1.25),
+	reportTrip("Sun Taxi", "Mobile", 0, 10, 35, 5),
+}
+
+// Each group report gives the same table from fetched trips as from
+// taxi_trips with -report-source db, given the rows the trips were stored
+// as grouped by its key.
+func TestGroupReportSources(t *testing.T) {
+	tests := []struct {
+		report string
+		keySQL string
+		rows   [][]any // key, trips, miles, revenue, tips
+	}{
+		{"company", "company", [][]any{{"Flash Cab", 2, 6.5, 32.75, 3.5}, {"Sun Taxi", 2, 11.0, 42.75, 6.25}}},
+		{"area", "NULLIF(pickup_community_area, 0)::text", [][]any{{"8", 2, 6.5, 32.75, 3.5}, {"32", 1, 1.0, 7.75, 1.25}, {"", 1, 10.0, 35.0, 5.0}}},
+		{"payment", "payment_type", [][]any{{"Cash", 1, 2.5, 12.25, 0.0}, {"Credit Card", 2, 5.0, 28.25, 4.75}, {"Mobile", 1, 10.0, 35.0, 5.0}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.report, func(t *testing.T) {
+			fetched := reports[tt.report]()
+			fetched.add(reportTrips)
+			var want bytes.Buffer
+			fetched.render(&want)
+
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			rows := sqlmock.NewRows([]string{"key", "count", "miles", "revenue", "tips"})
+			for _, r := range tt.rows {
+				rows.AddRow(driverValues(r)...)
+			}
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE("+tt.keySQL+", '')") + ".*GROUP BY 1").WillReturnRows(rows)
+
+			withConfig(t, func(c *config) { c.Report = tt.report })
+			var got bytes.Buffer
+			if err := reportFromDB(context.Background(), db, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != want.String() {
+				t.Errorf("-report-source db:\n%s\nfetched:\n%s", got.String(), want.String())
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestReportStreaming feeds the same trips to every report batch by batch
+// and all at once: the accumulated reports must be equal.
+func TestReportStreaming(t *testing.T) {
+	companies := []string{"Flash Cab", "Sun Taxi", "Blue Ribbon", ""}
+	payments := []string{"Cash", "Credit Card", "Mobile"}
+	start := time.Date(2023, 1, 6, 0, 0, 0, 0, time.UTC) // a Friday
+	var trips []data_fetched
+	for i := 0; i < 250; i++ {
+		trip := reportTrip(companies[i%len(companies)], payments[i%len(payments)], i%5*8,
+			float64(i%13)/4, float64(i%29)+0.25, float64(i%7)/2)
+		trip.TaxiID = fmt.Sprintf("taxi-%d", i%11)
+		if i%17 != 0 {
+			trip.TripStartTimestamp = CustomTime{Time: start.Add(time.Duration(i) * 37 * time.Minute), Valid: true}
+		}
+		trips = append(trips, trip)
+	}
+
+	names := make([]string, 0, len(reports))
+	for name := range reports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, split := range []bool{false, true} {
+		withConfig(t, func(c *config) { c.SplitWeekend = split })
+		for _, name := range names {
+			streamed, whole := reports[name](), reports[name]()
+			for i := 0; i < len(trips); i += 7 {
+				streamed.add(trips[i:min(i+7, len(trips))])
+			}
+			whole.add(trips)
+
+			if !reflect.DeepEqual(reportState(streamed), reportState(whole)) {
+				t.Errorf("%s report (split %v) streamed = %+v, all at once = %+v", name, split, reportState(streamed), reportState(whole))
+			}
+			var got, want bytes.Buffer
+			streamed.render(&got)
+			whole.render(&want)
+			if got.String() != want.String() {
+				t.Errorf("%s report (split %v) streamed renders\n%s\nall at once\n%s", name, split, got.String(), want.String())
+			}
+		}
+	}
+}
+
+// reportState is the aggregate state of a report, which DeepEqual can
+// compare.
+func reportState(agg aggregator) any {
+	if r, ok := agg.(*groupReport); ok {
+		totals := make(map[string]groupTotals, len(r.groups))
+		for key, g := range r.groups {
+			totals[key] = *g
+		}
+		return totals
+	}
+	return agg
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cursor marks the last trip of a page for keyset paging. Time is only set
+// when paging by start timestamp (-start-from-date).
+type cursor struct {
+	Time   time.Time
+	TripID string
+}
+
+// tripCursor returns the cursor positioned at t.
+func tripCursor(t data_fetched) cursor {
+	c := cursor{TripID: t.TripID}
+	if !cfg.StartFromDate.IsZero() {
+		c.Time = t.TripStartTimestamp.Time
+	}
+	return c
+}
+
+// String renders the cursor as "trip_id", or "timestamp trip_id" when
+// paging by time; parseCursor reverses it.
+func (c cursor) String() string {
+	if c.Time.IsZero() {
+		return c.TripID
+	}
+	return c.Time.Format(ctLayout) + " " + c.TripID
+}
+
+func parseCursor(s string) (cursor, error) {
+	ts, id, ok := strings.Cut(s, " ")
+	if !ok {
+		return cursor{TripID: s}, nil
+	}
+	t, err := time.Parse(ctLayout, ts)
+	if err != nil {
+		return cursor{}, err
+	}
+	return cursor{Time: t, TripID: id}, nil
+}
+
+// readResumeFile returns the keyset cursor saved in path, or "" when the
+// file does not exist yet.
+func readResumeFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// writeFileAtomic replaces path with data by writing a temporary file in the
+// same directory and renaming it, so a crash never leaves a torn file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// cursorTracker persists the keyset cursor once batches have been inserted.
+// Insert workers can finish out of order, so the saved cursor only advances
+// past a batch when every earlier batch has finished too.
+type cursorTracker struct {
+	path string
+
+	mu      sync.Mutex
+	next    int            // sequence number of the oldest unfinished batch
+	pending map[int]cursor // finished batches waiting on an earlier one
+}
+
+func newCursorTracker(path string) *cursorTracker {
+	return &cursorTracker{path: path, pending: make(map[int]cursor)}
+}
+
+// done records that batch seq, ending at cur, has been inserted.
+func (c *cursorTracker) done(seq int, cur cursor) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pending[seq] = cur
+	var last cursor
+	for {
+		p, ok := c.pending[c.next]
+		if !ok {
+			break
+		}
+		delete(c.pending, c.next)
+		last = p
+		c.next++
+	}
+	if last.TripID == "" {
+		return nil
+	}
+	return writeFileAtomic(c.path, []byte(last.String()+"\n"))
+}
+
+// checkpoint flushes every sink and then saves cur to -resume-file, so a
+// restart with the same -resume-file continues right after the rows written
+// so far.
+func checkpoint(sinks []Sink, cur cursor, rows int) error {
+	flushSinks(sinks)
+	if cfg.ResumeFile != "" && cur.TripID != "" {
+		if err := writeFileAtomic(cfg.ResumeFile, []byte(cur.String()+"\n")); err != nil {
+			return fmt.Errorf("saving resume cursor: %w", err)
+		}
+	}
+	log.Printf("Checkpoint: %d rows, cursor %s\n", rows, cur)
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestResumeAfterCrash saves cursors the way the insert workers do, then
+// leaves the process state behind as a crash would: a batch still pending
+// and a torn temporary file next to the resume file. The next run must
+// page on from the last cursor every earlier batch was inserted up to.
+func TestResumeAfterCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resume")
+
+	tracker := newCursorTracker(path)
+	if err := tracker.done(1, cursor{TripID: "d"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("cursor saved before batch 0 finished (stat: %v)", err)
+	}
+	if err := tracker.done(0, cursor{TripID: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.done(3, cursor{TripID: "h"}); err != nil {
+		t.Fatal(err)
+	}
+	// The crash: batch 2 never finishes and a write is cut short.
+	if err := os.WriteFile(path+".tmp123", []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := readResumeFile(path)
+	if err != nil || saved != "d" {
+		t.Fatalf("resume file holds %q (%v), want %q", saved, err, "d")
+	}
+
+	wheres := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case wheres <- r.URL.Query().Get("$where"):
+		default:
+		}
+		w.Write([]byte(`[]`))
+	}))
+	t.Cleanup(srv.Close)
+	withConfig(t, func(c *config) {
+		c.DatasetURL = srv.URL + "/resource.json"
+		c.DB = false
+		c.SummaryInterval = 0
+		c.ResumeFile = path
+		c.Keyset = true
+	})
+	if _, err := runFetch(t); err != nil {
+		t.Fatal(err)
+	}
+	if where, want := <-wheres, "trip_id > 'd'"; where != want {
+		t.Errorf("restarted run asked for $where %q, want %q", where, want)
+	}
+}
+
+func TestReadResumeFileMissing(t *testing.T) {
+	saved, err := readResumeFile(filepath.Join(t.TempDir(), "resume"))
+	if saved != "" || err != nil {
+		t.Errorf("readResumeFile of a missing file = %q, %v, want an empty cursor", saved, err)
+	}
+}
+
+func TestParseCursor(t *testing.T) {
+	tests := []string{"abc", "2023-01-01T00:15:00.000 abc"}
+	for _, s := range tests {
+		c, err := parseCursor(s)
+		if err != nil {
+			t.Errorf("parseCursor(%q): %v", s, err)
+			continue
+		}
+		if c.String() != s {
+			t.Errorf("parseCursor(%q).String() = %q", s, c.String())
+		}
+	}
+	if _, err := parseCursor("yesterday abc"); err == nil {
+		t.Error("parseCursor accepted a bad timestamp")
+	}
+}
+
+// TestRestartAtCheckpoint stops a run between checkpoints and restarts it:
+// the second run pages on from the last checkpoint, refetching the trips
+// fetched after it.
+func TestRestartAtCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resume")
+	withConfig(t, func(c *config) {
+		c.DB = false
+		c.SummaryInterval = 0
+		c.ResumeFile = path
+		c.Keyset = true
+		c.CheckpointEvery = 2
+		c.MaxConsecutiveErrors = 1
+	})
+
+	// The first run checkpoints after a and b, fetches c and dies on the
+	// next page.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pages := []string{`[{"trip_id":"a"},{"trip_id":"b"}]`, `[{"trip_id":"c"}]`}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(pages) == 0 {
+			cancel()
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(pages[0]))
+		pages = pages[1:]
+	}))
+	defer srv.Close()
+	cfg.DatasetURL = srv.URL + "/resource.json"
+	rows, _ := fetchAndPrinttaxitrips(ctx, ctx, nil, nil, &progress{start: time.Now()})
+	if rows != 3 {
+		t.Fatalf("first run fetched %d rows, want 3", rows)
+	}
+	if saved, err := readResumeFile(path); err != nil || saved != "b" {
+		t.Fatalf("checkpoint saved %q (%v), want %q", saved, err, "b")
+	}
+
+	wheres := make(chan string, 1)
+	restarted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case wheres <- r.URL.Query().Get("$where"):
+		default:
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer restarted.Close()
+	cfg.DatasetURL = restarted.URL + "/resource.json"
+	if _, err := runFetch(t); err != nil {
+		t.Fatal(err)
+	}
+	if where, want := <-wheres, "trip_id > 'b'"; where != want {
+		t.Errorf("restarted run asked for $where %q, want %q", where, want)
+	}
+}
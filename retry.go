@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/bits"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// retryRand is the jitter source. Tests can call seedRetryJitter to make the
+// delays reproducible.
+var (
+	retryMu   sync.Mutex
+	retryRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func seedRetryJitter(seed int64) {
+	retryMu.Lock()
+	defer retryMu.Unlock()
+	retryRand = rand.New(rand.NewSource(seed))
+}
+
+// RetryPolicy is how an operation is retried: how often, how long to wait
+// between attempts and which errors are worth another attempt. The page
+// fetch, the count query and the inserts each have one, built from the flags
+// by fetchRetry, countRetry and dbRetry.
+type RetryPolicy struct {
+	Name        string        // of the operation, for the log
+	MaxAttempts int           // the first attempt included; below 1 means 1
+	BaseDelay   time.Duration // before the first retry, doubling after each one
+	MaxDelay    time.Duration // cap on the doubled delay
+	Jitter      bool          // wait a random part of the delay
+	// Retryable reports whether an attempt that failed with err is worth
+	// repeating. A nil Retryable retries every error.
+	Retryable func(err error) bool
+}
+
+// Delay returns how long to wait after the given number of consecutive
+// failures. With Jitter it uses full jitter, a random delay between 0 and
+// the exponential backoff, so clients that failed together do not retry
+// together.
+func (p RetryPolicy) Delay(failures int) time.Duration {
+	backoff := p.MaxDelay
+	if failures < 1 {
+		failures = 1
+	}
+	// Only shifts that stay within MaxDelay are taken, so a large base
+	// cannot overflow into a negative delay.
+	switch shift := failures - 1; {
+	case p.BaseDelay <= 0:
+		backoff = 0
+	case p.BaseDelay >= p.MaxDelay:
+	case shift < bits.Len64(uint64(p.MaxDelay/p.BaseDelay)):
+		backoff = p.BaseDelay << shift
+	}
+	if !p.Jitter || backoff <= 0 {
+		return max(backoff, 0)
+	}
+
+	retryMu.Lock()
+	defer retryMu.Unlock()
+	return time.Duration(retryRand.Int63n(int64(backoff) + 1))
+}
+
+// Do calls fn until it succeeds, returns an error that is not Retryable,
+// has been called MaxAttempts times or ctx is done, and returns its last
+// error.
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+		if p.Retryable != nil && !p.Retryable(err) {
+			return err
+		}
+		delay := p.Delay(attempt)
+		logger(ctx).Warn("Retrying", "op", p.Name, "attempt", attempt, "max_attempts", p.MaxAttempts, "delay", delay, "err", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// fetchRetry is the policy for page fetches. The fetch loop waits for the
+// circuit breaker within each attempt and bounds all of a page's attempts
+// with -page-deadline.
+func fetchRetry() RetryPolicy {
+	return RetryPolicy{
+		Name:        "fetch",
+		MaxAttempts: cfg.MaxConsecutiveErrors,
+		BaseDelay:   cfg.RetryBase,
+		MaxDelay:    cfg.RetryMax,
+		Jitter:      cfg.RetryJitter,
+	}
+}
+
+// countRetry is the policy for the total count query. -count-timeout
+// bounds all of its attempts together.
+func countRetry() RetryPolicy {
+	return RetryPolicy{
+		Name:        "count query",
+		MaxAttempts: cfg.CountAttempts,
+		BaseDelay:   cfg.RetryBase,
+		MaxDelay:    cfg.RetryMax,
+		Jitter:      cfg.RetryJitter,
+		Retryable:   transientAPIError,
+	}
+}
+
+// dbRetry is the policy for inserting a batch. Each attempt is a
+// transaction of its own, so a failed one leaves nothing behind.
+func dbRetry() RetryPolicy {
+	return RetryPolicy{
+		Name:        "insert",
+		MaxAttempts: cfg.DBAttempts,
+		BaseDelay:   cfg.RetryBase,
+		MaxDelay:    cfg.RetryMax,
+		Jitter:      cfg.RetryJitter,
+		Retryable:   transientDBError,
+	}
+}
+
+// transientAPIError reports whether err is a network failure, a server
+// error or throttling, which may pass, rather than a rejected query.
+func transientAPIError(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.StatusCode >= 500 || se.StatusCode == http.StatusTooManyRequests
+	}
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// transientDBError reports whether err is a lost connection, a
+// serialization failure or deadlock, or the server shutting down, after
+// which the same transaction may succeed.
+func transientDBError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", "40": // connection exception, transaction rollback
+			return true
+		}
+		switch pqErr.Code {
+		case "57P01", "57P02", "57P03": // admin_shutdown, crash_shutdown, cannot_connect_now
+			return true
+		}
+		return false
+	}
+	var ne net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &ne)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryDelaySchedule(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+	want := []time.Duration{
+		time.Second, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second,
+		30 * time.Second, 30 * time.Second,
+	}
+	for failures, w := range want {
+		if got := p.Delay(failures); got != w {
+			t.Errorf("Delay(%d) = %s, want %s", failures, got, w)
+		}
+	}
+	// The shift would overflow long before this.
+	if got := p.Delay(100); got != p.MaxDelay {
+		t.Errorf("Delay(100) = %s, want the cap %s", got, p.MaxDelay)
+	}
+}
+
+// Large bases reach the cap instead of overflowing time.Duration.
+func TestRetryDelayLargeBase(t *testing.T) {
+	tests := []struct {
+		base, max time.Duration
+		failures  int
+		want      time.Duration
+	}{
+		{time.Minute, 24 * time.Hour, 29, 24 * time.Hour},
+		{time.Minute, 24 * time.Hour, 12, 24 * time.Hour},
+		{time.Minute, 24 * time.Hour, 11, 1024 * time.Minute},
+		{time.Hour, time.Duration(1<<63 - 1), 40, time.Duration(1<<63 - 1)},
+		{time.Hour, time.Duration(1<<63 - 1), 12, 2048 * time.Hour},
+		{time.Hour, time.Minute, 3, time.Minute},
+		{0, time.Minute, 5, 0},
+	}
+	for _, tt := range tests {
+		p := RetryPolicy{BaseDelay: tt.base, MaxDelay: tt.max}
+		if got := p.Delay(tt.failures); got != tt.want {
+			t.Errorf("Delay(%d) with base %s and max %s = %s, want %s", tt.failures, tt.base, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestRetryDelayFullJitter(t *testing.T) {
+	seedRetryJitter(1)
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 30 * time.Second, Jitter: true}
+	for failures := 1; failures <= 8; failures++ {
+		backoff := min(time.Second<<(failures-1), p.MaxDelay)
+		var low, high bool
+		for i := 0; i < 1000; i++ {
+			d := p.Delay(failures)
+			if d < 0 || d > backoff {
+				t.Fatalf("Delay(%d) = %s, want within [0, %s]", failures, d, backoff)
+			}
+			low = low || d < backoff/4
+			high = high || d > backoff*3/4
+		}
+		if !low || !high {
+			t.Errorf("Delay(%d) does not spread over [0, %s]", failures, backoff)
+		}
+	}
+}
+
+func TestRetryDo(t *testing.T) {
+	errTransient := errors.New("503")
+	errRejected := errors.New("400")
+	p := RetryPolicy{Name: "test", MaxAttempts: 4, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond,
+		Retryable: func(err error) bool { return err == errTransient }}
+	tests := []struct {
+		name      string
+		errs      []error // returned by successive attempts, then nil
+		wantCalls int
+		wantErr   error
+	}{
+		{"first attempt", nil, 1, nil},
+		{"after retries", []error{errTransient, errTransient}, 3, nil},
+		{"max attempts", []error{errTransient, errTransient, errTransient, errTransient, errTransient}, 4, errTransient},
+		{"not retryable", []error{errTransient, errRejected}, 2, errRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := p.Do(context.Background(), func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if calls != tt.wantCalls || err != tt.wantErr {
+				t.Errorf("Do made %d calls and returned %v, want %d calls and %v", calls, err, tt.wantCalls, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryDoStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := RetryPolicy{MaxAttempts: 10, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	calls := 0
+	done := make(chan error)
+	go func() {
+		done <- p.Do(ctx, func() error {
+			calls++
+			return errors.New("503")
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil || calls != 1 {
+			t.Errorf("Do made %d calls and returned %v, want 1 call and its error", calls, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Do kept waiting after the context was canceled")
+	}
+}
+
+func TestRetryJitterDeterministic(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 5 * time.Second, MaxDelay: 2 * time.Minute, Jitter: true}
+	delays := func() []time.Duration {
+		seedRetryJitter(42)
+		var ds []time.Duration
+		for failures := 1; failures <= 10; failures++ {
+			ds = append(ds, p.Delay(failures))
+		}
+		return ds
+	}
+	first, second := delays(), delays()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("with the same seed, delay %d was %s and then %s", i+1, first[i], second[i])
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// runLogHeader names the -run-log columns, the same as extraction_runs up to
+// max_trip_start, which is left out so existing files keep their layout.
+var runLogHeader = []string{
+	"run_id", "started_at", "ended_at", "rows_fetched", "rows_inserted", "rows_skipped",
+	"final_offset", "exit_code", "exit_status", "filter",
+}
+
+// appendRunLog adds r as one row to the CSV file at path, writing the header
+// first when the file is new or empty. On Unix the file is held under an
+// exclusive flock while the row is written, so runs finishing at the same
+// time append whole rows one after the other.
+func appendRunLog(path string, r runStats) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Closing the file releases the lock.
+	if err := lockFile(f); err != nil {
+		return fmt.Errorf("locking %s: %w", path, err)
+	}
+
+	// Checked under the lock, so only the first of two new runs writes it.
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	if info.Size() == 0 {
+		w.Write(runLogHeader)
+	}
+	w.Write([]string{
+		r.runID, r.startedAt.Format(time.RFC3339), r.endedAt.Format(time.RFC3339),
+		strconv.FormatInt(r.fetched, 10), strconv.FormatInt(r.inserted, 10), strconv.FormatInt(r.skipped, 10),
+		strconv.Itoa(r.finalOffset), strconv.Itoa(r.exitCode), exitDescriptions[r.exitCode], r.filter,
+	})
+	w.Flush()
+	return w.Error()
+}
+
+// logRun appends the run to -run-log. Like recordRun it runs deferred and
+// only logs a failure.
+func logRun(path string, r runStats) {
+	if err := appendRunLog(path, r); err != nil {
+		log.Printf("Appending run to %s: %v\n", path, err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func readRunLog(t *testing.T, path string) [][]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestAppendRunLogTwoRuns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "runs.csv")
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	runs := []runStats{
+		{runID: "r1", startedAt: start, endedAt: start.Add(time.Minute), fetched: 300, inserted: 300,
+			finalOffset: 200, exitCode: exitOK},
+		{runID: "r2", startedAt: start.Add(time.Hour), endedAt: start.Add(time.Hour + time.Minute), fetched: 100,
+			skipped: 100, exitCode: exitDB, filter: "company = 'Flash Cab'"},
+	}
+	for _, r := range runs {
+		if err := appendRunLog(path, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := [][]string{
+		runLogHeader,
+		{"r1", "2026-10-01T12:00:00Z", "2026-10-01T12:01:00Z", "300", "300", "0", "200", "0", "success", ""},
+		{"r2", "2026-10-01T13:00:00Z", "2026-10-01T13:01:00Z", "100", "0", "100", "0", "8", exitDescriptions[exitDB], "company = 'Flash Cab'"},
+	}
+	if got := readRunLog(t, path); !reflect.DeepEqual(got, want) {
+		t.Errorf("run log =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestAppendRunLogConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "runs.csv")
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := appendRunLog(path, runStats{runID: fmt.Sprint(i), filter: "a, \"quoted\" filter"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	rows := readRunLog(t, path)
+	if len(rows) != n+1 {
+		t.Fatalf("got %d rows, want the header and %d runs", len(rows), n)
+	}
+	if !reflect.DeepEqual(rows[0], runLogHeader) {
+		t.Errorf("first row = %q, want the header", rows[0])
+	}
+	for _, row := range rows[1:] {
+		if len(row) != len(runLogHeader) {
+			t.Errorf("row %q has %d fields, want %d", row, len(row), len(runLogHeader))
+		}
+	}
+}
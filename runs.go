@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// insertedRows counts the rows the primary database accepted, for
+// -stats-db.
+var insertedRows atomic.Int64
+
+// createRunsTable creates extraction_runs, the -stats-db run history.
+func createRunsTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+        CREATE TABLE IF NOT EXISTS extraction_runs (
+            id BIGSERIAL PRIMARY KEY,
+            run_id TEXT,
+            started_at TIMESTAMPTZ,
+            ended_at TIMESTAMPTZ,
+            rows_fetched INTEGER,
+            rows_inserted INTEGER,
+            rows_skipped INTEGER,
+            final_offset INTEGER,
+            exit_code INTEGER,
+            exit_status TEXT,
+            filter TEXT,
+            max_trip_start TIMESTAMP
+        );
+        ALTER TABLE extraction_runs ADD COLUMN IF NOT EXISTS max_trip_start TIMESTAMP;
+    `)
+	return err
+}
+
+// runStats is what -stats-db and -run-log record about a run.
+type runStats struct {
+	runID              string
+	startedAt, endedAt time.Time
+	fetched, inserted  int64
+	skipped            int64
+	finalOffset        int
+	exitCode           int
+	filter             string
+	maxTripStart       time.Time // of the trips loaded, zero when none were
+}
+
+// collectRunStats gathers the stats of the run ending with code.
+func collectRunStats(runID string, prog *progress, code int) runStats {
+	r := runStats{
+		runID:       runID,
+		startedAt:   prog.start,
+		endedAt:     time.Now(),
+		fetched:     int64(prog.count()),
+		inserted:    insertedRows.Load(),
+		skipped:     skippedRecords.Load(),
+		finalOffset: prog.lastOffset(),
+		exitCode:    code,
+		filter:      activeFilter(),
+	}
+	if cfg.DB && !cfg.Diff {
+		r.maxTripStart = prog.maxStart()
+	}
+	return r
+}
+
+// recordRun adds the run to extraction_runs. It is deferred so canceled and
+// partially failed runs are recorded too, and uses its own context since the
+// run's may be done by then. A failure is only logged.
+func recordRun(db *sql.DB, r runStats) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := db.ExecContext(ctx, `
+        INSERT INTO extraction_runs (run_id, started_at, ended_at, rows_fetched, rows_inserted, rows_skipped,
+            final_offset, exit_code, exit_status, filter, max_trip_start)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		r.runID, r.startedAt, r.endedAt, r.fetched, r.inserted, r.skipped,
+		r.finalOffset, r.exitCode, exitDescriptions[r.exitCode], r.filter, nullTime(r.maxTripStart))
+	if err != nil {
+		log.Printf("Recording run in extraction_runs: %v\n", err)
+	}
+}
+
+// lastRunMark returns the latest trip start loaded by the last successful
+// run that loaded any trips, or zero when there is no such run.
+func lastRunMark(ctx context.Context, db *sql.DB) (time.Time, error) {
+	var mark time.Time
+	err := db.QueryRowContext(ctx, `
+        SELECT max_trip_start FROM extraction_runs
+        WHERE exit_code = 0 AND max_trip_start IS NOT NULL
+        ORDER BY ended_at DESC LIMIT 1`).Scan(&mark)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return mark, err
+}
+
+// applySinceLastRun sets the -since-last-run lower bound from
+// extraction_runs, before the count and confirmation queries are built.
+//
+// The bound is inclusive, since trips share start timestamps and ones with
+// the mark's own may have been published after it was recorded; reloading
+// those is harmless as the insert is an upsert. Trips published late with an
+// earlier start are not picked up; a periodic full load catches them.
+func applySinceLastRun() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := connString()
+	if err != nil {
+		return err
+	}
+	db, err := pingDB(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := createRunsTable(ctx, db); err != nil {
+		return err
+	}
+	return sinceLastRun(ctx, db)
+}
+
+// sinceLastRun sets cfg.Since to the mark of the last successful run in db,
+// leaving it unset when there is none.
+func sinceLastRun(ctx context.Context, db *sql.DB) error {
+	mark, err := lastRunMark(ctx, db)
+	if err != nil {
+		return fmt.Errorf("reading the last run from extraction_runs: %w", err)
+	}
+	if mark.IsZero() {
+		log.Println("No successful run recorded in extraction_runs; -since-last-run loads everything")
+		return nil
+	}
+	cfg.Since = mark
+	log.Printf("Loading trips starting at or after %s, the latest loaded by the last successful run\n", mark.Format(ctLayout))
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRecordRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	started := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	latest := time.Date(2026, 9, 30, 23, 45, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		r    runStats
+		mark any
+	}{
+		{"success", runStats{runID: "r1", startedAt: started, endedAt: started.Add(time.Minute),
+			fetched: 300, inserted: 300, finalOffset: 200, exitCode: exitOK, maxTripStart: latest}, latest},
+		// A failed run is recorded too, with nothing to resume from.
+		{"failure", runStats{runID: "r2", startedAt: started, endedAt: started.Add(time.Minute),
+			fetched: 100, skipped: 100, finalOffset: 0, exitCode: exitDB, filter: "company = 'Flash Cab'"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.r
+			mock.ExpectExec("INSERT INTO extraction_runs").
+				WithArgs(r.runID, r.startedAt, r.endedAt, r.fetched, r.inserted, r.skipped,
+					r.finalOffset, r.exitCode, exitDescriptions[r.exitCode], r.filter, tt.mark).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			recordRun(db, r)
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestSinceLastRun seeds extraction_runs with the last successful run and
+// checks the page query starts at its mark; with no run recorded the load
+// is a full one.
+func TestSinceLastRun(t *testing.T) {
+	mark := time.Date(2026, 9, 30, 23, 45, 0, 0, time.UTC)
+	query := `SELECT max_trip_start FROM extraction_runs\s+WHERE exit_code = 0 AND max_trip_start IS NOT NULL\s+ORDER BY ended_at DESC LIMIT 1`
+	tests := []struct {
+		name      string
+		rows      *sqlmock.Rows
+		wantWhere string
+	}{
+		{"prior run", sqlmock.NewRows([]string{"max_trip_start"}).AddRow(mark), "trip_start_timestamp >= '2026-09-30T23:45:00.000'"},
+		{"no prior run", sqlmock.NewRows([]string{"max_trip_start"}), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *config) {
+				c.Since = time.Time{}
+				c.Keyset = false
+			})
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			mock.ExpectQuery(query).WillReturnRows(tt.rows)
+
+			if err := sinceLastRun(context.Background(), db); err != nil {
+				t.Fatal(err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+			u, err := url.Parse(pageURL(0, cursor{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := u.Query().Get("$where"); got != tt.wantWhere {
+				t.Errorf("$where = %q, want %q", got, tt.wantWhere)
+			}
+		})
+	}
+
+	t.Run("query fails", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		mock.ExpectQuery(query).WillReturnError(errors.New("relation does not exist"))
+		if err := sinceLastRun(context.Background(), db); err == nil {
+			t.Error("sinceLastRun succeeded without extraction_runs")
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// statusError is a non-200 response from the API.
+type statusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *statusError) Error() string { return "unexpected status " + e.Status }
+
+// serverSampleURL asks for n rows in one request: a block of n consecutive
+// rows in :id order, starting at a random offset among the total matching
+// rows.
+//
+// This is cheap but only roughly random. :id follows the order rows were
+// published, so the block tends to cover a narrow span of trip dates and
+// can over-represent whatever was loaded together; it is representative
+// only for fields that do not vary with load order. Use -server-sample for
+// quick looks, not for estimates that need a true random sample.
+func serverSampleURL(total, n int) string {
+	params := url.Values{
+		"$order":  {":id"},
+		"$limit":  {strconv.Itoa(n)},
+		"$offset": {strconv.Itoa(rand.Intn(total - n + 1))},
+	}
+	return queryURL(params)
+}
+
+// clientSampler is the fallback when the server refuses the sample query:
+// pages are fetched as usual and each trip is kept with probability p, so
+// about n of the total rows are kept.
+type clientSampler struct {
+	p    float64
+	rand *rand.Rand
+}
+
+func newClientSampler(total, n int) *clientSampler {
+	return &clientSampler{p: float64(n) / float64(total), rand: rand.New(rand.NewSource(rand.Int63()))}
+}
+
+func (s *clientSampler) filter(trips []data_fetched) []data_fetched {
+	kept := trips[:0]
+	for _, t := range trips {
+		if s.rand.Float64() < s.p {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// planSample returns the URL of the server-side sample of n rows, or "" when
+// the dataset has no more than n matching rows and is loaded whole.
+func planSample(ctx context.Context, n int) (string, int, error) {
+	total, err := fetchTotalCount(ctx)
+	if err != nil {
+		return "", 0, fmt.Errorf("-server-sample needs the row count: %w", err)
+	}
+	if total <= n {
+		log.Printf("Only %d rows match; loading all of them instead of sampling\n", total)
+		return "", total, nil
+	}
+	return serverSampleURL(total, n), total, nil
+}
+
+// sampleUnsupported reports whether err means the server rejected the sample
+// query itself, as opposed to a transient failure worth retrying.
+func sampleUnsupported(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.StatusCode >= 400 && se.StatusCode < 500 && se.StatusCode != http.StatusTooManyRequests
+}
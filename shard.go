@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/lib/pq"
+)
+
+// With -shards N each trip goes to taxi_trips_<k>, k being the FNV-1a hash
+// of its trip_id mod N. The shards inherit from taxi_trips, which stays
+// empty: queries against taxi_trips (reports, -diff, -validate-only,
+// -reparse) see the rows of every shard, and columns added to it reach the
+// shards too.
+//
+// Each shard has its own primary key, so trip_id is only unique within a
+// shard. Routing is deterministic, so that holds as long as N does not
+// change; loading the same trips with another N duplicates them. Queries
+// that filter by trip_id still scan every shard, since Postgres cannot tell
+// which one holds it.
+
+// shardOf returns the shard a trip belongs to among n.
+func shardOf(tripID string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(tripID))
+	return int(h.Sum32() % uint32(n))
+}
+
+func shardName(k int) string {
+	return fmt.Sprintf("taxi_trips_%d", k)
+}
+
+// tripTable returns the table a trip is written to.
+func tripTable(tripID string) string {
+	if cfg.Shards <= 0 {
+		return "taxi_trips"
+	}
+	return shardName(shardOf(tripID, cfg.Shards))
+}
+
+// createShards creates the n shard tables that are missing.
+func createShards(ctx context.Context, db *sql.DB, n int) error {
+	for k := 0; k < n; k++ {
+		shard := pq.QuoteIdentifier(shardName(k))
+		_, err := db.ExecContext(ctx, fmt.Sprintf(`
+            CREATE TABLE IF NOT EXISTS %[1]s (PRIMARY KEY (trip_id)) INHERITS (taxi_trips);
+            CREATE INDEX IF NOT EXISTS %[2]s ON %[1]s (pickup_geohash);
+        `, shard, pq.QuoteIdentifier(shardName(k)+"_pickup_geohash_idx")))
+		if err != nil {
+			return fmt.Errorf("creating %s: %w", shardName(k), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestShardRouting inserts a page with -shards 2: each trip is upserted
+// into the shard its trip_id hashes to, with one statement per shard.
+func TestShardRouting(t *testing.T) {
+	withConfig(t, func(c *config) { c.Shards = 2 })
+	shardSQL := func(k int) string {
+		return strings.Replace(wantInsertSQL, `INSERT INTO "taxi_trips"`, `INSERT INTO "`+shardName(k)+`"`, 1)
+	}
+	args := func(trip data_fetched) []driver.Value {
+		a := append([]driver.Value{trip.TripID}, sampleArgs[1:24]...)
+		return append(a, rowHash(sourceValues(trip)))
+	}
+	// 0d5f2a0e9c1b and 2f7b4c20be3d hash to shard 1, 1e6a3b1fad2c to 0.
+	trips := []data_fetched{sampleTrip(t, "0d5f2a0e9c1b"), sampleTrip(t, "1e6a3b1fad2c"), sampleTrip(t, "2f7b4c20be3d")}
+
+	db, mock := newMock(t)
+	mock.ExpectBegin()
+	mock.ExpectPrepare(anomalySQL)
+	shard1 := mock.ExpectPrepare(shardSQL(1))
+	shard1.ExpectExec().WithArgs(args(trips[0])...).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectPrepare(shardSQL(0)).ExpectExec().WithArgs(args(trips[1])...).WillReturnResult(sqlmock.NewResult(0, 1))
+	shard1.ExpectExec().WithArgs(args(trips[2])...).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := insertTrips(context.Background(), db, batch{trips: trips}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTripTable(t *testing.T) {
+	tests := []struct {
+		shards int
+		tripID string
+		want   string
+	}{
+		{0, "a", "taxi_trips"},
+		{2, "a", "taxi_trips_0"},
+		{2, "b", "taxi_trips_1"},
+		{4, "c", "taxi_trips_2"},
+		{4, "d", "taxi_trips_3"},
+	}
+	for _, tt := range tests {
+		withConfig(t, func(c *config) { c.Shards = tt.shards })
+		if got := tripTable(tt.tripID); got != tt.want {
+			t.Errorf("with -shards %d, trip %s goes to %s, want %s", tt.shards, tt.tripID, got, tt.want)
+		}
+	}
+}
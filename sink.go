@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink is an output that receives every batch the fetch loop produces.
+type Sink interface {
+	Name() string
+	Write(ctx context.Context, b batch) error
+	Close() error
+}
+
+// openSinks opens the outputs enabled by the flags: Postgres and any replicas
+// unless -db=false or -diff, any -csv, -jsonl and -geojson files, stdout
+// with -stdout-ndjson, Kafka with -kafka-brokers, and the -report
+// aggregator.
+func openSinks(ctx context.Context, db *sql.DB, replicas []*sql.DB, tracker *cursorTracker) ([]Sink, error) {
+	var sinks []Sink
+	if cfg.DB && !cfg.Diff {
+		sinks = append(sinks, newDBSink(ctx, db, cfg.DBWorkers, tracker))
+		for i, r := range replicas {
+			sinks = append(sinks, newReplicaSink(ctx, r, i+1, cfg.DBWorkers))
+		}
+	}
+	if cfg.CSVPath != "" {
+		s, err := newCSVSink(cfg.CSVPath)
+		if err != nil {
+			closeSinks(sinks, err)
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.JSONLPath != "" {
+		s, err := newJSONLSink(cfg.JSONLPath)
+		if err != nil {
+			closeSinks(sinks, err)
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.GeoJSONPath != "" {
+		s, err := newGeoJSONSink(cfg.GeoJSONPath)
+		if err != nil {
+			closeSinks(sinks, err)
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.StdoutNDJSON {
+		sinks = append(sinks, newNDJSONSink(os.Stdout))
+	}
+	if len(cfg.KafkaBrokers) > 0 {
+		s, err := newKafkaSink()
+		if err != nil {
+			closeSinks(sinks, err)
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.Report != "" {
+		sinks = append(sinks, &ReportSink{name: cfg.Report, agg: reports[cfg.Report]()})
+	}
+	return sinks, nil
+}
+
+// writeSinks hands b to every sink.
+func writeSinks(ctx context.Context, sinks []Sink, b batch) {
+	for _, s := range sinks {
+		if err := s.Write(ctx, b); err != nil {
+			sinkError(s.Name(), err)
+		}
+	}
+}
+
+// queuedBatches returns how many batches wait in the database sinks' queues.
+func queuedBatches(sinks []Sink) int {
+	n := 0
+	for _, s := range sinks {
+		if d, ok := s.(*DBSink); ok {
+			n += len(d.batches)
+		}
+	}
+	return n
+}
+
+// flusher is implemented by sinks that buffer writes.
+type flusher interface {
+	Flush() error
+}
+
+// flushSinks makes every batch written so far durable in the sinks that
+// buffer.
+func flushSinks(sinks []Sink) {
+	for _, s := range sinks {
+		if f, ok := s.(flusher); ok {
+			if err := f.Flush(); err != nil {
+				sinkError(s.Name(), err)
+			}
+		}
+	}
+}
+
+// aborter is a sink whose output is only kept when the run ends cleanly,
+// such as a file export, which would otherwise look complete when cut short.
+type aborter interface {
+	abort(err error)
+}
+
+// closeSinks closes every sink once the run has ended with runErr. A failed
+// run, including one the database workers fail while their sinks are
+// closed, aborts the sinks that are only kept on success.
+func closeSinks(sinks []Sink, runErr error) {
+	for _, s := range sinks {
+		if runErr == nil {
+			runErr = runFailure.get()
+		}
+		if a, ok := s.(aborter); ok && runErr != nil {
+			a.abort(runErr)
+		}
+		if err := s.Close(); err != nil {
+			sinkError(s.Name(), err)
+		}
+	}
+}
+
+// sinkError ends the run on an output error unless -continue-on-sink-error
+// is set.
+func sinkError(name string, err error) {
+	if cfg.ContinueOnSinkError {
+		log.Printf("%s: %v\n", name, err)
+		return
+	}
+	runFailure.set(fmt.Errorf("%s: %w", name, err))
+}
+
+// DBSink inserts batches into Postgres from a pool of workers, each page in
+// its own transaction. Concurrent upserts of the same trip are resolved by
+// Postgres's ON CONFLICT handling. Write only queues the batch, so insert
+// errors are reported by the workers. When a tracker is set, the cursor of
+// each inserted batch of trips is handed to it.
+//
+// The queue holds -queue-size batches: a longer queue lets fetching run
+// ahead of slow inserts, at the cost of holding each queued batch in memory.
+// Its depth is reported as the queue_depth StatsD gauge. With
+// -db-load-threshold inserts into the primary database are slowed down
+// while it is busy; see dbThrottle.
+type DBSink struct {
+	name     string
+	primary  bool // counted in insertedRows
+	tracker  *cursorTracker
+	ids      *idLog
+	warnOnly bool // insert errors are logged, never fatal
+	throttle *dbThrottle
+
+	batches chan batch
+	wg      sync.WaitGroup // workers
+	pending sync.WaitGroup // queued batches not yet inserted
+}
+
+func newDBSink(ctx context.Context, db *sql.DB, workers int, tracker *cursorTracker) *DBSink {
+	s := &DBSink{name: "postgres", primary: true, tracker: tracker, ids: insertedIDs,
+		throttle: newDBThrottle(ctx, db, cfg.DBLoadThreshold, cfg.DBLoadInterval)}
+	s.start(ctx, db, workers)
+	return s
+}
+
+// newReplicaSink writes to the nth replica -dsn. The resume cursor and
+// -inserted-ids follow the primary database only.
+func newReplicaSink(ctx context.Context, db *sql.DB, n, workers int) *DBSink {
+	s := &DBSink{name: fmt.Sprintf("replica %d", n), warnOnly: cfg.ReplicaErrors == "warn"}
+	s.start(ctx, db, workers)
+	return s
+}
+
+func (s *DBSink) start(ctx context.Context, db *sql.DB, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	s.batches = make(chan batch, cfg.QueueSize)
+	for i := 0; i < workers; i++ {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for b := range s.batches {
+				s.reportDepth()
+				s.throttle.wait(ctx)
+				s.insert(ctx, db, b)
+				s.pending.Done()
+			}
+		}()
+	}
+}
+
+// insert loads one batch and hands its cursor to the tracker.
+func (s *DBSink) insert(ctx context.Context, db *sql.DB, b batch) {
+	ctx = withLogAttrs(ctx, "page", b.seq, "offset", b.offset)
+	ctx, insertSpan := otel.start(ctx, "insert batch")
+	insertSpan.set("seq", b.seq)
+	insertSpan.set("rows", b.size())
+	insertStart := time.Now()
+	err := dbRetry().Do(ctx, func() error {
+		if cfg.Loader != nil {
+			return cfg.Loader.insertRecords(ctx, db, b.records)
+		}
+		return insertTrips(ctx, db, b, s.ids)
+	})
+	if err != nil {
+		err = &DBError{Op: fmt.Sprintf("inserting the page at offset %d", b.offset), Err: err}
+	}
+	stats.timing("insert", time.Since(insertStart))
+	insertSpan.finish(err)
+	if err != nil && ctx.Err() != nil {
+		logger(ctx).Warn("Dropping batch after cancellation", "err", err)
+		skippedRecords.Add(int64(b.size()))
+		return
+	} else if err != nil && s.warnOnly {
+		logger(ctx).Warn("Insert failed", "sink", s.Name(), "err", err)
+		return
+	} else if err != nil {
+		sinkError(s.Name(), err)
+		skippedRecords.Add(int64(b.size()))
+		return
+	}
+	if s.primary {
+		insertedRows.Add(int64(b.size()))
+	}
+	if s.tracker != nil && len(b.trips) > 0 {
+		if err := s.tracker.done(b.seq, tripCursor(b.trips[len(b.trips)-1])); err != nil {
+			runFailure.set(fmt.Errorf("saving resume cursor: %w", err))
+		}
+	}
+}
+
+func (s *DBSink) Name() string { return s.name }
+
+func (s *DBSink) reportDepth() {
+	stats.gauge("queue_depth."+strings.ReplaceAll(s.name, " ", "_"), len(s.batches))
+}
+
+func (s *DBSink) Write(ctx context.Context, b batch) error {
+	s.pending.Add(1)
+	s.batches <- b
+	s.reportDepth()
+	return nil
+}
+
+// Flush waits for the batches queued so far to be inserted.
+func (s *DBSink) Flush() error {
+	s.pending.Wait()
+	return nil
+}
+
+// Close waits for the queued batches to be inserted.
+func (s *DBSink) Close() error {
+	close(s.batches)
+	s.wg.Wait()
+	s.throttle.close()
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// statsdPrefix namespaces every metric sent to StatsD.
+const statsdPrefix = "taxi_trips."
+
+// statsdClient sends counters and timers to StatsD over UDP. A nil client
+// discards everything, so callers need not check whether -statsd-addr is
+// set, and send errors are ignored so StatsD can never stop an extraction.
+type statsdClient struct {
+	conn net.Conn
+	rate float64
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+var stats *statsdClient
+
+// newStatsdClient connects to addr, returning nil (and logging why) when it
+// cannot.
+func newStatsdClient(addr string, rate float64) *statsdClient {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		log.Printf("StatsD disabled: %v\n", err)
+		return nil
+	}
+	if rate <= 0 || rate > 1 {
+		rate = 1
+	}
+	return &statsdClient{conn: conn, rate: rate, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (s *statsdClient) count(name string, n int) {
+	s.send(name, fmt.Sprintf("%d|c", n))
+}
+
+func (s *statsdClient) timing(name string, d time.Duration) {
+	s.send(name, fmt.Sprintf("%d|ms", d.Milliseconds()))
+}
+
+// gauge reports the current value of name. Gauges are never sampled, since
+// a dropped update would leave a stale value standing.
+func (s *statsdClient) gauge(name string, n int) {
+	if s == nil {
+		return
+	}
+	s.conn.Write([]byte(fmt.Sprintf("%s%s:%d|g", statsdPrefix, name, n)))
+}
+
+func (s *statsdClient) send(name, value string) {
+	if s == nil {
+		return
+	}
+	if s.rate < 1 {
+		s.mu.Lock()
+		skip := s.rnd.Float64() >= s.rate
+		s.mu.Unlock()
+		if skip {
+			return
+		}
+		value += fmt.Sprintf("|@%g", s.rate)
+	}
+	s.conn.Write([]byte(statsdPrefix + name + ":" + value))
+}
+
+func (s *statsdClient) close() {
+	if s != nil {
+		s.conn.Close()
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestTableRowMatchesHeader(t *testing.T) {
+	header := tableHeader()
+	for _, trip := range []data_fetched{{}, sampleTrip(t, "")} {
+		if row := tableRow(trip); len(row) != len(header) {
+			t.Errorf("trip %q has %d cells for %d headers", trip.TripID, len(row), len(header))
+		}
+	}
+}
+
+// TestPrintTableColumns checks the rendered table: every line of the
+// header and of the rows has the same number of cells.
+func TestPrintTableColumns(t *testing.T) {
+	var out bytes.Buffer
+	saved, printed, hidden := textOut, printedRows, hiddenRows
+	t.Cleanup(func() { textOut, printedRows, hiddenRows = saved, printed, hidden })
+	textOut, printedRows, hiddenRows = &out, 0, 0
+	withConfig(t, func(c *config) {
+		c.StdoutNDJSON = false
+		c.MaxPrintRows = 0
+	})
+
+	printTable([]data_fetched{sampleTrip(t, "a"), sampleTrip(t, "b")})
+	var lines []string
+	for _, l := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if strings.HasPrefix(l, "|") {
+			lines = append(lines, l)
+		}
+	}
+	if len(lines) != 3 {
+		t.Fatalf("printed %d header and row lines, want 3:\n%s", len(lines), out.String())
+	}
+	want := len(tableColumns) + 1
+	for _, l := range lines {
+		if n := strings.Count(l, "|"); n != want {
+			t.Errorf("line %q has %d separators, want %d", l, n, want)
+		}
+	}
+}
+
+// TestReservedTableName loads a -fields-file dataset into a table and
+// columns named after SQL keywords: every statement quotes them.
+func TestReservedTableName(t *testing.T) {
+	spec := &fieldsSpec{
+		Table: "order",
+		Key:   "user",
+		Fields: []fieldSpec{
+			{Name: "user", Type: "text"},
+			{Name: "Select", Type: "integer"},
+		},
+	}
+	db, mock := newMock(t)
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS "order" ("user" TEXT PRIMARY KEY, "Select" BIGINT)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectBegin()
+	mock.ExpectPrepare(`INSERT INTO "order" ("user", "Select") VALUES ($1, $2) ON CONFLICT ("user") DO UPDATE SET "Select" = EXCLUDED."Select"`).
+		ExpectExec().WithArgs("a", int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	ctx := context.Background()
+	if err := spec.createTable(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if err := spec.insertRecords(ctx, db, []json.RawMessage{json.RawMessage(`{"user":"a","Select":"3"}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
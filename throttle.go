@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+// activeConnsSQL counts the other sessions running a statement in the
+// database, the load -db-load-threshold is compared with.
+const activeConnsSQL = `SELECT count(*) FROM pg_stat_activity
+        WHERE state = 'active' AND datname = current_database() AND pid <> pg_backend_pid()`
+
+const (
+	minThrottleDelay = 100 * time.Millisecond
+	maxThrottleDelay = 10 * time.Second
+)
+
+// dbThrottle slows inserts down while Postgres is busy. Every interval it
+// counts the active sessions in pg_stat_activity; while there are more than
+// threshold, the delay before each insert doubles, up to maxThrottleDelay,
+// and once the load is back under it the delay halves until it is gone.
+//
+// The probe is one more query per interval on the instance being protected,
+// and it holds a connection from the pool while it runs, so keep the
+// interval in seconds rather than milliseconds. Sessions of other databases
+// on the same instance are not counted. A nil throttle never waits.
+type dbThrottle struct {
+	threshold int
+
+	mu    sync.Mutex
+	delay time.Duration
+	stop  context.CancelFunc
+	done  chan struct{}
+}
+
+func newDBThrottle(ctx context.Context, db *sql.DB, threshold int, interval time.Duration) *dbThrottle {
+	if threshold <= 0 {
+		return nil
+	}
+	ctx, stop := context.WithCancel(ctx)
+	t := &dbThrottle{threshold: threshold, stop: stop, done: make(chan struct{})}
+	go t.probe(ctx, db, interval)
+	return t
+}
+
+func (t *dbThrottle) probe(ctx context.Context, db *sql.DB, interval time.Duration) {
+	defer close(t.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var active int
+		if err := db.QueryRowContext(ctx, activeConnsSQL).Scan(&active); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("WARNING: probing database load: %v\n", err)
+			}
+			continue
+		}
+		stats.gauge("db_active_connections", active)
+		t.adjust(active)
+	}
+}
+
+// adjust sets the delay from the latest active session count.
+func (t *dbThrottle) adjust(active int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	old := t.delay
+	switch {
+	case active > t.threshold:
+		t.delay = min(max(2*t.delay, minThrottleDelay), maxThrottleDelay)
+	case t.delay > minThrottleDelay:
+		t.delay /= 2
+	default:
+		t.delay = 0
+	}
+	if t.delay != old {
+		log.Printf("Database load: %d active sessions (-db-load-threshold %d); insert delay now %s\n", active, t.threshold, t.delay)
+		stats.gauge("insert_delay_ms", int(t.delay/time.Millisecond))
+	}
+}
+
+// wait holds an insert back for the current delay, or until ctx is done.
+func (t *dbThrottle) wait(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	delay := t.delay
+	t.mu.Unlock()
+	if delay == 0 {
+		return
+	}
+	stats.timing("insert_throttled", delay)
+	select {
+	case <-ctx.Done():
+	case <-time.After(delay):
+	}
+}
+
+// close stops the probe.
+func (t *dbThrottle) close() {
+	if t == nil {
+		return
+	}
+	t.stop()
+	<-t.done
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// tnpDatasetURL is the Transportation Network Providers (rideshare) trips
+// dataset, used by -schema tnp unless -dataset-url is given.
+const tnpDatasetURL = "https://data.cityofchicago.org/resource/m6dm-c72p.json"
+
+// tnpTrip is a rideshare trip. It shares most fields with data_fetched but
+// has no taxi, company or payment details and records whether a shared trip
+// was authorized.
+type tnpTrip struct {
+	TripID                   string        `json:"trip_id"`
+	TripStartTimestamp       CustomTime    `json:"trip_start_timestamp"`
+	TripEndTimestamp         CustomTime    `json:"trip_end_timestamp"`
+	TripSeconds              CustomInt     `json:"trip_seconds"`
+	TripMiles                CustomFloat64 `json:"trip_miles"`
+	PickupCensusTract        string        `json:"pickup_census_tract"`
+	DropoffCensusTract       string        `json:"dropoff_census_tract"`
+	PickupCommunityArea      CustomInt     `json:"pickup_community_area"`
+	DropoffCommunityArea     CustomInt     `json:"dropoff_community_area"`
+	Fare                     CustomFloat64 `json:"fare"`
+	Tip                      CustomFloat64 `json:"tip"`
+	AdditionalCharges        CustomFloat64 `json:"additional_charges"`
+	TripTotal                CustomFloat64 `json:"trip_total"`
+	SharedTripAuthorized     bool          `json:"shared_trip_authorized"`
+	TripsPooled              CustomInt     `json:"trips_pooled"`
+	PickupCentroidLatitude   CustomFloat64 `json:"pickup_centroid_latitude"`
+	PickupCentroidLongitude  CustomFloat64 `json:"pickup_centroid_longitude"`
+	PickupCentroidLocation   Location      `json:"pickup_centroid_location"`
+	DropoffCentroidLatitude  CustomFloat64 `json:"dropoff_centroid_latitude"`
+	DropoffCentroidLongitude CustomFloat64 `json:"dropoff_centroid_longitude"`
+	DropoffCentroidLocation  Location      `json:"dropoff_centroid_location"`
+}
+
+// tnpColumns lists the tnp_trips columns in the order values returns them.
+var tnpColumns = []string{
+	"trip_id", "trip_start_timestamp", "trip_end_timestamp", "trip_seconds", "trip_miles",
+	"pickup_census_tract", "dropoff_census_tract", "pickup_community_area", "dropoff_community_area",
+	"fare", "tip", "additional_charges", "trip_total", "shared_trip_authorized", "trips_pooled",
+	"pickup_centroid_latitude", "pickup_centroid_longitude", "pickup_centroid_location",
+	"dropoff_centroid_latitude", "dropoff_centroid_longitude", "dropoff_centroid_location",
+}
+
+func (t tnpTrip) values() []any {
+	return []any{
+		t.TripID, nullTime(t.TripStartTimestamp.Time), nullTime(t.TripEndTimestamp.Time), t.TripSeconds.Int, t.TripMiles.Float64,
+		t.PickupCensusTract, t.DropoffCensusTract, t.PickupCommunityArea.Int, t.DropoffCommunityArea.Int,
+		t.Fare.Float64, t.Tip.Float64, t.AdditionalCharges.Float64, t.TripTotal.Float64, t.SharedTripAuthorized, t.TripsPooled.Int,
+		t.PickupCentroidLatitude.Float64, t.PickupCentroidLongitude.Float64, t.PickupCentroidLocation.wkt(),
+		t.DropoffCentroidLatitude.Float64, t.DropoffCentroidLongitude.Float64, t.DropoffCentroidLocation.wkt(),
+	}
+}
+
+// tnpSchema loads rideshare trips into tnp_trips.
+type tnpSchema struct{}
+
+func (tnpSchema) names() map[string]bool {
+	return jsonFields(reflect.TypeOf(tnpTrip{}))
+}
+
+func (tnpSchema) createTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+        CREATE TABLE IF NOT EXISTS tnp_trips (
+            trip_id TEXT PRIMARY KEY,
+            trip_start_timestamp TIMESTAMP,
+            trip_end_timestamp TIMESTAMP,
+            trip_seconds INTEGER,
+            trip_miles FLOAT,
+            pickup_census_tract TEXT,
+            dropoff_census_tract TEXT,
+            pickup_community_area INTEGER,
+            dropoff_community_area INTEGER,
+            fare FLOAT,
+            tip FLOAT,
+            additional_charges FLOAT,
+            trip_total FLOAT,
+            shared_trip_authorized BOOLEAN,
+            trips_pooled INTEGER,
+            pickup_centroid_latitude FLOAT,
+            pickup_centroid_longitude FLOAT,
+            pickup_centroid_location TEXT,
+            dropoff_centroid_latitude FLOAT,
+            dropoff_centroid_longitude FLOAT,
+            dropoff_centroid_location TEXT
+        );
+    `)
+	return err
+}
+
+var tnpInsertSQL = upsertSQL("tnp_trips", tnpColumns, "trip_id")
+
+func (tnpSchema) insertRecords(ctx context.Context, db *sql.DB, records []json.RawMessage) error {
+	rows := make([][]any, len(records))
+	for i, record := range records {
+		var trip tnpTrip
+		if err := json.Unmarshal(record, &trip); err != nil {
+			return fmt.Errorf("record %d: %w", i, err)
+		}
+		rows[i] = trip.values()
+	}
+	return insertRows(ctx, db, tnpInsertSQL, rows)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// otelServiceName is reported as the service.name of every span.
+const otelServiceName = "taxi-trips"
+
+// tracer exports spans to an OpenTelemetry collector as OTLP/HTTP JSON. A
+// nil tracer starts nil spans and every span method does nothing on nil, so
+// tracing costs nothing when -otel-endpoint is unset. Like StatsD, export
+// errors are logged and never stop an extraction.
+type tracer struct {
+	url    string
+	client *http.Client
+	spans  chan *span
+	done   chan struct{}
+}
+
+var otel *tracer
+
+// span is one timed operation. Spans are handed to the exporter when they
+// end.
+type span struct {
+	t        *tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	start    time.Time
+	end      time.Time
+
+	mu    sync.Mutex
+	attrs map[string]any
+	err   error
+}
+
+// newTracer starts the exporter for endpoint, the collector's base URL such
+// as http://localhost:4318.
+func newTracer(endpoint string) *tracer {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	t := &tracer{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		spans:  make(chan *span, 1024),
+		done:   make(chan struct{}),
+	}
+	go t.export()
+	return t
+}
+
+// start begins a span, a child of the span in ctx if there is one, and
+// returns a context carrying it.
+func (t *tracer) start(ctx context.Context, name string) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &span{t: t, name: name, start: time.Now(), attrs: make(map[string]any)}
+	if parent := spanFromContext(ctx); parent != nil {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+type spanKey struct{}
+
+func spanFromContext(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+// set records an attribute: a string, int or bool.
+func (s *span) set(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs[key] = value
+}
+
+// finish ends the span with an error status when err is set. Spans are
+// dropped rather than blocking when the exporter falls behind.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.end, s.err = time.Now(), err
+	s.mu.Unlock()
+	select {
+	case s.t.spans <- s:
+	default:
+	}
+}
+
+// close exports the spans still queued.
+func (t *tracer) close() {
+	if t == nil {
+		return
+	}
+	close(t.spans)
+	<-t.done
+}
+
+// export sends spans in batches of up to 100, or every 5 seconds.
+func (t *tracer) export() {
+	defer close(t.done)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	var pending []*span
+	for {
+		select {
+		case s, ok := <-t.spans:
+			if !ok {
+				t.send(pending)
+				return
+			}
+			if pending = append(pending, s); len(pending) >= 100 {
+				t.send(pending)
+				pending = nil
+			}
+		case <-ticker.C:
+			t.send(pending)
+			pending = nil
+		}
+	}
+}
+
+func (t *tracer) send(spans []*span) {
+	if len(spans) == 0 {
+		return
+	}
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		log.Printf("OTLP export: %v\n", err)
+		return
+	}
+	resp, err := t.client.Post(t.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("OTLP export: %v\n", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("OTLP export: unexpected status %s\n", resp.Status)
+	}
+}
+
+// otlpRequest renders spans as an OTLP ExportTraceServiceRequest in its JSON
+// encoding.
+func otlpRequest(spans []*span) map[string]any {
+	out := make([]map[string]any, len(spans))
+	for i, s := range spans {
+		s.mu.Lock()
+		attrs := make([]map[string]any, 0, len(s.attrs))
+		for k, v := range s.attrs {
+			attrs = append(attrs, otlpAttr(k, v))
+		}
+		status := map[string]any{"code": 1} // STATUS_CODE_OK
+		if s.err != nil {
+			status = map[string]any{"code": 2, "message": s.err.Error()}
+		}
+		out[i] = map[string]any{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              1, // SPAN_KIND_INTERNAL
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        attrs,
+			"status":            status,
+		}
+		if s.parentID != [8]byte{} {
+			out[i]["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		s.mu.Unlock()
+	}
+	return map[string]any{
+		"resourceSpans": []map[string]any{{
+			"resource": map[string]any{
+				"attributes": []map[string]any{otlpAttr("service.name", otelServiceName)},
+			},
+			"scopeSpans": []map[string]any{{
+				"scope": map[string]any{"name": otelServiceName},
+				"spans": out,
+			}},
+		}},
+	}
+}
+
+func otlpAttr(key string, value any) map[string]any {
+	var v map[string]any
+	switch value := value.(type) {
+	case int:
+		v = map[string]any{"intValue": strconv.Itoa(value)}
+	case bool:
+		v = map[string]any{"boolValue": value}
+	default:
+		v = map[string]any{"stringValue": fmt.Sprint(value)}
+	}
+	return map[string]any{"key": key, "value": v}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Escapes in JSON strings are decoded, not copied into the value.
+func TestUnmarshalEscapes(t *testing.T) {
+	record := `{"trip_id":"t\"1","company":"Caf\u00e9 \"Yellow\" Cab\n",` +
+		`"trip_start_timestamp":"2023\u002d01\u002d01T00:15:00.000",` +
+		`"trip_seconds":"\u0039\u0030","fare":"12\u002e5"}`
+	var trip data_fetched
+	if err := json.Unmarshal([]byte(record), &trip); err != nil {
+		t.Fatal(err)
+	}
+	if want := `t"1`; trip.TripID != want {
+		t.Errorf("trip_id = %q, want %q", trip.TripID, want)
+	}
+	if want := "Café \"Yellow\" Cab\n"; trip.Company != want {
+		t.Errorf("company = %q, want %q", trip.Company, want)
+	}
+	if want := time.Date(2023, 1, 1, 0, 15, 0, 0, time.UTC); !trip.TripStartTimestamp.Valid || !trip.TripStartTimestamp.Time.Equal(want) {
+		t.Errorf("trip_start_timestamp = %v, want %s", trip.TripStartTimestamp, want)
+	}
+	if !trip.TripSeconds.Valid || trip.TripSeconds.Int != 90 {
+		t.Errorf("trip_seconds = %+v, want 90", trip.TripSeconds)
+	}
+	if !trip.Fare.Valid || trip.Fare.Float64 != 12.5 {
+		t.Errorf("fare = %+v, want 12.5", trip.Fare)
+	}
+}
+
+func TestUnmarshalCustomTime(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    CustomTime
+		wantErr bool
+	}{
+		{`null`, CustomTime{}, false},
+		{`"2023-01-01T00:15:00.000"`, CustomTime{Time: time.Date(2023, 1, 1, 0, 15, 0, 0, time.UTC), Valid: true}, false},
+		{`"2023-01-01T00:15:00-06:00"`, CustomTime{Time: time.Date(2023, 1, 1, 6, 15, 0, 0, time.UTC), Valid: true, zoned: true}, false},
+		{`"yesterday"`, CustomTime{}, true},
+		{`20230101`, CustomTime{}, true},
+	}
+	for _, tt := range tests {
+		var got CustomTime
+		err := got.UnmarshalJSON([]byte(tt.in))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("UnmarshalJSON(%s) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got.Valid != tt.want.Valid || got.zoned != tt.want.zoned || !got.Time.Equal(tt.want.Time) {
+			t.Errorf("UnmarshalJSON(%s) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalCustomFloat64(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    CustomFloat64
+		wantErr bool
+	}{
+		{`"12.5"`, CustomFloat64{12.5, true}, false},
+		{`12.5`, CustomFloat64{12.5, true}, false},
+		{`"12"`, CustomFloat64{12, true}, false},
+		{`12`, CustomFloat64{12, true}, false},
+		{`-0.25`, CustomFloat64{-0.25, true}, false},
+		{`1e3`, CustomFloat64{1000, true}, false},
+		{`"0"`, CustomFloat64{0, true}, false},
+		{`null`, CustomFloat64{}, false},
+		{`""`, CustomFloat64{}, true},
+		{`"twelve"`, CustomFloat64{}, true},
+		{`true`, CustomFloat64{}, true},
+	}
+	for _, tt := range tests {
+		var got CustomFloat64
+		err := got.UnmarshalJSON([]byte(tt.in))
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("UnmarshalJSON(%s) = %+v, %v, want %+v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestUnmarshalCustomInt(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    CustomInt
+		wantErr bool
+	}{
+		{`"90"`, CustomInt{90, true}, false},
+		{`90`, CustomInt{90, true}, false},
+		{`"-3"`, CustomInt{-3, true}, false},
+		{`-3`, CustomInt{-3, true}, false},
+		{`"0"`, CustomInt{0, true}, false},
+		{`null`, CustomInt{}, false},
+		{`""`, CustomInt{}, true},
+		{`"ninety"`, CustomInt{}, true},
+		{`[90]`, CustomInt{}, true},
+	}
+	for _, tt := range tests {
+		var got CustomInt
+		err := got.UnmarshalJSON([]byte(tt.in))
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("UnmarshalJSON(%s) = %+v, %v, want %+v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestCoercion checks the values a dataset may send in a different type,
+// with and without -strict-types.
+func TestCoercion(t *testing.T) {
+	tests := []struct {
+		in      string
+		strict  bool
+		want    CustomInt
+		wantErr bool
+	}{
+		{`"123.0"`, false, CustomInt{123, true}, false},
+		{`123.0`, false, CustomInt{123, true}, false},
+		{`"123.7"`, false, CustomInt{123, true}, false},
+		{`"-2.5"`, false, CustomInt{-2, true}, false},
+		{`"1e20"`, false, CustomInt{}, true}, // out of range
+		{`"123.0"`, true, CustomInt{}, true},
+		{`123.0`, true, CustomInt{}, true},
+		{`"123"`, true, CustomInt{123, true}, false},
+		{`123`, true, CustomInt{123, true}, false},
+	}
+	for _, tt := range tests {
+		withConfig(t, func(c *config) { c.StrictTypes = tt.strict })
+		var got CustomInt
+		err := got.UnmarshalJSON([]byte(tt.in))
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("CustomInt from %s with strict %v = %+v, %v, want %+v, error %v", tt.in, tt.strict, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	// Integers are valid floats in either mode.
+	for _, strict := range []bool{false, true} {
+		withConfig(t, func(c *config) { c.StrictTypes = strict })
+		for _, in := range []string{`"12"`, `12`} {
+			var got CustomFloat64
+			if err := got.UnmarshalJSON([]byte(in)); err != nil || got != (CustomFloat64{12, true}) {
+				t.Errorf("CustomFloat64 from %s with strict %v = %+v, %v, want 12", in, strict, got, err)
+			}
+		}
+	}
+}
+
+// TestCoerceFieldInteger checks that -fields-file integers are coerced the
+// same way as CustomInt.
+func TestCoerceFieldInteger(t *testing.T) {
+	tests := []struct {
+		in      string
+		strict  bool
+		want    int64
+		wantErr bool
+	}{
+		{`"7"`, false, 7, false},
+		{`7`, true, 7, false},
+		{`"7.0"`, false, 7, false},
+		{`7.9`, false, 7, false},
+		{`"7.0"`, true, 0, true},
+	}
+	for _, tt := range tests {
+		withConfig(t, func(c *config) { c.StrictTypes = tt.strict })
+		got, err := coerceField("integer", []byte(tt.in))
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("coerceField(integer, %s) with strict %v = %v, %v, want %d, error %v", tt.in, tt.strict, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// tnpRecord is a rideshare trip as the TNP dataset returns it: no taxi or
+// payment fields, and shared_trip_authorized as a JSON boolean.
+const tnpRecord = `{"trip_id":"000a4b7e5f3ab5b5cf5d9cd9a0c8bb1f99e4d12c",` +
+	`"trip_start_timestamp":"2023-01-01T00:15:00.000","trip_end_timestamp":"2023-01-01T00:30:00.000",` +
+	`"trip_seconds":"1020","trip_miles":"4.6",` +
+	`"pickup_census_tract":"17031081500","pickup_community_area":"8","dropoff_community_area":"32",` +
+	`"fare":"12.5","tip":"2","additional_charges":"3.06","trip_total":"17.56",` +
+	`"shared_trip_authorized":false,"trips_pooled":"1",` +
+	`"pickup_centroid_latitude":"41.892507781","pickup_centroid_longitude":"-87.626214906",` +
+	`"pickup_centroid_location":{"type":"Point","coordinates":[-87.6262149064,41.8925077809]},` +
+	`"dropoff_centroid_latitude":"41.878865584","dropoff_centroid_longitude":"-87.625192142",` +
+	`"dropoff_centroid_location":{"type":"Point","coordinates":[-87.6251921424,41.8788655841]}}`
+
+func TestUnmarshalTNPTrip(t *testing.T) {
+	var trip tnpTrip
+	if err := json.Unmarshal([]byte(tnpRecord), &trip); err != nil {
+		t.Fatal(err)
+	}
+	want := []any{
+		"000a4b7e5f3ab5b5cf5d9cd9a0c8bb1f99e4d12c",
+		time.Date(2023, 1, 1, 0, 15, 0, 0, time.UTC), time.Date(2023, 1, 1, 0, 30, 0, 0, time.UTC), 1020, 4.6,
+		"17031081500", "", 8, 32,
+		12.5, 2.0, 3.06, 17.56, false, 1,
+		41.892507781, -87.626214906, "POINT(-87.6262149064 41.8925077809)",
+		41.878865584, -87.625192142, "POINT(-87.6251921424 41.8788655841)",
+	}
+	got := trip.values()
+	if len(got) != len(tnpColumns) {
+		t.Fatalf("values returned %d values for %d columns", len(got), len(tnpColumns))
+	}
+	for i, column := range tnpColumns {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("%s = %#v, want %#v", column, got[i], want[i])
+		}
+	}
+
+	var shared tnpTrip
+	if err := json.Unmarshal([]byte(`{"trip_id":"t","shared_trip_authorized":true}`), &shared); err != nil {
+		t.Fatal(err)
+	}
+	if !shared.SharedTripAuthorized {
+		t.Error("shared_trip_authorized true decoded as false")
+	}
+}
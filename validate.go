@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// totalTolerance is how far trip_total may differ from the sum of its
+// components before a trip is flagged.
+const totalTolerance = 0.01
+
+// validateTrip returns the reasons a trip looks wrong, or nil when it
+// passes every check.
+func validateTrip(t data_fetched) []string {
+	var reasons []string
+	if t.TripID == "" {
+		reasons = append(reasons, "missing trip_id")
+	}
+	start, end := t.TripStartTimestamp.Time, t.TripEndTimestamp.Time
+	if !start.IsZero() && !end.IsZero() && end.Before(start) {
+		reasons = append(reasons, "trip ends before it starts")
+	}
+	if t.TripSeconds.Int < 0 {
+		reasons = append(reasons, "negative trip_seconds")
+	}
+	if t.TripMiles.Float64 < 0 {
+		reasons = append(reasons, "negative trip_miles")
+	}
+	if t.Fare.Float64 < 0 {
+		reasons = append(reasons, "negative fare")
+	}
+	components := t.Fare.Float64 + t.Tips.Float64 + t.Tolls.Float64 + t.Extras.Float64
+	if t.TripTotal.Float64 != 0 && math.Abs(t.TripTotal.Float64-components) > totalTolerance {
+		reasons = append(reasons, "trip_total does not match fare + tips + tolls + extras")
+	}
+	return reasons
+}
+
+// dbChecks are the -validate-only checks over taxi_trips: the invariants of
+// validateTrip as SQL conditions matching the rows that break them.
+var dbChecks = []struct {
+	name, cond string
+}{
+	{"missing_keys", "trip_id IS NULL OR trip_id = '' OR taxi_id IS NULL OR trip_start_timestamp IS NULL"},
+	{"end_before_start", "trip_end_timestamp < trip_start_timestamp"},
+	{"negative_seconds", "trip_seconds < 0"},
+	{"negative_miles", "trip_miles < 0"},
+	{"negative_fare", "fare < 0"},
+	{"total_mismatch", fmt.Sprintf("trip_total <> 0 AND abs(trip_total - (COALESCE(fare, 0) + COALESCE(tips, 0) + "+
+		"COALESCE(tolls, 0) + COALESCE(extras, 0))) > %g", totalTolerance)},
+}
+
+// parseThresholds parses a -validate-thresholds list such as
+// "total_mismatch=100,negative_fare=0". Checks not listed allow no
+// violations.
+func parseThresholds(s string) (map[string]int, error) {
+	known := make(map[string]bool, len(dbChecks))
+	for _, c := range dbChecks {
+		known[c.name] = true
+	}
+	thresholds := make(map[string]int)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, n, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if !ok {
+			return nil, fmt.Errorf("%q is not check=count", item)
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("unknown check %q", name)
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(n))
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("%s: threshold must be a non-negative integer, got %q", name, n)
+		}
+		thresholds[name] = limit
+	}
+	return thresholds, nil
+}
+
+// validateDB runs every check over taxi_trips, prints how many rows break
+// each one and reports whether all counts are within their thresholds.
+func validateDB(ctx context.Context, db *sql.DB, w io.Writer) (bool, error) {
+	table := newTable(w, []string{"Check", "Violations", "Threshold", "Result"})
+	passed := true
+	for _, c := range dbChecks {
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT count(*) FROM taxi_trips WHERE "+c.cond).Scan(&n); err != nil {
+			return false, fmt.Errorf("%s: %w", c.name, err)
+		}
+		limit := cfg.ValidateMax[c.name]
+		result := "ok"
+		if n > limit {
+			result, passed = "FAIL", false
+		}
+		appendResult(table, w, []string{c.name, strconv.Itoa(n), strconv.Itoa(limit), result}, n > limit)
+	}
+	table.Render()
+	return passed, nil
+}